@@ -11,6 +11,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/SkyAPM/go2sky"
@@ -18,6 +19,12 @@ import (
 	dapr "github.com/dapr/go-sdk/client"
 	"github.com/dapr/go-sdk/service/common"
 	"github.com/go-chi/chi/v5"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/grpc/metadata"
 	"k8s.io/klog/v2"
 	agentv3 "skywalking.apache.org/repo/goapi/collect/language/agent/v3"
 )
@@ -39,31 +46,41 @@ var (
 )
 
 const (
-	TestModeEnvName                           = "TEST_MODE"
-	FunctionContextEnvName                    = "FUNC_CONTEXT"
-	PodNameEnvName                            = "POD_NAME"
-	PodNamespaceEnvName                       = "POD_NAMESPACE"
-	ModeEnvName                               = "CONTEXT_MODE"
-	Async                        Runtime      = "Async"
-	Knative                      Runtime      = "Knative"
-	OpenFuncBinding              ResourceType = "bindings"
-	OpenFuncTopic                ResourceType = "pubsub"
-	Success                                   = 200
-	InternalError                             = 500
-	defaultPort                               = "8080"
-	defaultHttpPattern                        = "/"
-	defaultDaprHost                           = "127.0.0.1"
-	defaultDaprGRPCPort                       = "50001"
-	TracingProviderSkywalking                 = "skywalking"
-	TracingProviderOpentelemetry              = "opentelemetry"
-	KubernetesMode                            = "kubernetes"
-	SelfHostMode                              = "self-host"
-	TestModeOn                                = "on"
-	innerEventTypePrefix                      = "io.openfunction.function"
-	tracingProviderSkywalking                 = "skywalking"
-	RawData                                   = Option("RawData") // This option controls the Send() function to send raw data
+	TestModeEnvName                               = "TEST_MODE"
+	FunctionContextEnvName                        = "FUNC_CONTEXT"
+	PodNameEnvName                                = "POD_NAME"
+	PodNamespaceEnvName                           = "POD_NAMESPACE"
+	ModeEnvName                                   = "CONTEXT_MODE"
+	Async                            Runtime      = "Async"
+	Knative                          Runtime      = "Knative"
+	OpenFuncBinding                  ResourceType = "bindings"
+	OpenFuncTopic                    ResourceType = "pubsub"
+	OpenFuncState                    ResourceType = "state"
+	OpenFuncSecret                   ResourceType = "secretstores"
+	OpenFuncInvoke                   ResourceType = "invoke"
+	Success                                       = 200
+	InternalError                                 = 500
+	defaultPort                                   = "8080"
+	defaultHttpPattern                            = "/"
+	defaultDaprHost                               = "127.0.0.1"
+	defaultDaprGRPCPort                           = "50001"
+	defaultShutdownTimeout                        = 30 * time.Second
+	PanicPolicyDrop                               = "drop"
+	PanicPolicyRetry                              = "retry"
+	TracingProviderSkywalking                     = "skywalking"
+	TracingProviderOpentelemetry                  = "opentelemetry"
+	KubernetesMode                                = "kubernetes"
+	SelfHostMode                                  = "self-host"
+	TestModeOn                                    = "on"
+	innerEventTypePrefix                          = "io.openfunction.function"
+	tracingProviderSkywalking                     = "skywalking"
+	OpenTelemetryInstrumentationName              = "github.com/OpenFunction/functions-framework-go"
+	RawData                                       = Option("RawData") // This option controls the Send() function to send raw data
 )
 
+// errDaprClientNotInitialized is returned by the building blocks called without a dapr client.
+var errDaprClientNotInitialized = errors.New("dapr client is not initialized")
+
 type Runtime string
 type ResourceType string
 type Option string
@@ -104,6 +121,16 @@ type RuntimeContext interface {
 	// DestroyDaprClient destroys the dapr client when the function is executed with an exception.
 	DestroyDaprClient()
 
+	// SetDaprClient replaces the dapr client of the function, e.g. with a fake client in tests,
+	// and resolves the secret references in the metadata with it.
+	SetDaprClient(client dapr.Client) error
+
+	// TrackInFlight counts the invocation as in-flight until the returned function is called.
+	TrackInFlight() (done func())
+
+	// GetInFlight returns the number of the in-flight invocations of the function, e.g. to be exported as a metric.
+	GetInFlight() int64
+
 	// GetPrePlugins returns a list of plugin names for the previous phase of function execution.
 	GetPrePlugins() []string
 
@@ -122,6 +149,15 @@ type RuntimeContext interface {
 	// GetHttpPattern returns the path of the server listening in Knative runtime mode.
 	GetHttpPattern() string
 
+	// GetShutdownTimeout returns how long the function is allowed to drain in-flight invocations on shutdown.
+	GetShutdownTimeout() time.Duration
+
+	// GetTimeout returns the default timeout of the invocations, zero means no timeout.
+	GetTimeout() time.Duration
+
+	// GetPanicDisposition returns whether the event of the async runtime is retried or dropped when the function panics.
+	GetPanicDisposition() Disposition
+
 	// SetSyncRequest sets the native http.ResponseWriter and *http.Request when an http request is received.
 	SetSyncRequest(w http.ResponseWriter, r *http.Request)
 
@@ -134,6 +170,15 @@ type RuntimeContext interface {
 	// GetOutputs returns the mapping relationship of *Output.
 	GetOutputs() map[string]*Output
 
+	// GetStates returns the state stores of the function.
+	GetStates() map[string]*StateStore
+
+	// GetSecretStores returns the secret stores of the function.
+	GetSecretStores() map[string]*SecretStore
+
+	// HasDaprComponents detects if the function uses any Dapr component, which requires the dapr client.
+	HasDaprComponents() bool
+
 	// GetSyncRequest returns the pointer of SyncRequest.
 	GetSyncRequest() *SyncRequest
 
@@ -143,6 +188,9 @@ type RuntimeContext interface {
 	// GetTopicEvent returns the pointer of common.TopicEvent.
 	GetTopicEvent() *common.TopicEvent
 
+	// GetInvocationEvent returns the pointer of common.InvocationEvent.
+	GetInvocationEvent() *common.InvocationEvent
+
 	// GetCloudEvent returns the pointer of v2.Event.
 	GetCloudEvent() *cloudevents.Event
 
@@ -171,8 +219,51 @@ type RuntimeContext interface {
 type Context interface {
 	NativeContext
 
-	// Send provides the ability to allow the user to send data to a specified output target.
-	Send(outputName string, data []byte) ([]byte, error)
+	// Send provides the ability to allow the user to send data to a specified output target,
+	// the options override the metadata, the operation and the content type of a single call.
+	Send(outputName string, data []byte, opts ...SendOption) ([]byte, error)
+
+	// SendWithContext is Send bound to ctx, the call to the output is cancelled when ctx is done.
+	// Send uses the native context of the invocation, so it follows the timeout and the cancellation of the request.
+	SendWithContext(ctx context.Context, outputName string, data []byte, opts ...SendOption) ([]byte, error)
+
+	// SendCloudEvent sends the CloudEvent built by the user to the output, the knative transport and the http
+	// transport in CloudEvent mode send it as it is, and the other transports send it in the structured format.
+	SendCloudEvent(ctx context.Context, outputName string, event cloudevents.Event, opts ...SendOption) ([]byte, error)
+
+	// Invoke calls the method of the app of a service invocation output through Dapr, and returns its response.
+	// The failure of the app is returned as an error created by NewHTTPError with the status code of the app.
+	Invoke(outputName string, data []byte, opts ...InvokeOption) (*InvokeResponse, error)
+
+	// InvokeWithContext is Invoke bound to ctx.
+	InvokeWithContext(ctx context.Context, outputName string, data []byte, opts ...InvokeOption) (*InvokeResponse, error)
+
+	// GetState returns the item of key in the state store, the value of the item is empty if the key does not exist.
+	GetState(storeName, key string, opts ...StateOption) (*StateItem, error)
+
+	// SaveState saves the value of key in the state store.
+	SaveState(storeName, key string, value []byte, opts ...StateOption) error
+
+	// DeleteState deletes key from the state store.
+	DeleteState(storeName, key string, opts ...StateOption) error
+
+	// GetBulkState returns the items of the keys in the state store.
+	GetBulkState(storeName string, keys []string, opts ...StateOption) ([]*BulkStateItem, error)
+
+	// SaveBulkState saves the items in the state store, each item is saved only if its ETag matches.
+	SaveBulkState(storeName string, items ...*SetStateItem) error
+
+	// DeleteBulkState deletes the items from the state store, each item is deleted only if its ETag matches.
+	DeleteBulkState(storeName string, items ...*DeleteStateItem) error
+
+	// ExecuteStateTransaction executes the upsert and delete operations in a transaction of the state store.
+	ExecuteStateTransaction(storeName string, ops []*StateOperation, opts ...StateOption) error
+
+	// GetSecret returns the values of the secret in the secret store.
+	GetSecret(storeName, key string) (map[string]string, error)
+
+	// GetBulkSecret returns all the secrets in the secret store.
+	GetBulkSecret(storeName string) (map[string]map[string]string, error)
 
 	// ReturnOnSuccess returns the Out with a success state.
 	ReturnOnSuccess() Out
@@ -189,6 +280,9 @@ type Context interface {
 	// GetTopicEvent returns the pointer of common.TopicEvent.
 	GetTopicEvent() *common.TopicEvent
 
+	// GetInvocationEvent returns the pointer of common.InvocationEvent.
+	GetInvocationEvent() *common.InvocationEvent
+
 	// GetCloudEvent returns the pointer of v2.Event.
 	GetCloudEvent() *cloudevents.Event
 
@@ -223,6 +317,18 @@ type Out interface {
 
 	// WithData sets the FunctionOut with new return data.
 	WithData(data []byte) *FunctionOut
+
+	// GetHeader returns the response headers in FunctionOut.
+	GetHeader() http.Header
+
+	// GetContentType returns the content type of the return data in FunctionOut.
+	GetContentType() string
+
+	// WithHeader adds a response header to the FunctionOut, e.g. the `Location` of a redirect.
+	WithHeader(key, value string) *FunctionOut
+
+	// WithContentType sets the content type of the return data in FunctionOut.
+	WithContentType(contentType string) *FunctionOut
 }
 
 type TracingConfig interface {
@@ -249,37 +355,48 @@ type ContextOption interface {
 }
 
 type FunctionContext struct {
-	mu             sync.Mutex
-	Name           string             `json:"name"`
-	Version        string             `json:"version"`
-	RequestID      string             `json:"requestID,omitempty"`
-	Ctx            context.Context    `json:"ctx,omitempty"`
-	Inputs         map[string]*Input  `json:"inputs,omitempty"`
-	Outputs        map[string]*Output `json:"outputs,omitempty"`
-	Runtime        Runtime            `json:"runtime"`
-	Port           string             `json:"port,omitempty"`
-	State          interface{}        `json:"state,omitempty"`
-	Event          *EventRequest      `json:"event,omitempty"`
-	SyncRequest    *SyncRequest       `json:"syncRequest,omitempty"`
-	PrePlugins     []string           `json:"prePlugins,omitempty"`
-	PostPlugins    []string           `json:"postPlugins,omitempty"`
-	PluginsTracing *PluginsTracing    `json:"pluginsTracing,omitempty"`
-	Out            Out                `json:"out,omitempty"`
-	Error          error              `json:"error,omitempty"`
-	HttpPattern    string             `json:"httpPattern,omitempty"`
-	podName        string
-	podNamespace   string
-	daprClient     dapr.Client
-	mode           string
-	options        map[Option]string
+	mu        sync.Mutex
+	Name      string             `json:"name"`
+	Version   string             `json:"version"`
+	RequestID string             `json:"requestID,omitempty"`
+	Ctx       context.Context    `json:"ctx,omitempty"`
+	Inputs    map[string]*Input  `json:"inputs,omitempty"`
+	Outputs   map[string]*Output `json:"outputs,omitempty"`
+	Runtime   Runtime            `json:"runtime"`
+	Port      string             `json:"port,omitempty"`
+	// Deprecated: State has no behavior, use the state stores declared in States.
+	State           interface{}                `json:"state,omitempty"`
+	States          map[string]*StateStore     `json:"states,omitempty"`
+	SecretStores    map[string]*SecretStore    `json:"secretStores,omitempty"`
+	Event           *EventRequest              `json:"event,omitempty"`
+	SyncRequest     *SyncRequest               `json:"syncRequest,omitempty"`
+	PrePlugins      []string                   `json:"prePlugins,omitempty"`
+	PostPlugins     []string                   `json:"postPlugins,omitempty"`
+	PluginsTracing  *PluginsTracing            `json:"pluginsTracing,omitempty"`
+	Out             Out                        `json:"out,omitempty"`
+	Error           error                      `json:"error,omitempty"`
+	HttpPattern     string                     `json:"httpPattern,omitempty"`
+	ShutdownTimeout string                     `json:"shutdownTimeout,omitempty"`
+	PanicPolicy     string                     `json:"panicPolicy,omitempty"`
+	Timeout         string                     `json:"timeout,omitempty"`
+	Functions       map[string]json.RawMessage `json:"functions,omitempty"`
+	shutdownTimeout time.Duration
+	timeout         time.Duration
+	podName         string
+	podNamespace    string
+	daprClient      dapr.Client
+	mode            string
+	options         map[Option]string
+	inFlight        *int64
 }
 
 type EventRequest struct {
-	InputName    string               `json:"inputName,omitempty"`
-	BindingEvent *common.BindingEvent `json:"bindingEvent,omitempty"`
-	TopicEvent   *common.TopicEvent   `json:"topicEvent,omitempty"`
-	CloudEvent   *cloudevents.Event   `json:"cloudEventnt,omitempty"`
-	innerEvent   InnerEvent
+	InputName       string                  `json:"inputName,omitempty"`
+	BindingEvent    *common.BindingEvent    `json:"bindingEvent,omitempty"`
+	TopicEvent      *common.TopicEvent      `json:"topicEvent,omitempty"`
+	InvocationEvent *common.InvocationEvent `json:"invocationEvent,omitempty"`
+	CloudEvent      *cloudevents.Event      `json:"cloudEventnt,omitempty"`
+	innerEvent      InnerEvent
 }
 
 type SyncRequest struct {
@@ -292,6 +409,11 @@ type Input struct {
 	ComponentName string            `json:"componentName"`
 	ComponentType string            `json:"componentType"`
 	Metadata      map[string]string `json:"metadata,omitempty"`
+	// DeadLetter names the output the failed events of the input are forwarded to.
+	DeadLetter string `json:"deadLetter,omitempty"`
+	// MaxDeliveryAttempts is the number of failed deliveries of an event before it is forwarded
+	// to the dead letter, the events that will not be redelivered are forwarded at once.
+	MaxDeliveryAttempts int `json:"maxDeliveryAttempts,omitempty"`
 }
 
 // GetType will be called after the context has been parsed correctly,
@@ -307,6 +429,16 @@ type Output struct {
 	ComponentType string            `json:"componentType"`
 	Metadata      map[string]string `json:"metadata,omitempty"`
 	Operation     string            `json:"operation,omitempty"`
+	Resiliency    *ResiliencyPolicy `json:"resiliency,omitempty"`
+	// AppID, Method and Verb are the target of a service invocation output, the method is the uri by default.
+	AppID  string `json:"appID,omitempty"`
+	Method string `json:"method,omitempty"`
+	Verb   string `json:"verb,omitempty"`
+	// Transport selects the backend sending the data to the output, which is dapr by default.
+	// The componentName and componentType are not required by the transports other than dapr.
+	Transport string `json:"transport,omitempty"`
+	// CloudEventMode sends the data of an http transport as a CloudEvent, in binary or structured mode.
+	CloudEventMode string `json:"cloudEventMode,omitempty"`
 }
 
 // GetType will be called after the context has been parsed correctly,
@@ -322,6 +454,9 @@ type FunctionOut struct {
 	Data     []byte            `json:"data,omitempty"`
 	Error    error             `json:"error,omitempty"`
 	Metadata map[string]string `json:"metadata,omitempty"`
+	// Header is written to the response of the sync request
+	Header      http.Header `json:"header,omitempty"`
+	ContentType string      `json:"contentType,omitempty"`
 }
 
 type PluginsTracing struct {
@@ -339,6 +474,7 @@ type TracingProvider struct {
 type ResponseWriterWrapper struct {
 	http.ResponseWriter
 	statusCode int
+	written    bool
 }
 
 func (rww *ResponseWriterWrapper) Status() int {
@@ -350,29 +486,56 @@ func (rww *ResponseWriterWrapper) Header() http.Header {
 }
 
 func (rww *ResponseWriterWrapper) Write(bytes []byte) (int, error) {
+	rww.written = true
 	return rww.ResponseWriter.Write(bytes)
 }
 
 func (rww *ResponseWriterWrapper) WriteHeader(statusCode int) {
 	rww.statusCode = statusCode
+	rww.written = true
 	rww.ResponseWriter.WriteHeader(statusCode)
 }
 
+// Written reports whether the response has been started, so that it cannot be replaced anymore.
+func (rww *ResponseWriterWrapper) Written() bool {
+	return rww.written
+}
+
 func NewResponseWriterWrapper(w http.ResponseWriter, statusCode int) *ResponseWriterWrapper {
 	return &ResponseWriterWrapper{
-		w,
-		statusCode,
+		ResponseWriter: w,
+		statusCode:     statusCode,
+	}
+}
+
+func (ctx *FunctionContext) Send(outputName string, data []byte, opts ...SendOption) ([]byte, error) {
+	nativeContext := ctx.GetNativeContext()
+	if nativeContext == nil {
+		nativeContext = context.Background()
+	}
+	return ctx.SendWithContext(nativeContext, outputName, data, opts...)
+}
+
+func (ctx *FunctionContext) SendWithContext(c context.Context, outputName string, data []byte, opts ...SendOption) ([]byte, error) {
+	return ctx.send(c, outputName, data, nil, opts...)
+}
+
+func (ctx *FunctionContext) SendCloudEvent(c context.Context, outputName string, event cloudevents.Event, opts ...SendOption) ([]byte, error) {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return nil, err
 	}
+	return ctx.send(c, outputName, data, &event, opts...)
 }
 
-func (ctx *FunctionContext) Send(outputName string, data []byte) ([]byte, error) {
+func (ctx *FunctionContext) send(c context.Context, outputName string, data []byte, event *cloudevents.Event, opts ...SendOption) ([]byte, error) {
 	if !ctx.HasOutputs() {
 		return nil, errors.New("no output")
 	}
 
 	var err error
 	var output *Output
-	var response *dapr.BindingEvent
+	var response []byte
 	var payload []byte
 
 	if v, ok := ctx.Outputs[outputName]; ok {
@@ -381,42 +544,82 @@ func (ctx *FunctionContext) Send(outputName string, data []byte) ([]byte, error)
 		return nil, fmt.Errorf("output %s not found", outputName)
 	}
 
-	payload = data
+	transport, ok := getTransport(output.Transport)
+	if !ok {
+		return nil, fmt.Errorf("transport %s of output %s is not registered", output.Transport, outputName)
+	}
+	if output.usesDapr() && ctx.daprClient == nil {
+		return nil, errDaprClientNotInitialized
+	}
 
-	if IsTracingProviderSkyWalking(ctx) && traceable(output.ComponentType) && !ctx.IsRawDataEnabled() {
-		ie := NewInnerEvent(ctx)
-		ie.MergeMetadata(ctx.GetInnerEvent())
-		ie.SetUserData(data)
+	options := newSendOptions(opts...)
+	if event != nil && options.contentType == "" {
+		options.contentType = cloudevents.ApplicationCloudEventsJSON
+	}
+	if output.GetType() == OpenFuncInvoke {
+		resp, err := ctx.InvokeWithContext(c, outputName, data, options.invokeOptions()...)
+		if resp != nil {
+			return resp.Data, err
+		}
+		return nil, err
+	}
+	target := options.apply(output)
+	payload = data
+	contentType := options.contentType
+	start := time.Now()
+
+	endSpan := func(error) {}
+	if output.usesDapr() {
+		if (IsTracingProviderSkyWalking(ctx) || IsTracingProviderOpenTelemetry(ctx)) && traceable(output.ComponentType) && !ctx.IsRawDataEnabled() && event == nil {
+			ie := NewInnerEvent(ctx)
+			ie.MergeMetadata(ctx.GetInnerEvent())
+			ie.SetUserData(data)
+
+			// Set the exit span for tracing
+			if endSpan, err = setExitSpan(ctx, c, innerEventCarrier{ie}, outputName); err != nil {
+				klog.Warningf("failed to set exit span: %v", err)
+			}
 
-		// Set the exit span for tracing
-		if err := setExitSpan(ctx, ie, outputName); err != nil {
+			payload = ie.GetCloudEventJSON()
+			// the content type of the data is not the one of the InnerEvent wrapping it
+			if contentType != "" {
+				contentType = "application/json"
+			}
+			if _, ok := target.Metadata[contentTypeMetadataKey]; ok {
+				target.Metadata[contentTypeMetadataKey] = "application/json"
+			}
+		}
+	} else {
+		// the other transports propagate the trace context through the metadata of the output
+		if target.Metadata == nil {
+			target.Metadata = map[string]string{}
+		}
+		if endSpan, err = setExitSpan(ctx, c, propagation.MapCarrier(target.Metadata), outputName); err != nil {
 			klog.Warningf("failed to set exit span: %v", err)
 		}
-
-		payload = ie.GetCloudEventJSON()
 	}
 
-	switch output.GetType() {
-	case OpenFuncTopic:
-		err = ctx.daprClient.PublishEvent(context.Background(), output.ComponentName, output.Uri, payload)
-	case OpenFuncBinding:
-		in := &dapr.InvokeBindingRequest{
-			Name:      output.ComponentName,
-			Operation: output.Operation,
-			Data:      payload,
-			Metadata:  output.Metadata,
-		}
-		response, err = ctx.daprClient.InvokeBinding(context.Background(), in)
+	req := &OutputRequest{
+		Context:     ctx,
+		OutputName:  outputName,
+		Output:      target,
+		Data:        payload,
+		ContentType: contentType,
+		Event:       event,
 	}
+	err = callWithResiliency(c, ctx, outputName, target, func(c context.Context) error {
+		var err error
+		response, err = transport.Send(c, req)
+		return err
+	})
+	endSpan(err)
+
+	notifySendObservers(ctx, outputName, target, time.Since(start), err)
 
 	if err != nil {
 		return nil, err
 	}
-
-	if response != nil {
-		return response.Data, nil
-	}
-	return nil, nil
+	return response, nil
 }
 
 func (ctx *FunctionContext) GetDaprClient() dapr.Client {
@@ -437,6 +640,18 @@ func (ctx *FunctionContext) HasOutputs() bool {
 	return false
 }
 
+func (ctx *FunctionContext) HasDaprComponents() bool {
+	if ctx.HasInputs() || len(ctx.GetStates()) > 0 || len(ctx.GetSecretStores()) > 0 {
+		return true
+	}
+	for _, out := range ctx.GetOutputs() {
+		if out.usesDapr() {
+			return true
+		}
+	}
+	return false
+}
+
 func (ctx *FunctionContext) ReturnOnSuccess() Out {
 	return &FunctionOut{
 		Code: Success,
@@ -474,9 +689,39 @@ func (ctx *FunctionContext) InitDaprClientIfNil() {
 			klog.Errorf("failed to init dapr client: %v", err)
 			panic(err)
 		}
+
+		// the secrets are resolved once the client is connected
+		if err := ctx.resolveSecretRefs(); err != nil {
+			klog.Errorf("failed to resolve secrets: %v", err)
+			panic(err)
+		}
 	}
 }
 
+func (ctx *FunctionContext) TrackInFlight() func() {
+	if ctx.inFlight == nil {
+		return func() {}
+	}
+	atomic.AddInt64(ctx.inFlight, 1)
+	return func() {
+		atomic.AddInt64(ctx.inFlight, -1)
+	}
+}
+
+func (ctx *FunctionContext) GetInFlight() int64 {
+	if ctx.inFlight == nil {
+		return 0
+	}
+	return atomic.LoadInt64(ctx.inFlight)
+}
+
+func (ctx *FunctionContext) SetDaprClient(client dapr.Client) error {
+	ctx.mu.Lock()
+	defer ctx.mu.Unlock()
+	ctx.daprClient = client
+	return ctx.resolveSecretRefs()
+}
+
 func (ctx *FunctionContext) DestroyDaprClient() {
 	if testMode := os.Getenv(TestModeEnvName); testMode == TestModeOn {
 		return
@@ -510,6 +755,21 @@ func (ctx *FunctionContext) GetHttpPattern() string {
 	return ctx.HttpPattern
 }
 
+func (ctx *FunctionContext) GetShutdownTimeout() time.Duration {
+	return ctx.shutdownTimeout
+}
+
+func (ctx *FunctionContext) GetTimeout() time.Duration {
+	return ctx.timeout
+}
+
+func (ctx *FunctionContext) GetPanicDisposition() Disposition {
+	if ctx.PanicPolicy == PanicPolicyRetry {
+		return DispositionRetry
+	}
+	return DispositionDrop
+}
+
 func (ctx *FunctionContext) GetError() error {
 	return ctx.Error
 }
@@ -540,26 +800,41 @@ func (ctx *FunctionContext) SetEvent(inputName string, event interface{}) {
 	case *common.BindingEvent:
 		be := event.(*common.BindingEvent)
 		ie := convertEvent(ctx, inputName, be.Data)
-		ctx.setEvent(inputName, be, nil, nil, ie)
+		ctx.setEvent(inputName, be, nil, nil, nil, ie)
 	case *common.TopicEvent:
 		te := event.(*common.TopicEvent)
 		ie := convertEvent(ctx, inputName, ConvertUserDataToBytes(te.Data))
-		ctx.setEvent(inputName, nil, te, nil, ie)
+		ctx.setEvent(inputName, nil, te, nil, nil, ie)
+	case *common.InvocationEvent:
+		ve := event.(*common.InvocationEvent)
+		ie := convertEvent(ctx, inputName, ve.Data)
+		// the headers of the invocation carry the trace context of the caller
+		if c := ctx.GetNativeContext(); c != nil {
+			if md, ok := metadata.FromIncomingContext(c); ok {
+				for k, v := range md {
+					if len(v) > 0 && !strings.HasPrefix(k, ":") {
+						ie.SetMetadata(k, v[0])
+					}
+				}
+			}
+		}
+		ctx.setEvent(inputName, nil, nil, ve, nil, ie)
 	case *cloudevents.Event:
 		ce := event.(*cloudevents.Event)
 		ie := convertEvent(ctx, inputName, ce.Data())
-		ctx.setEvent(inputName, nil, nil, ce, ie)
+		ctx.setEvent(inputName, nil, nil, nil, ce, ie)
 	default:
 		klog.Errorf("failed to resolve event type: %v", t)
 	}
 }
 
-func (ctx *FunctionContext) setEvent(name string, be *common.BindingEvent, te *common.TopicEvent, ce *cloudevents.Event, ie InnerEvent) {
+func (ctx *FunctionContext) setEvent(name string, be *common.BindingEvent, te *common.TopicEvent, ve *common.InvocationEvent, ce *cloudevents.Event, ie InnerEvent) {
 	ctx.mu.Lock()
 	defer ctx.mu.Unlock()
 	ctx.Event.InputName = name
 	ctx.Event.BindingEvent = be
 	ctx.Event.TopicEvent = te
+	ctx.Event.InvocationEvent = ve
 	ctx.Event.CloudEvent = ce
 	ctx.Event.innerEvent = ie
 }
@@ -580,6 +855,14 @@ func (ctx *FunctionContext) GetOutputs() map[string]*Output {
 	return ctx.Outputs
 }
 
+func (ctx *FunctionContext) GetStates() map[string]*StateStore {
+	return ctx.States
+}
+
+func (ctx *FunctionContext) GetSecretStores() map[string]*SecretStore {
+	return ctx.SecretStores
+}
+
 func (ctx *FunctionContext) GetPodName() string {
 	return ctx.podName
 }
@@ -600,6 +883,10 @@ func (ctx *FunctionContext) GetTopicEvent() *common.TopicEvent {
 	return ctx.Event.TopicEvent
 }
 
+func (ctx *FunctionContext) GetInvocationEvent() *common.InvocationEvent {
+	return ctx.Event.InvocationEvent
+}
+
 func (ctx *FunctionContext) GetCloudEvent() *cloudevents.Event {
 	return ctx.Event.CloudEvent
 }
@@ -699,6 +986,31 @@ func (o *FunctionOut) WithData(data []byte) *FunctionOut {
 	return o
 }
 
+func (o *FunctionOut) GetHeader() http.Header {
+	return o.Header
+}
+
+func (o *FunctionOut) GetContentType() string {
+	return o.ContentType
+}
+
+func (o *FunctionOut) WithHeader(key, value string) *FunctionOut {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.Header == nil {
+		o.Header = http.Header{}
+	}
+	o.Header.Add(key, value)
+	return o
+}
+
+func (o *FunctionOut) WithContentType(contentType string) *FunctionOut {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.ContentType = contentType
+	return o
+}
+
 func (tracing *PluginsTracing) IsEnabled() bool {
 	return tracing.Enabled
 }
@@ -756,7 +1068,17 @@ func hasPlugin(plugins []string, target string) bool {
 }
 
 func GetRuntimeContext() (RuntimeContext, error) {
-	ctx, err := parseContext()
+	ctx, err := parseContext("")
+	if err != nil {
+		return nil, err
+	}
+	return ctx, nil
+}
+
+// GetRuntimeContextForFunction returns the RuntimeContext of a declaratively registered function,
+// the section under `functions.<funcName>` of FUNC_CONTEXT is merged over the shared settings.
+func GetRuntimeContextForFunction(funcName string) (RuntimeContext, error) {
+	ctx, err := parseContext(funcName)
 	if err != nil {
 		return nil, err
 	}
@@ -771,15 +1093,23 @@ func CloneRuntimeContext(ctx RuntimeContext) RuntimeContext {
 		Inputs:  ctx.GetInputs(),
 		Outputs: ctx.GetOutputs(),
 
-		Runtime: ctx.GetRuntime(),
-		Port:    ctx.GetPort(),
-		State:   ctx.GetContext().State,
+		Runtime:      ctx.GetRuntime(),
+		Port:         ctx.GetPort(),
+		State:        ctx.GetContext().State,
+		States:       ctx.GetStates(),
+		SecretStores: ctx.GetSecretStores(),
 
 		PrePlugins:     ctx.GetPrePlugins(),
 		PostPlugins:    ctx.GetPostPlugins(),
 		PluginsTracing: ctx.GetContext().PluginsTracing,
 		HttpPattern:    ctx.GetHttpPattern(),
 
+		ShutdownTimeout: ctx.GetContext().ShutdownTimeout,
+		shutdownTimeout: ctx.GetShutdownTimeout(),
+		PanicPolicy:     ctx.GetContext().PanicPolicy,
+		Timeout:         ctx.GetContext().Timeout,
+		timeout:         ctx.GetTimeout(),
+
 		Event:        &EventRequest{},
 		SyncRequest:  &SyncRequest{},
 		mode:         ctx.GetMode(),
@@ -787,25 +1117,48 @@ func CloneRuntimeContext(ctx RuntimeContext) RuntimeContext {
 		podNamespace: ctx.GetPodNamespace(),
 		options:      ctx.GetContext().options,
 		daprClient:   ctx.GetContext().daprClient,
+		inFlight:     ctx.GetContext().inFlight,
 	}
 }
 
-func parseContext() (*FunctionContext, error) {
-	ctx := &FunctionContext{
-		Inputs:  make(map[string]*Input),
-		Outputs: make(map[string]*Output),
-	}
-
+func parseContext(funcName string) (*FunctionContext, error) {
 	data := os.Getenv(FunctionContextEnvName)
 	if data == "" {
 		return nil, fmt.Errorf("env %s not found", FunctionContextEnvName)
 	}
 
-	err := json.Unmarshal([]byte(data), ctx)
+	mode := KubernetesMode
+	if os.Getenv(ModeEnvName) == SelfHostMode {
+		mode = SelfHostMode
+	}
+	return parseContextData([]byte(data), funcName, mode)
+}
+
+// ParseRuntimeContext parses data in the format of FUNC_CONTEXT in the self-hosted mode,
+// without reading the function context and the pod from the environment.
+func ParseRuntimeContext(data []byte) (*FunctionContext, error) {
+	return parseContextData(data, "", SelfHostMode)
+}
+
+func parseContextData(data []byte, funcName string, mode string) (*FunctionContext, error) {
+	ctx := &FunctionContext{
+		Inputs:   make(map[string]*Input),
+		Outputs:  make(map[string]*Output),
+		inFlight: new(int64),
+		mode:     mode,
+	}
+
+	err := json.Unmarshal(data, ctx)
 	if err != nil {
 		return nil, err
 	}
 
+	if spec, ok := ctx.Functions[funcName]; ok && funcName != "" {
+		if err := mergeFunctionSpec(ctx, spec); err != nil {
+			return nil, fmt.Errorf("error parsing context of function %s: %s", funcName, err.Error())
+		}
+	}
+
 	switch ctx.Runtime {
 	case Async, Knative:
 		break
@@ -818,27 +1171,65 @@ func parseContext() (*FunctionContext, error) {
 
 	if ctx.HasInputs() {
 		for name, in := range ctx.GetInputs() {
-			if _, err := getBuildingBlockType(in.ComponentType); err != nil {
+			if t, err := getBuildingBlockType(in.ComponentType); err != nil {
 				klog.Errorf("failed to get building block type for input %s: %v", name, err)
 				return nil, err
+			} else if t == OpenFuncState || t == OpenFuncSecret {
+				return nil, fmt.Errorf("%s can not be the input %s", t, name)
+			} else if t == OpenFuncInvoke {
+				if in.Uri == "" {
+					return nil, fmt.Errorf("method of the service invocation input %s is required", name)
+				}
+				// the caller gets the failure of the invocation, there is no event to be forwarded
+				if in.DeadLetter != "" {
+					return nil, fmt.Errorf("service invocation input %s can not have a dead letter", name)
+				}
+			}
+			if in.DeadLetter != "" {
+				if _, ok := ctx.Outputs[in.DeadLetter]; !ok {
+					return nil, fmt.Errorf("dead letter %s of input %s is not defined in outputs", in.DeadLetter, name)
+				}
+			}
+			if in.MaxDeliveryAttempts < 0 {
+				return nil, fmt.Errorf("invalid max delivery attempts of input %s: %d", name, in.MaxDeliveryAttempts)
 			}
 		}
 	}
 
 	if ctx.HasOutputs() {
 		for name, out := range ctx.GetOutputs() {
-			if _, err := getBuildingBlockType(out.ComponentType); err != nil {
+			if !out.usesDapr() {
+				if err := out.parseTransport(); err != nil {
+					return nil, fmt.Errorf("error parsing transport of output %s: %s", name, err.Error())
+				}
+			} else if t, err := getBuildingBlockType(out.ComponentType); err != nil {
 				klog.Errorf("failed to get building block type for output %s: %v", name, err)
 				return nil, err
+			} else if t == OpenFuncState || t == OpenFuncSecret {
+				return nil, fmt.Errorf("%s can not be the output %s", t, name)
+			} else if t == OpenFuncInvoke {
+				if err := out.parseInvocation(); err != nil {
+					return nil, fmt.Errorf("error parsing service invocation of output %s: %s", name, err.Error())
+				}
+			}
+			if out.Resiliency != nil {
+				if err := out.Resiliency.parse(); err != nil {
+					return nil, fmt.Errorf("error parsing resiliency of output %s: %s", name, err.Error())
+				}
 			}
 		}
 	}
 
-	switch os.Getenv(ModeEnvName) {
-	case SelfHostMode:
-		ctx.mode = SelfHostMode
-	default:
-		ctx.mode = KubernetesMode
+	for name, store := range ctx.GetStates() {
+		if t, err := getBuildingBlockType(store.ComponentType); err != nil || t != OpenFuncState {
+			return nil, fmt.Errorf("invalid component type of state store %s: %s", name, store.ComponentType)
+		}
+	}
+
+	for name, store := range ctx.GetSecretStores() {
+		if t, err := getBuildingBlockType(store.ComponentType); err != nil || t != OpenFuncSecret {
+			return nil, fmt.Errorf("invalid component type of secret store %s: %s", name, store.ComponentType)
+		}
 	}
 
 	if ctx.mode == KubernetesMode {
@@ -890,6 +1281,30 @@ func parseContext() (*FunctionContext, error) {
 		ctx.HttpPattern = defaultHttpPattern
 	}
 
+	if ctx.ShutdownTimeout == "" {
+		ctx.shutdownTimeout = defaultShutdownTimeout
+	} else {
+		timeout, err := time.ParseDuration(ctx.ShutdownTimeout)
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("error parsing shutdown timeout: %s", ctx.ShutdownTimeout)
+		}
+		ctx.shutdownTimeout = timeout
+	}
+
+	if ctx.Timeout != "" {
+		timeout, err := time.ParseDuration(ctx.Timeout)
+		if err != nil || timeout < 0 {
+			return nil, fmt.Errorf("error parsing timeout: %s", ctx.Timeout)
+		}
+		ctx.timeout = timeout
+	}
+
+	switch ctx.PanicPolicy {
+	case "", PanicPolicyDrop, PanicPolicyRetry:
+	default:
+		return nil, fmt.Errorf("invalid panic policy: %s", ctx.PanicPolicy)
+	}
+
 	// Support one-sidecar-per-function mode
 	host := os.Getenv("DAPR_HOST")
 	if host == "" {
@@ -910,9 +1325,45 @@ func parseContext() (*FunctionContext, error) {
 	// Initialize the context options
 	newContextOptions(ctx)
 
+	addRuntimeContext(ctx)
 	return ctx, nil
 }
 
+// functionSpec lists the settings which can be set per function,
+// the inputs, outputs and tracing tags are merged by key while the plugins are replaced.
+type functionSpec struct {
+	Inputs         *map[string]*Input       `json:"inputs,omitempty"`
+	Outputs        *map[string]*Output      `json:"outputs,omitempty"`
+	States         *map[string]*StateStore  `json:"states,omitempty"`
+	SecretStores   *map[string]*SecretStore `json:"secretStores,omitempty"`
+	PrePlugins     *[]string                `json:"prePlugins,omitempty"`
+	PostPlugins    *[]string                `json:"postPlugins,omitempty"`
+	PluginsTracing **PluginsTracing         `json:"pluginsTracing,omitempty"`
+	PanicPolicy    *string                  `json:"panicPolicy,omitempty"`
+	Timeout        *string                  `json:"timeout,omitempty"`
+}
+
+func mergeFunctionSpec(ctx *FunctionContext, spec json.RawMessage) error {
+	if ctx.Inputs == nil {
+		ctx.Inputs = make(map[string]*Input)
+	}
+	if ctx.Outputs == nil {
+		ctx.Outputs = make(map[string]*Output)
+	}
+	// Decoding through the pointers writes into the fields of the shared context
+	return json.Unmarshal(spec, &functionSpec{
+		Inputs:         &ctx.Inputs,
+		Outputs:        &ctx.Outputs,
+		States:         &ctx.States,
+		SecretStores:   &ctx.SecretStores,
+		PrePlugins:     &ctx.PrePlugins,
+		PostPlugins:    &ctx.PostPlugins,
+		PluginsTracing: &ctx.PluginsTracing,
+		PanicPolicy:    &ctx.PanicPolicy,
+		Timeout:        &ctx.Timeout,
+	})
+}
+
 func NewFunctionOut() *FunctionOut {
 	return &FunctionOut{}
 }
@@ -937,11 +1388,15 @@ func traceable(t string) bool {
 }
 
 func getBuildingBlockType(componentType string) (ResourceType, error) {
+	// the service invocation is not a component
+	if componentType == string(OpenFuncInvoke) {
+		return OpenFuncInvoke, nil
+	}
 	typeSplit := strings.Split(componentType, ".")
 	if len(typeSplit) > 1 {
 		t := typeSplit[0]
 		switch ResourceType(t) {
-		case OpenFuncBinding, OpenFuncTopic:
+		case OpenFuncBinding, OpenFuncTopic, OpenFuncState, OpenFuncSecret:
 			return ResourceType(t), nil
 		default:
 			return "", fmt.Errorf("unknown component type: %s", t)
@@ -950,35 +1405,89 @@ func getBuildingBlockType(componentType string) (ResourceType, error) {
 	return "", errors.New("invalid component type")
 }
 
-func setExitSpan(ctx *FunctionContext, innerEvent InnerEvent, target string) error {
+// setExitSpan creates the exit span of the call to the output target, and propagates
+// the trace context through the carrier, e.g. the metadata of the inner event.
+// The returned function ends the span with the error of the call, it must be called after the call returns.
+func setExitSpan(ctx *FunctionContext, c context.Context, carrier propagation.TextMapCarrier, target string) (func(error), error) {
+	noop := func(error) {}
 	if !ctx.HasPluginsTracingCfg() || !ctx.GetPluginsTracingCfg().IsEnabled() {
-		return nil
+		return noop, nil
 	}
 
 	switch ctx.GetPluginsTracingCfg().ProviderName() {
 	case tracingProviderSkywalking:
 		tracer := go2sky.GetGlobalTracer()
 		if tracer == nil {
-			return errors.New("skywalking is not enabled")
+			return noop, errors.New("skywalking is not enabled")
 		}
 
-		span, err := tracer.CreateExitSpan(ctx.GetNativeContext(), ctx.GetName(), target, func(headerKey, headerValue string) error {
-			innerEvent.SetMetadata(headerKey, headerValue)
+		span, err := tracer.CreateExitSpan(c, ctx.GetName(), target, func(headerKey, headerValue string) error {
+			carrier.Set(headerKey, headerValue)
 			return nil
 		})
 		if err != nil {
-			return err
+			return noop, err
 		}
-		defer span.End()
 
 		span.SetSpanLayer(agentv3.SpanLayer_FAAS)
 		span.SetComponent(5013)
-		return nil
+		return func(err error) {
+			if err != nil {
+				span.Error(time.Now(), err.Error())
+			}
+			span.End()
+		}, nil
+	case TracingProviderOpentelemetry:
+		output := ctx.GetOutputs()[target]
+		kind := trace.SpanKindProducer
+		attrs := []attribute.KeyValue{
+			attribute.String("component.type", output.ComponentType),
+			attribute.String("component.name", output.ComponentName),
+		}
+		if output.GetType() == OpenFuncInvoke {
+			kind = trace.SpanKindClient
+			attrs = append(attrs, attribute.String("peer.service", output.AppID))
+		}
+		nCtx, span := otel.Tracer(OpenTelemetryInstrumentationName).Start(c, target,
+			trace.WithSpanKind(kind),
+			trace.WithAttributes(attrs...),
+		)
+
+		// Propagate the W3C trace context through the carrier
+		otel.GetTextMapPropagator().Inject(nCtx, carrier)
+		return func(err error) {
+			if err != nil {
+				span.RecordError(err)
+				span.SetStatus(codes.Error, err.Error())
+			}
+			span.End()
+		}, nil
 	default:
-		return nil
+		return noop, nil
 	}
 }
 
+// innerEventCarrier adapts the metadata of InnerEvent to propagation.TextMapCarrier.
+type innerEventCarrier struct {
+	InnerEvent
+}
+
+func (c innerEventCarrier) Get(key string) string {
+	return c.GetMetadata()[key]
+}
+
+func (c innerEventCarrier) Set(key string, value string) {
+	c.SetMetadata(key, value)
+}
+
+func (c innerEventCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.GetMetadata()))
+	for k := range c.GetMetadata() {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
 func ConvertUserDataToBytes(data interface{}) []byte {
 	if d, ok := data.([]byte); ok {
 		return d
@@ -1039,3 +1548,12 @@ func IsTracingProviderSkyWalking(ctx RuntimeContext) bool {
 
 	return false
 }
+
+func IsTracingProviderOpenTelemetry(ctx RuntimeContext) bool {
+	if ctx.HasPluginsTracingCfg() && ctx.GetPluginsTracingCfg().IsEnabled() &&
+		ctx.GetPluginsTracingCfg().ProviderName() == TracingProviderOpentelemetry {
+		return true
+	}
+
+	return false
+}
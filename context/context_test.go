@@ -1,11 +1,32 @@
 package context
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"net/http"
 	"os"
 	"reflect"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
+
+	commonv1pb "github.com/dapr/dapr/pkg/proto/common/v1"
+	pb "github.com/dapr/dapr/pkg/proto/runtime/v1"
+	dapr "github.com/dapr/go-sdk/client"
+	"go.opentelemetry.io/otel"
+	otelcodes "go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
 )
 
 var (
@@ -39,6 +60,42 @@ var (
   "version": "v1.0.0",
   "runtime": "Async",
   "port": "wrongPort"
+}`
+	funcCtxWithShutdownTimeout = `{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Knative",
+  "shutdownTimeout": "10s"
+}`
+	funcCtxWithWrongShutdownTimeout = `{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Knative",
+  "shutdownTimeout": "ten seconds"
+}`
+	funcCtxWithPanicPolicy = `{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Async",
+  "panicPolicy": "retry"
+}`
+	funcCtxWithWrongPanicPolicy = `{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Async",
+  "panicPolicy": "ignore"
+}`
+	funcCtxWithTimeout = `{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Knative",
+  "timeout": "3s"
+}`
+	funcCtxWithWrongTimeout = `{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Knative",
+  "timeout": "-3s"
 }`
 	funcCtxWithPlugins = `{
   "name": "function-test",
@@ -72,6 +129,49 @@ var (
       "value": "base64(string key):base64(string value),base64(string key2):base64(string value2)"
     }
   }
+}`
+	funcCtxWithFunctions = `{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Knative",
+  "prePlugins": ["plgA"],
+  "postPlugins": ["plgA"],
+  "outputs": {
+    "shared": {
+      "uri": "shared",
+      "componentName": "kafka-server",
+      "componentType": "bindings.kafka"
+    }
+  },
+  "pluginsTracing": {
+    "enabled": true,
+    "provider": {
+      "name": "opentelemetry"
+    },
+    "tags": {
+      "layer": "faas"
+    }
+  },
+  "functions": {
+    "foo": {
+      "prePlugins": ["plgB"],
+      "outputs": {
+        "echo": {
+          "uri": "echo",
+          "componentName": "echo",
+          "componentType": "bindings.http"
+        }
+      },
+      "pluginsTracing": {
+        "tags": {
+          "team": "foo"
+        }
+      }
+    },
+    "wrong": {
+      "outputs": ["echo"]
+    }
+  }
 }`
 	funcCtxWithWrongTracingCfg = `{
   "name": "function-test",
@@ -210,6 +310,87 @@ func TestParseFunctionContext(t *testing.T) {
 		t.Fatal("Error set function context env")
 	}
 
+	// test `shutdownTimeout` field
+	if err := os.Setenv(FunctionContextEnvName, funcCtxWithKnativeRuntime); err == nil {
+		if ctx, err := GetRuntimeContext(); err != nil {
+			t.Fatalf("Error parse function context: %s", err.Error())
+		} else {
+			if ctx.GetShutdownTimeout() != defaultShutdownTimeout {
+				t.Fatal("Error parse function context: failed to parse shutdown timeout")
+			}
+		}
+	} else {
+		t.Fatal("Error set function context env")
+	}
+
+	if err := os.Setenv(FunctionContextEnvName, funcCtxWithShutdownTimeout); err == nil {
+		if ctx, err := GetRuntimeContext(); err != nil {
+			t.Fatalf("Error parse function context: %s", err.Error())
+		} else {
+			if ctx.GetShutdownTimeout() != 10*time.Second {
+				t.Fatal("Error parse function context: failed to parse shutdown timeout")
+			}
+		}
+	} else {
+		t.Fatal("Error set function context env")
+	}
+
+	if err := os.Setenv(FunctionContextEnvName, funcCtxWithWrongShutdownTimeout); err == nil {
+		if _, err := GetRuntimeContext(); err == nil || !strings.Contains(err.Error(), "error parsing shutdown timeout") {
+			t.Fatal("Error parse function context: failed to parse shutdown timeout")
+		}
+	} else {
+		t.Fatal("Error set function context env")
+	}
+
+	// test `timeout` field
+	if err := os.Setenv(FunctionContextEnvName, funcCtxWithTimeout); err == nil {
+		if ctx, err := GetRuntimeContext(); err != nil {
+			t.Fatalf("Error parse function context: %s", err.Error())
+		} else if ctx.GetTimeout() != 3*time.Second {
+			t.Fatal("Error parse function context: failed to parse timeout")
+		}
+	} else {
+		t.Fatal("Error set function context env")
+	}
+
+	if err := os.Setenv(FunctionContextEnvName, funcCtxWithWrongTimeout); err == nil {
+		if _, err := GetRuntimeContext(); err == nil || !strings.Contains(err.Error(), "error parsing timeout") {
+			t.Fatal("Error parse function context: failed to parse timeout")
+		}
+	} else {
+		t.Fatal("Error set function context env")
+	}
+
+	// test `panicPolicy` field
+	if err := os.Setenv(FunctionContextEnvName, funcCtxWithKnativeRuntime); err == nil {
+		if ctx, err := GetRuntimeContext(); err != nil {
+			t.Fatalf("Error parse function context: %s", err.Error())
+		} else if ctx.GetPanicDisposition() != DispositionDrop {
+			t.Fatal("Error parse function context: failed to parse panic policy")
+		}
+	} else {
+		t.Fatal("Error set function context env")
+	}
+
+	if err := os.Setenv(FunctionContextEnvName, funcCtxWithPanicPolicy); err == nil {
+		if ctx, err := GetRuntimeContext(); err != nil {
+			t.Fatalf("Error parse function context: %s", err.Error())
+		} else if ctx.GetPanicDisposition() != DispositionRetry {
+			t.Fatal("Error parse function context: failed to parse panic policy")
+		}
+	} else {
+		t.Fatal("Error set function context env")
+	}
+
+	if err := os.Setenv(FunctionContextEnvName, funcCtxWithWrongPanicPolicy); err == nil {
+		if _, err := GetRuntimeContext(); err == nil || !strings.Contains(err.Error(), "invalid panic policy") {
+			t.Fatal("Error parse function context: failed to parse panic policy")
+		}
+	} else {
+		t.Fatal("Error set function context env")
+	}
+
 	// test `inputs`, `outputs` fields
 	if err := os.Setenv(FunctionContextEnvName, funcCtx); err == nil {
 		if ctx, err := GetRuntimeContext(); err != nil {
@@ -278,6 +459,37 @@ func TestParseFunctionContext(t *testing.T) {
 		t.Fatal("Error set function context env")
 	}
 
+	// test `functions` field
+	if err := os.Setenv(FunctionContextEnvName, funcCtxWithFunctions); err == nil {
+		if ctx, err := GetRuntimeContextForFunction("foo"); err != nil {
+			t.Fatalf("Error parse function context: %s", err.Error())
+		} else {
+			if len(ctx.GetOutputs()) != 2 || ctx.GetOutputs()["shared"] == nil || ctx.GetOutputs()["echo"] == nil {
+				t.Fatal("Error parse function context: failed to merge outputs of function")
+			}
+			if !(len(ctx.GetPrePlugins()) == 2 && ctx.GetPrePlugins()[0] == "plgB") || ctx.GetPostPlugins()[1] != "plgA" {
+				t.Fatal("Error parse function context: failed to merge plugins of function")
+			}
+			if ctx.GetPluginsTracingCfg().GetTags()["team"] != "foo" || ctx.GetPluginsTracingCfg().GetTags()["layer"] != "faas" {
+				t.Fatal("Error parse function context: failed to merge tracing tags of function")
+			}
+		}
+
+		if ctx, err := GetRuntimeContextForFunction("bar"); err != nil {
+			t.Fatalf("Error parse function context: %s", err.Error())
+		} else {
+			if len(ctx.GetOutputs()) != 1 || ctx.GetPrePlugins()[0] != "plgA" || ctx.GetPluginsTracingCfg().GetTags()["team"] != "" {
+				t.Fatal("Error parse function context: function without section should use the shared settings")
+			}
+		}
+
+		if _, err := GetRuntimeContextForFunction("wrong"); err == nil || !strings.Contains(err.Error(), "error parsing context of function wrong") {
+			t.Fatal("Error parse function context: failed to parse section of function")
+		}
+	} else {
+		t.Fatal("Error set function context env")
+	}
+
 	if err := os.Setenv(FunctionContextEnvName, funcCtxWithWrongTracingCfg); err == nil {
 		if _, err := GetRuntimeContext(); err == nil || !strings.Contains(err.Error(), "the tracing plugin is enabled, but its configuration is incorrect") {
 			t.Fatal("Error parse function context: failed to parse tracing config")
@@ -326,3 +538,790 @@ func TestGetVarsFromContext(t *testing.T) {
 	}
 
 }
+
+func TestOpenTelemetryExitSpan(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter)))
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	os.Setenv(ModeEnvName, SelfHostMode)
+	if err := os.Setenv(FunctionContextEnvName, funcCtx); err != nil {
+		t.Fatal("Error set function context env")
+	}
+	rtCtx, err := GetRuntimeContext()
+	if err != nil {
+		t.Fatalf("Error parse function context: %s", err.Error())
+	}
+	ctx := rtCtx.GetContext()
+	ctx.PluginsTracing = &PluginsTracing{
+		Enabled:  true,
+		Provider: &TracingProvider{Name: TracingProviderOpentelemetry},
+	}
+
+	parent, span := otel.Tracer("test").Start(context.Background(), "entry")
+	ctx.SetNativeContext(parent)
+
+	ie := NewInnerEvent(ctx)
+	endSpan, err := setExitSpan(ctx, parent, innerEventCarrier{ie}, "target")
+	if err != nil {
+		t.Fatalf("Error set exit span: %s", err.Error())
+	}
+	// the exit span lasts until the call returns
+	if len(exporter.GetSpans()) != 0 {
+		t.Fatal("Error set exit span: the span is ended before the call")
+	}
+	endSpan(errors.New("output is down"))
+	span.End()
+
+	traceParent := ie.GetMetadata()["traceparent"]
+	if !strings.Contains(traceParent, span.SpanContext().TraceID().String()) {
+		t.Fatalf("Error set exit span: traceparent %q is not propagated", traceParent)
+	}
+
+	spans := exporter.GetSpans()
+	if len(spans) != 2 || spans[0].Name != "target" || spans[0].SpanKind != trace.SpanKindProducer {
+		t.Fatal("Error set exit span: producer span is not exported")
+	}
+	if spans[0].Status.Code != otelcodes.Error {
+		t.Fatal("Error set exit span: the error of the call is not recorded")
+	}
+}
+
+type contextRecordingClient struct {
+	dapr.Client
+	contexts []context.Context
+	events   []*pb.PublishEventRequest
+	bindings []*dapr.InvokeBindingRequest
+}
+
+func (c *contextRecordingClient) PublishEvent(ctx context.Context, pubsubName, topicName string, data interface{}, opts ...dapr.PublishEventOption) error {
+	c.contexts = append(c.contexts, ctx)
+	event := &pb.PublishEventRequest{PubsubName: pubsubName, Topic: topicName}
+	for _, opt := range opts {
+		opt(event)
+	}
+	c.events = append(c.events, event)
+	return ctx.Err()
+}
+
+func (c *contextRecordingClient) InvokeBinding(ctx context.Context, in *dapr.InvokeBindingRequest) (*dapr.BindingEvent, error) {
+	c.contexts = append(c.contexts, ctx)
+	c.bindings = append(c.bindings, in)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &dapr.BindingEvent{}, nil
+}
+
+type sendContextKey struct{}
+
+func TestSendWithContext(t *testing.T) {
+	funcCtx := `{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Async",
+  "outputs": {
+    "binding": {
+      "uri": "echo",
+      "componentName": "kafka-server",
+      "componentType": "bindings.kafka"
+    },
+    "topic": {
+      "uri": "sample",
+      "componentName": "msg",
+      "componentType": "pubsub.kafka"
+    }
+  }
+}`
+	os.Setenv(ModeEnvName, SelfHostMode)
+	if err := os.Setenv(FunctionContextEnvName, funcCtx); err != nil {
+		t.Fatal("Error set function context env")
+	}
+	rtCtx, err := GetRuntimeContext()
+	if err != nil {
+		t.Fatalf("Error parse function context: %s", err.Error())
+	}
+	ctx := rtCtx.GetContext()
+	client := &contextRecordingClient{}
+	ctx.daprClient = client
+
+	// Send uses the native context of the invocation
+	nativeContext := context.WithValue(context.Background(), sendContextKey{}, "native")
+	ctx.SetNativeContext(nativeContext)
+	for _, output := range []string{"binding", "topic"} {
+		if _, err := ctx.Send(output, []byte("hello")); err != nil {
+			t.Fatalf("Error send to %s: %s", output, err.Error())
+		}
+	}
+	for _, c := range client.contexts {
+		if c.Value(sendContextKey{}) != "native" {
+			t.Fatal("Error send: the native context is not used")
+		}
+	}
+
+	// the call to the output is cancelled with the context
+	cancelled, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, output := range []string{"binding", "topic"} {
+		if _, err := ctx.SendWithContext(cancelled, output, []byte("hello")); !errors.Is(err, context.Canceled) {
+			t.Fatalf("Error send to %s with cancelled context: %v", output, err)
+		}
+	}
+}
+
+func TestSendOptions(t *testing.T) {
+	funcCtx := `{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Async",
+  "outputs": {
+    "binding": {
+      "uri": "echo",
+      "componentName": "kafka-server",
+      "componentType": "bindings.kafka",
+      "operation": "create",
+      "metadata": {
+        "key1": "value1",
+        "key2": "value2"
+      }
+    },
+    "topic": {
+      "uri": "sample",
+      "componentName": "msg",
+      "componentType": "pubsub.kafka"
+    }
+  }
+}`
+	os.Setenv(ModeEnvName, SelfHostMode)
+	if err := os.Setenv(FunctionContextEnvName, funcCtx); err != nil {
+		t.Fatal("Error set function context env")
+	}
+	rtCtx, err := GetRuntimeContext()
+	if err != nil {
+		t.Fatalf("Error parse function context: %s", err.Error())
+	}
+	ctx := rtCtx.GetContext()
+	client := &contextRecordingClient{}
+	ctx.daprClient = client
+
+	// the output is used as it is without options
+	if _, err := ctx.Send("binding", []byte("hello")); err != nil {
+		t.Fatalf("Error send to binding: %s", err.Error())
+	}
+	in := client.bindings[0]
+	if in.Operation != "create" || !reflect.DeepEqual(in.Metadata, map[string]string{"key1": "value1", "key2": "value2"}) {
+		t.Fatalf("Error send to binding: unexpected request %+v", in)
+	}
+
+	if _, err := ctx.Send("binding", []byte("hello"),
+		SendWithOperation("delete"),
+		SendWithMetadata(map[string]string{"key2": "override", "key3": "value3"}),
+		SendWithPartitionKey("order-1"),
+		SendWithTTL(1500*time.Millisecond),
+		SendWithContentType("application/json"),
+	); err != nil {
+		t.Fatalf("Error send to binding with options: %s", err.Error())
+	}
+	in = client.bindings[1]
+	expected := map[string]string{
+		"key1":         "value1",
+		"key2":         "override",
+		"key3":         "value3",
+		"partitionKey": "order-1",
+		"ttlInSeconds": "2",
+		"contentType":  "application/json",
+	}
+	if in.Operation != "delete" || !reflect.DeepEqual(in.Metadata, expected) {
+		t.Fatalf("Error send to binding with options: unexpected request %+v", in)
+	}
+
+	// the options of a call do not change the output
+	output := ctx.GetOutputs()["binding"]
+	if output.Operation != "create" || len(output.Metadata) != 2 {
+		t.Fatal("Error send to binding with options: the output is changed")
+	}
+
+	if _, err := ctx.Send("topic", []byte("hello"),
+		SendWithPartitionKey("order-1"),
+		SendWithContentType("application/json"),
+	); err != nil {
+		t.Fatalf("Error send to topic with options: %s", err.Error())
+	}
+	event := client.events[0]
+	if event.DataContentType != "application/json" || !reflect.DeepEqual(event.Metadata, map[string]string{"partitionKey": "order-1"}) {
+		t.Fatalf("Error send to topic with options: unexpected event %+v", event)
+	}
+
+	// the data wrapped in the InnerEvent for tracing is sent as json
+	ctx.PluginsTracing = &PluginsTracing{
+		Enabled:  true,
+		Provider: &TracingProvider{Name: TracingProviderOpentelemetry},
+	}
+	if _, err := ctx.Send("binding", []byte("hello"), SendWithContentType("text/plain")); err != nil {
+		t.Fatalf("Error send to binding with tracing: %s", err.Error())
+	}
+	if in := client.bindings[2]; in.Metadata["contentType"] != "application/json" {
+		t.Fatalf("Error send to binding with tracing: unexpected content type %s", in.Metadata["contentType"])
+	}
+	if _, err := ctx.Send("topic", []byte("hello"), SendWithContentType("text/plain")); err != nil {
+		t.Fatalf("Error send to topic with tracing: %s", err.Error())
+	}
+	if event := client.events[1]; event.DataContentType != "application/json" {
+		t.Fatalf("Error send to topic with tracing: unexpected content type %s", event.DataContentType)
+	}
+}
+
+type flakyBindingClient struct {
+	dapr.Client
+	calls    int
+	failures int
+	block    bool
+}
+
+func (c *flakyBindingClient) InvokeBinding(ctx context.Context, in *dapr.InvokeBindingRequest) (*dapr.BindingEvent, error) {
+	c.calls++
+	if c.block {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	if c.calls <= c.failures {
+		return nil, errors.New("broker is not available")
+	}
+	return &dapr.BindingEvent{Data: []byte("ok")}, nil
+}
+
+func TestSendResiliency(t *testing.T) {
+	funcCtx := `{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Async",
+  "outputs": {
+    "retry": {
+      "uri": "echo",
+      "componentName": "kafka-server",
+      "componentType": "bindings.kafka",
+      "resiliency": {
+        "maxAttempts": 3,
+        "initialInterval": "1ms",
+        "timeout": "10ms"
+      }
+    },
+    "breaker": {
+      "uri": "echo",
+      "componentName": "kafka-server",
+      "componentType": "bindings.kafka",
+      "resiliency": {
+        "circuitBreaker": {
+          "consecutiveFailures": 2,
+          "timeout": "50ms"
+        }
+      }
+    }
+  }
+}`
+	os.Setenv(ModeEnvName, SelfHostMode)
+	if err := os.Setenv(FunctionContextEnvName, funcCtx); err != nil {
+		t.Fatal("Error set function context env")
+	}
+	rtCtx, err := GetRuntimeContext()
+	if err != nil {
+		t.Fatalf("Error parse function context: %s", err.Error())
+	}
+	ctx := rtCtx.GetContext()
+
+	var decisions []ResiliencyDecision
+	AddResiliencyObserver(func(c context.Context, ctx RuntimeContext, event *ResiliencyEvent) {
+		decisions = append(decisions, event.Decision)
+	})
+
+	// the transient errors are retried
+	client := &flakyBindingClient{failures: 2}
+	ctx.daprClient = client
+	if data, err := ctx.Send("retry", []byte("hello")); err != nil || string(data) != "ok" {
+		t.Fatalf("Error send with retries: %v", err)
+	}
+	if client.calls != 3 || !reflect.DeepEqual(decisions, []ResiliencyDecision{ResiliencyRetry, ResiliencyRetry}) {
+		t.Fatalf("Error send with retries: %d calls, decisions %v", client.calls, decisions)
+	}
+
+	// the error of the last attempt is returned, each attempt is bounded by the timeout
+	decisions = nil
+	client = &flakyBindingClient{block: true}
+	ctx.daprClient = client
+	if _, err := ctx.Send("retry", []byte("hello")); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("Error send with attempt timeout: %v", err)
+	}
+	if client.calls != 3 || decisions[len(decisions)-1] != ResiliencyGiveUp {
+		t.Fatalf("Error send with attempt timeout: %d calls, decisions %v", client.calls, decisions)
+	}
+
+	// the circuit opens after the consecutive failures and closes after a successful trial call
+	decisions = nil
+	client = &flakyBindingClient{failures: 2}
+	ctx.daprClient = client
+	policy := ctx.GetOutputs()["breaker"].Resiliency
+	for i := 0; i < 2; i++ {
+		if _, err := ctx.Send("breaker", []byte("hello")); err == nil {
+			t.Fatal("Error send with circuit breaker: expected failure")
+		}
+	}
+	if policy.GetCircuitBreakerState() != CircuitBreakerStateOpen {
+		t.Fatalf("Error send with circuit breaker: circuit is %s", policy.GetCircuitBreakerState())
+	}
+	if _, err := ctx.Send("breaker", []byte("hello")); !errors.Is(err, ErrCircuitOpen) || client.calls != 2 {
+		t.Fatalf("Error send with open circuit: %v", err)
+	}
+	time.Sleep(60 * time.Millisecond)
+	if _, err := ctx.Send("breaker", []byte("hello")); err != nil {
+		t.Fatalf("Error send with half-open circuit: %v", err)
+	}
+	if policy.GetCircuitBreakerState() != CircuitBreakerStateClosed {
+		t.Fatalf("Error send with circuit breaker: circuit is %s", policy.GetCircuitBreakerState())
+	}
+	expected := []ResiliencyDecision{ResiliencyCircuitOpen, ResiliencyCircuitReject, ResiliencyCircuitClose}
+	if !reflect.DeepEqual(decisions, expected) {
+		t.Fatalf("Error send with circuit breaker: decisions %v", decisions)
+	}
+}
+
+func TestParseResiliencyPolicy(t *testing.T) {
+	for _, policy := range []string{
+		`{"maxAttempts": -1}`,
+		`{"initialInterval": "wrong"}`,
+		`{"timeout": "-1s"}`,
+		`{"multiplier": 0.5}`,
+		`{"jitter": 2}`,
+		`{"circuitBreaker": {"consecutiveFailures": 0}}`,
+	} {
+		funcCtx := `{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Async",
+  "outputs": {
+    "echo": {
+      "uri": "echo",
+      "componentName": "kafka-server",
+      "componentType": "bindings.kafka",
+      "resiliency": ` + policy + `
+    }
+  }
+}`
+		os.Setenv(ModeEnvName, SelfHostMode)
+		os.Setenv(FunctionContextEnvName, funcCtx)
+		if _, err := GetRuntimeContext(); err == nil {
+			t.Fatalf("Error parse resiliency policy %s: expected error", policy)
+		}
+	}
+}
+
+func TestParseDeadLetter(t *testing.T) {
+	funcCtx := `{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Async",
+  "inputs": {
+    "kafka": {
+      "uri": "kafka",
+      "componentName": "kafka",
+      "componentType": "bindings.kafka",
+      "deadLetter": "dlq"
+    }
+  }
+}`
+	os.Setenv(ModeEnvName, SelfHostMode)
+	os.Setenv(FunctionContextEnvName, funcCtx)
+	if _, err := GetRuntimeContext(); err == nil || !strings.Contains(err.Error(), "dead letter dlq") {
+		t.Fatalf("Error parse dead letter: %v", err)
+	}
+}
+
+// fakeStateClient is an in-memory state store which checks the ETags like Dapr.
+type fakeStateClient struct {
+	dapr.Client
+	stores   map[string]map[string]*dapr.StateItem
+	metadata []map[string]string
+	version  int
+}
+
+func (c *fakeStateClient) store(name string) map[string]*dapr.StateItem {
+	if c.stores == nil {
+		c.stores = map[string]map[string]*dapr.StateItem{}
+	}
+	if c.stores[name] == nil {
+		c.stores[name] = map[string]*dapr.StateItem{}
+	}
+	return c.stores[name]
+}
+
+func (c *fakeStateClient) checkETag(store, key string, etag *dapr.ETag) error {
+	if item, ok := c.store(store)[key]; etag != nil && (!ok || item.Etag != etag.Value) {
+		return fmt.Errorf("error saving state: %w", status.Error(codes.Aborted, "possible etag mismatch"))
+	}
+	return nil
+}
+
+func (c *fakeStateClient) GetState(ctx context.Context, storeName, key string, meta map[string]string) (*dapr.StateItem, error) {
+	c.metadata = append(c.metadata, meta)
+	if item, ok := c.store(storeName)[key]; ok {
+		return item, nil
+	}
+	return &dapr.StateItem{Key: key}, nil
+}
+
+func (c *fakeStateClient) SaveBulkState(ctx context.Context, storeName string, items ...*dapr.SetStateItem) error {
+	for _, item := range items {
+		c.metadata = append(c.metadata, item.Metadata)
+		if err := c.checkETag(storeName, item.Key, item.Etag); err != nil {
+			return err
+		}
+		c.version++
+		c.store(storeName)[item.Key] = &dapr.StateItem{Key: item.Key, Value: item.Value, Etag: strconv.Itoa(c.version)}
+	}
+	return nil
+}
+
+func (c *fakeStateClient) DeleteStateWithETag(ctx context.Context, storeName, key string, etag *dapr.ETag, meta map[string]string, opts *dapr.StateOptions) error {
+	if err := c.checkETag(storeName, key, etag); err != nil {
+		return err
+	}
+	delete(c.store(storeName), key)
+	return nil
+}
+
+func (c *fakeStateClient) GetBulkState(ctx context.Context, storeName string, keys []string, meta map[string]string, parallelism int32) ([]*dapr.BulkStateItem, error) {
+	var items []*dapr.BulkStateItem
+	for _, key := range keys {
+		item, _ := c.GetState(ctx, storeName, key, meta)
+		items = append(items, &dapr.BulkStateItem{Key: key, Value: item.Value, Etag: item.Etag})
+	}
+	return items, nil
+}
+
+func (c *fakeStateClient) ExecuteStateTransaction(ctx context.Context, storeName string, meta map[string]string, ops []*dapr.StateOperation) error {
+	for _, op := range ops {
+		if err := c.checkETag(storeName, op.Item.Key, op.Item.Etag); err != nil {
+			return err
+		}
+	}
+	for _, op := range ops {
+		if op.Type == dapr.StateOperationTypeDelete {
+			delete(c.store(storeName), op.Item.Key)
+		} else {
+			c.SaveBulkState(ctx, storeName, &dapr.SetStateItem{Key: op.Item.Key, Value: op.Item.Value})
+		}
+	}
+	return nil
+}
+
+func TestStateStore(t *testing.T) {
+	funcCtx := `{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Knative",
+  "states": {
+    "orders": {
+      "componentName": "redis",
+      "componentType": "state.redis",
+      "metadata": {
+        "ttlInSeconds": "60"
+      }
+    }
+  }
+}`
+	os.Setenv(ModeEnvName, SelfHostMode)
+	if err := os.Setenv(FunctionContextEnvName, funcCtx); err != nil {
+		t.Fatal("Error set function context env")
+	}
+	rtCtx, err := GetRuntimeContext()
+	if err != nil {
+		t.Fatalf("Error parse function context: %s", err.Error())
+	}
+	ctx := rtCtx.GetContext()
+
+	if err := ctx.SaveState("orders", "a", []byte("1")); err != errDaprClientNotInitialized {
+		t.Fatalf("Error save state without dapr client: %v", err)
+	}
+	client := &fakeStateClient{}
+	ctx.SetDaprClient(client)
+
+	if err := ctx.SaveState("unknown", "a", []byte("1")); err == nil {
+		t.Fatal("Error save state: expected error of unknown state store")
+	}
+
+	if err := ctx.SaveState("orders", "a", []byte("1"), StateWithMetadata(map[string]string{"contentType": "text/plain"})); err != nil {
+		t.Fatalf("Error save state: %v", err)
+	}
+	expected := map[string]string{"ttlInSeconds": "60", "contentType": "text/plain"}
+	if !reflect.DeepEqual(client.metadata[0], expected) {
+		t.Fatalf("Error save state: unexpected metadata %v", client.metadata[0])
+	}
+
+	item, err := ctx.GetState("orders", "a")
+	if err != nil || string(item.Value) != "1" || item.Etag == "" {
+		t.Fatalf("Error get state: %v", err)
+	}
+
+	// the state is saved only if the ETag matches
+	if err := ctx.SaveState("orders", "a", []byte("2"), StateWithETag(item.Etag)); err != nil {
+		t.Fatalf("Error save state with etag: %v", err)
+	}
+	if err := ctx.SaveState("orders", "a", []byte("3"), StateWithETag(item.Etag)); !IsETagMismatch(err) {
+		t.Fatalf("Error save state with stale etag: %v", err)
+	}
+	if err := ctx.DeleteState("orders", "a", StateWithETag(item.Etag)); !IsETagMismatch(err) {
+		t.Fatalf("Error delete state with stale etag: %v", err)
+	}
+
+	bulk := []*SetStateItem{{Key: "b", Value: []byte("b")}, {Key: "c", Value: []byte("c")}}
+	if err := ctx.SaveBulkState("orders", bulk...); err != nil {
+		t.Fatalf("Error save bulk state: %v", err)
+	}
+	// the items of the caller are not changed by the metadata of the store
+	if bulk[0].Metadata != nil || !reflect.DeepEqual(client.metadata[len(client.metadata)-1], map[string]string{"ttlInSeconds": "60"}) {
+		t.Fatalf("Error save bulk state: unexpected metadata %v %v", bulk[0].Metadata, client.metadata)
+	}
+	items, err := ctx.GetBulkState("orders", []string{"a", "b", "c"})
+	if err != nil || len(items) != 3 || string(items[0].Value) != "2" || string(items[2].Value) != "c" {
+		t.Fatalf("Error get bulk state: %v", err)
+	}
+
+	if err := ctx.ExecuteStateTransaction("orders", []*StateOperation{
+		{Type: StateOperationDelete, Item: &SetStateItem{Key: "b"}},
+		{Type: StateOperationUpsert, Item: &SetStateItem{Key: "d", Value: []byte("d")}},
+	}); err != nil {
+		t.Fatalf("Error execute state transaction: %v", err)
+	}
+	if item, _ := ctx.GetState("orders", "b"); len(item.Value) != 0 {
+		t.Fatal("Error execute state transaction: b is not deleted")
+	}
+	if item, _ := ctx.GetState("orders", "d"); string(item.Value) != "d" {
+		t.Fatal("Error execute state transaction: d is not saved")
+	}
+
+	if err := ctx.DeleteState("orders", "a"); err != nil {
+		t.Fatalf("Error delete state: %v", err)
+	}
+	if item, _ := ctx.GetState("orders", "a"); len(item.Value) != 0 {
+		t.Fatal("Error delete state: a is not deleted")
+	}
+}
+
+func TestParseStateStores(t *testing.T) {
+	for _, funcCtx := range []string{
+		`{"name": "function-test", "runtime": "Knative", "states": {"orders": {"componentName": "kafka", "componentType": "bindings.kafka"}}}`,
+		`{"name": "function-test", "runtime": "Knative", "outputs": {"orders": {"componentName": "redis", "componentType": "state.redis"}}}`,
+	} {
+		os.Setenv(ModeEnvName, SelfHostMode)
+		os.Setenv(FunctionContextEnvName, funcCtx)
+		if _, err := GetRuntimeContext(); err == nil {
+			t.Fatalf("Error parse state stores %s: expected error", funcCtx)
+		}
+	}
+}
+
+type fakeSecretClient struct {
+	dapr.Client
+	secrets map[string]map[string]map[string]string
+}
+
+func (c *fakeSecretClient) GetSecret(ctx context.Context, storeName, key string, meta map[string]string) (map[string]string, error) {
+	secret, ok := c.secrets[storeName][key]
+	if !ok {
+		return nil, fmt.Errorf("secret %s not found", key)
+	}
+	return secret, nil
+}
+
+func (c *fakeSecretClient) GetBulkSecret(ctx context.Context, storeName string, meta map[string]string) (map[string]map[string]string, error) {
+	return c.secrets[storeName], nil
+}
+
+func TestSecretStore(t *testing.T) {
+	funcCtx := `{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Async",
+  "secretStores": {
+    "vault": {
+      "componentName": "vault-store",
+      "componentType": "secretstores.hashicorp.vault"
+    }
+  },
+  "outputs": {
+    "kafka": {
+      "uri": "echo",
+      "componentName": "kafka-server",
+      "componentType": "bindings.kafka",
+      "metadata": {
+        "saslPassword": "{{secret:vault/kafka-password}}",
+        "saslUsername": "{{secret:vault/kafka/username}}",
+        "brokers": "localhost:9092"
+      }
+    }
+  }
+}`
+	os.Setenv(ModeEnvName, SelfHostMode)
+	if err := os.Setenv(FunctionContextEnvName, funcCtx); err != nil {
+		t.Fatal("Error set function context env")
+	}
+	rtCtx, err := GetRuntimeContext()
+	if err != nil {
+		t.Fatalf("Error parse function context: %s", err.Error())
+	}
+	ctx := rtCtx.GetContext()
+
+	client := &fakeSecretClient{secrets: map[string]map[string]map[string]string{
+		"vault-store": {
+			"kafka-password": {"kafka-password": "s3cret"},
+			"kafka":          {"username": "admin", "password": "s3cret"},
+		},
+	}}
+	if err := ctx.SetDaprClient(client); err != nil {
+		t.Fatalf("Error resolve secrets: %v", err)
+	}
+	expected := map[string]string{
+		"saslPassword": "s3cret",
+		"saslUsername": "admin",
+		"brokers":      "localhost:9092",
+	}
+	if metadata := ctx.GetOutputs()["kafka"].Metadata; !reflect.DeepEqual(metadata, expected) {
+		t.Fatalf("Error resolve secrets: unexpected metadata %v", metadata)
+	}
+
+	secret, err := ctx.GetSecret("vault", "kafka")
+	if err != nil || secret["username"] != "admin" {
+		t.Fatalf("Error get secret: %v", err)
+	}
+	secrets, err := ctx.GetBulkSecret("vault")
+	if err != nil || len(secrets) != 2 {
+		t.Fatalf("Error get bulk secret: %v", err)
+	}
+	if _, err := ctx.GetSecret("unknown", "kafka"); err == nil {
+		t.Fatal("Error get secret: expected error of unknown secret store")
+	}
+
+	// the references to the values not found fail the resolution
+	for _, ref := range []string{"{{secret:vault/kafka/token}}", "{{secret:vault/unknown}}", "{{secret:unknown/kafka}}"} {
+		if _, err := ctx.resolveSecretRef(ref); err == nil {
+			t.Fatalf("Error resolve secret %s: expected error", ref)
+		}
+	}
+}
+
+type fakeInvokeClient struct {
+	dapr.Client
+	grpcClient *fakeInvokeGrpcClient
+}
+
+func (c *fakeInvokeClient) GrpcClient() pb.DaprClient {
+	return c.grpcClient
+}
+
+type fakeInvokeGrpcClient struct {
+	pb.DaprClient
+	requests []*pb.InvokeServiceRequest
+	headers  []metadata.MD
+}
+
+func (c *fakeInvokeGrpcClient) InvokeService(ctx context.Context, in *pb.InvokeServiceRequest, opts ...grpc.CallOption) (*commonv1pb.InvokeResponse, error) {
+	c.requests = append(c.requests, in)
+	md, _ := metadata.FromOutgoingContext(ctx)
+	c.headers = append(c.headers, md)
+
+	if in.Message.Method == "missing" {
+		s, _ := status.New(codes.NotFound, "not found").WithDetails(&errdetails.ErrorInfo{
+			Domain:   "dapr.io",
+			Metadata: map[string]string{"http.code": "404", "http.error_message": "no such order"},
+		})
+		return nil, s.Err()
+	}
+	for _, opt := range opts {
+		if h, ok := opt.(grpc.HeaderCallOption); ok {
+			*h.HeaderAddr = metadata.Pairs("dapr-http-status", "201")
+		}
+	}
+	return &commonv1pb.InvokeResponse{
+		Data:        &anypb.Any{Value: append([]byte("echo: "), in.Message.Data.Value...)},
+		ContentType: "text/plain",
+	}, nil
+}
+
+func TestInvoke(t *testing.T) {
+	funcCtx := `{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Knative",
+  "outputs": {
+    "orders": {
+      "appID": "order-service",
+      "uri": "orders",
+      "verb": "put",
+      "componentType": "invoke",
+      "metadata": {
+        "X-Tenant": "acme"
+      }
+    }
+  }
+}`
+	os.Setenv(ModeEnvName, SelfHostMode)
+	if err := os.Setenv(FunctionContextEnvName, funcCtx); err != nil {
+		t.Fatal("Error set function context env")
+	}
+	rtCtx, err := GetRuntimeContext()
+	if err != nil {
+		t.Fatalf("Error parse function context: %s", err.Error())
+	}
+	output := rtCtx.GetOutputs()["orders"]
+	if output.GetType() != OpenFuncInvoke || output.Method != "orders" || output.Verb != http.MethodPut {
+		t.Fatalf("Error parse service invocation output: %+v", output)
+	}
+	ctx := rtCtx.GetContext()
+	if _, err := ctx.Invoke("orders", []byte("hello")); !errors.Is(err, errDaprClientNotInitialized) {
+		t.Fatalf("Error invoke without dapr client: %v", err)
+	}
+	client := &fakeInvokeGrpcClient{}
+	ctx.daprClient = &fakeInvokeClient{grpcClient: client}
+
+	resp, err := ctx.Invoke("orders", []byte("hello"), InvokeWithQuery("id=1"), InvokeWithHeader("X-Request", "1"))
+	if err != nil {
+		t.Fatalf("Error invoke: %v", err)
+	}
+	if string(resp.Data) != "echo: hello" || resp.ContentType != "text/plain" || resp.StatusCode != http.StatusCreated {
+		t.Fatalf("Error invoke: unexpected response %+v", resp)
+	}
+	req := client.requests[0]
+	if req.Id != "order-service" || req.Message.Method != "orders" || req.Message.ContentType != "application/json" ||
+		req.Message.HttpExtension.Verb != commonv1pb.HTTPExtension_PUT || req.Message.HttpExtension.Querystring != "id=1" {
+		t.Fatalf("Error invoke: unexpected request %v", req)
+	}
+	if md := client.headers[0]; len(md.Get("x-tenant")) == 0 || md.Get("x-request")[0] != "1" {
+		t.Fatalf("Error invoke: unexpected headers %v", md)
+	}
+
+	// the failure of the app is returned with its status code
+	resp, err = ctx.Invoke("orders", nil, InvokeWithMethod("missing"))
+	if GetErrorStatusCode(err) != http.StatusNotFound || resp == nil || string(resp.Data) != "no such order" {
+		t.Fatalf("Error invoke missing method: %v", err)
+	}
+
+	// Send to a service invocation output invokes the app
+	data, err := ctx.Send("orders", []byte("hi"), SendWithOperation("orders/1"), SendWithContentType("text/plain"))
+	if err != nil || string(data) != "echo: hi" {
+		t.Fatalf("Error send to service invocation output: %v", err)
+	}
+	if req := client.requests[len(client.requests)-1]; req.Message.Method != "orders/1" || req.Message.ContentType != "text/plain" {
+		t.Fatalf("Error send to service invocation output: unexpected request %v", req)
+	}
+}
+
+func TestParseInvocation(t *testing.T) {
+	for _, output := range []*Output{
+		{ComponentType: "invoke", Uri: "orders"},
+		{ComponentType: "invoke", AppID: "order-service"},
+		{ComponentType: "invoke", AppID: "order-service", Uri: "orders", Verb: "fetch"},
+	} {
+		if err := output.parseInvocation(); err == nil {
+			t.Fatalf("Error parse service invocation: expected error of %+v", output)
+		}
+	}
+}
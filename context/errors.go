@@ -0,0 +1,129 @@
+package context
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// ErrFunctionPanic is wrapped by the error of an invocation recovered from a panic.
+var ErrFunctionPanic = errors.New("function panic")
+
+// Disposition tells the async runtime what to do with an event after it has been handled,
+// it maps to the SUCCESS, RETRY and DROP statuses of the Dapr pubsub.
+type Disposition string
+
+const (
+	DispositionSuccess Disposition = "SUCCESS"
+	DispositionRetry   Disposition = "RETRY"
+	DispositionDrop    Disposition = "DROP"
+)
+
+// Error is returned by the function to control how the runtimes respond to the failure,
+// the Knative runtime responds with the StatusCode and the async runtime follows the Disposition.
+type Error struct {
+	StatusCode  int
+	Disposition Disposition
+	Err         error
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return http.StatusText(e.StatusCode)
+	}
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// NewRetryableError marks err as a transient failure, the event will be redelivered
+// and the http request is responded with 503 Service Unavailable.
+func NewRetryableError(err error) error {
+	return &Error{
+		StatusCode:  http.StatusServiceUnavailable,
+		Disposition: DispositionRetry,
+		Err:         err,
+	}
+}
+
+// NewDropError marks err as a permanent failure, the event will not be redelivered
+// and the http request is responded with 422 Unprocessable Entity.
+func NewDropError(err error) error {
+	return &Error{
+		StatusCode:  http.StatusUnprocessableEntity,
+		Disposition: DispositionDrop,
+		Err:         err,
+	}
+}
+
+// NewHTTPError responds the http request with the status code and the message of err,
+// the event of the async runtime will be redelivered on 408, 429 and 5xx status codes.
+func NewHTTPError(statusCode int, err error) error {
+	disposition := DispositionDrop
+	if statusCode == http.StatusRequestTimeout || statusCode == http.StatusTooManyRequests || statusCode >= 500 {
+		disposition = DispositionRetry
+	}
+	return &Error{
+		StatusCode:  statusCode,
+		Disposition: disposition,
+		Err:         err,
+	}
+}
+
+// NewPanicError is the error of an invocation recovered from a panic, the event is retried or
+// dropped as the panic policy of the function and the http request is responded with 500.
+func NewPanicError(recovered interface{}, disposition Disposition) error {
+	return &Error{
+		StatusCode:  InternalError,
+		Disposition: disposition,
+		Err:         fmt.Errorf("%w: %v", ErrFunctionPanic, recovered),
+	}
+}
+
+// NewTimeoutError is the error of an invocation exceeding its timeout, which wraps context.DeadlineExceeded,
+// the event will be redelivered and the http request is responded with 504 Gateway Timeout.
+func NewTimeoutError(timeout time.Duration) error {
+	return &Error{
+		StatusCode:  http.StatusGatewayTimeout,
+		Disposition: DispositionRetry,
+		Err:         fmt.Errorf("function timed out after %s: %w", timeout, context.DeadlineExceeded),
+	}
+}
+
+// NewHTTPErrorf is NewHTTPError with a formatted message.
+func NewHTTPErrorf(statusCode int, format string, a ...interface{}) error {
+	return NewHTTPError(statusCode, fmt.Errorf(format, a...))
+}
+
+// GetErrorStatusCode returns the http status code of err, the errors not created by
+// this package are internal errors.
+func GetErrorStatusCode(err error) int {
+	var e *Error
+	if errors.As(err, &e) && e.StatusCode > 0 {
+		return e.StatusCode
+	}
+	return InternalError
+}
+
+// GetErrorDisposition returns how the async runtime handles the event failed with err,
+// the errors not created by this package are dropped as the Dapr SDK does.
+func GetErrorDisposition(err error) Disposition {
+	if err == nil {
+		return DispositionSuccess
+	}
+	var e *Error
+	if errors.As(err, &e) && e.Disposition != "" {
+		return e.Disposition
+	}
+	return DispositionDrop
+}
+
+// IsTypedError reports whether err or any error it wraps is an *Error.
+func IsTypedError(err error) bool {
+	var e *Error
+	return errors.As(err, &e)
+}
@@ -0,0 +1,44 @@
+package context
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestErrors(t *testing.T) {
+	cause := errors.New("cause")
+
+	for _, tc := range []struct {
+		name        string
+		err         error
+		statusCode  int
+		disposition Disposition
+	}{
+		{"nil", nil, InternalError, DispositionSuccess},
+		{"plain", cause, InternalError, DispositionDrop},
+		{"retryable", NewRetryableError(cause), http.StatusServiceUnavailable, DispositionRetry},
+		{"drop", NewDropError(cause), http.StatusUnprocessableEntity, DispositionDrop},
+		{"http not found", NewHTTPError(http.StatusNotFound, cause), http.StatusNotFound, DispositionDrop},
+		{"http too many requests", NewHTTPErrorf(http.StatusTooManyRequests, "slow down"), http.StatusTooManyRequests, DispositionRetry},
+		{"http bad gateway", NewHTTPError(http.StatusBadGateway, cause), http.StatusBadGateway, DispositionRetry},
+		{"wrapped", fmt.Errorf("wrapped: %w", NewRetryableError(cause)), http.StatusServiceUnavailable, DispositionRetry},
+	} {
+		if tc.err != nil {
+			if code := GetErrorStatusCode(tc.err); code != tc.statusCode {
+				t.Fatalf("%s: expected status code %d, got %d", tc.name, tc.statusCode, code)
+			}
+		}
+		if disposition := GetErrorDisposition(tc.err); disposition != tc.disposition {
+			t.Fatalf("%s: expected disposition %s, got %s", tc.name, tc.disposition, disposition)
+		}
+	}
+
+	if !errors.Is(NewDropError(cause), cause) {
+		t.Fatal("the typed error should wrap its cause")
+	}
+	if NewHTTPError(http.StatusForbidden, nil).Error() != http.StatusText(http.StatusForbidden) {
+		t.Fatal("the typed error without a cause should use the status text as message")
+	}
+}
@@ -0,0 +1,261 @@
+package context
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	commonv1pb "github.com/dapr/dapr/pkg/proto/common/v1"
+	pb "github.com/dapr/dapr/pkg/proto/runtime/v1"
+	"go.opentelemetry.io/otel/propagation"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
+	"k8s.io/klog/v2"
+)
+
+const (
+	defaultInvokeVerb = http.MethodPost
+	// the http status of the invoked app is returned by Dapr in this header, or in the error details on failure
+	daprHTTPStatusHeader  = "dapr-http-status"
+	errorInfoHTTPCode     = "http.code"
+	errorInfoHTTPError    = "http.error_message"
+	daprAPITokenEnvName   = "DAPR_API_TOKEN"
+	daprAPITokenHeader    = "dapr-api-token"
+	invokeContentTypeJSON = "application/json"
+)
+
+// InvokeResponse is the response of the app invoked by Invoke.
+type InvokeResponse struct {
+	Data        []byte
+	ContentType string
+	StatusCode  int
+}
+
+// InvokeOption configures a single call of Invoke.
+type InvokeOption func(*invokeOptions)
+
+type invokeOptions struct {
+	method      string
+	verb        string
+	query       string
+	contentType string
+	header      map[string]string
+}
+
+// InvokeWithMethod overrides the method of the output.
+func InvokeWithMethod(method string) InvokeOption {
+	return func(o *invokeOptions) {
+		o.method = method
+	}
+}
+
+// InvokeWithVerb overrides the http verb of the output.
+func InvokeWithVerb(verb string) InvokeOption {
+	return func(o *invokeOptions) {
+		o.verb = strings.ToUpper(verb)
+	}
+}
+
+// InvokeWithQuery sets the query string of the request, e.g. "id=1&sort=asc".
+func InvokeWithQuery(query string) InvokeOption {
+	return func(o *invokeOptions) {
+		o.query = query
+	}
+}
+
+// InvokeWithContentType sets the content type of the request, which is application/json by default.
+func InvokeWithContentType(contentType string) InvokeOption {
+	return func(o *invokeOptions) {
+		o.contentType = contentType
+	}
+}
+
+// InvokeWithHeader sets a header of the request, which is forwarded by Dapr to the invoked app.
+func InvokeWithHeader(key, value string) InvokeOption {
+	return func(o *invokeOptions) {
+		o.header[strings.ToLower(key)] = value
+	}
+}
+
+func newInvokeOptions(output *Output, opts ...InvokeOption) *invokeOptions {
+	o := &invokeOptions{
+		method:      output.Method,
+		verb:        output.Verb,
+		contentType: invokeContentTypeJSON,
+		header:      map[string]string{},
+	}
+	for k, v := range output.Metadata {
+		o.header[strings.ToLower(k)] = v
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// invokeOptions converts the options of Send to a service invocation output, the operation overrides
+// the method and the metadata are sent as headers.
+func (o *sendOptions) invokeOptions() []InvokeOption {
+	var opts []InvokeOption
+	if o.operation != "" {
+		opts = append(opts, InvokeWithMethod(o.operation))
+	}
+	if o.contentType != "" {
+		opts = append(opts, InvokeWithContentType(o.contentType))
+	}
+	for k, v := range o.metadata {
+		opts = append(opts, InvokeWithHeader(k, v))
+	}
+	return opts
+}
+
+// parseInvocation validates the service invocation output.
+func (o *Output) parseInvocation() error {
+	if o.AppID == "" {
+		return errors.New("app ID is required")
+	}
+	if o.Method == "" {
+		o.Method = o.Uri
+	}
+	if o.Method == "" {
+		return errors.New("method is required")
+	}
+	if o.Verb == "" {
+		o.Verb = defaultInvokeVerb
+	}
+	o.Verb = strings.ToUpper(o.Verb)
+	if _, ok := commonv1pb.HTTPExtension_Verb_value[o.Verb]; !ok || o.Verb == "NONE" {
+		return fmt.Errorf("invalid verb: %s", o.Verb)
+	}
+	return nil
+}
+
+func (ctx *FunctionContext) Invoke(outputName string, data []byte, opts ...InvokeOption) (*InvokeResponse, error) {
+	nativeContext := ctx.GetNativeContext()
+	if nativeContext == nil {
+		nativeContext = context.Background()
+	}
+	return ctx.InvokeWithContext(nativeContext, outputName, data, opts...)
+}
+
+func (ctx *FunctionContext) InvokeWithContext(c context.Context, outputName string, data []byte, opts ...InvokeOption) (*InvokeResponse, error) {
+	output, ok := ctx.Outputs[outputName]
+	if !ok {
+		return nil, fmt.Errorf("output %s not found", outputName)
+	}
+	if output.GetType() != OpenFuncInvoke {
+		return nil, fmt.Errorf("output %s is not a service invocation", outputName)
+	}
+	if ctx.daprClient == nil {
+		return nil, errDaprClientNotInitialized
+	}
+
+	o := newInvokeOptions(output, opts...)
+	start := time.Now()
+
+	// Set the exit span for tracing, the trace context is propagated in the headers
+	endSpan := func(error) {}
+	if IsTracingProviderSkyWalking(ctx) || IsTracingProviderOpenTelemetry(ctx) {
+		var err error
+		if endSpan, err = setExitSpan(ctx, c, propagation.MapCarrier(o.header), outputName); err != nil {
+			klog.Warningf("failed to set exit span: %v", err)
+		}
+	}
+
+	var resp *InvokeResponse
+	err := callWithResiliency(c, ctx, outputName, output, func(c context.Context) error {
+		var err error
+		resp, err = ctx.invokeService(c, output, data, o)
+		return err
+	})
+	endSpan(err)
+
+	notifySendObservers(ctx, outputName, output, time.Since(start), err)
+	return resp, err
+}
+
+// invokeService invokes the method of the app through Dapr, the failure of the app is returned as
+// an HTTP error with the status code of the app, so that the function can pass it to its caller.
+func (ctx *FunctionContext) invokeService(c context.Context, output *Output, data []byte, o *invokeOptions) (*InvokeResponse, error) {
+	client := ctx.daprClient.GrpcClient()
+	if client == nil {
+		return nil, errors.New("dapr grpc client is not available")
+	}
+
+	var kv []string
+	for k, v := range o.header {
+		kv = append(kv, k, v)
+	}
+	if token := os.Getenv(daprAPITokenEnvName); token != "" {
+		kv = append(kv, daprAPITokenHeader, token)
+	}
+	if len(kv) > 0 {
+		c = metadata.AppendToOutgoingContext(c, kv...)
+	}
+
+	req := &pb.InvokeServiceRequest{
+		Id: output.AppID,
+		Message: &commonv1pb.InvokeRequest{
+			Method:      o.method,
+			Data:        &anypb.Any{Value: data},
+			ContentType: o.contentType,
+			HttpExtension: &commonv1pb.HTTPExtension{
+				Verb:        commonv1pb.HTTPExtension_Verb(commonv1pb.HTTPExtension_Verb_value[o.verb]),
+				Querystring: o.query,
+			},
+		},
+	}
+
+	var header metadata.MD
+	out, err := client.InvokeService(c, req, grpc.Header(&header))
+	if err != nil {
+		return invokeError(output, o, err)
+	}
+
+	resp := &InvokeResponse{
+		ContentType: out.GetContentType(),
+		StatusCode:  http.StatusOK,
+	}
+	if out.GetData() != nil {
+		resp.Data = out.GetData().GetValue()
+	}
+	if values := header.Get(daprHTTPStatusHeader); len(values) > 0 {
+		if code, err := strconv.Atoi(values[0]); err == nil {
+			resp.StatusCode = code
+		}
+	}
+	return resp, nil
+}
+
+// invokeError returns the response of the failed app with its status code, the errors of Dapr are returned as they are.
+func invokeError(output *Output, o *invokeOptions, err error) (*InvokeResponse, error) {
+	s, ok := status.FromError(err)
+	if !ok {
+		return nil, err
+	}
+	for _, detail := range s.Details() {
+		info, ok := detail.(*errdetails.ErrorInfo)
+		if !ok {
+			continue
+		}
+		code, convErr := strconv.Atoi(info.GetMetadata()[errorInfoHTTPCode])
+		if convErr != nil {
+			continue
+		}
+		msg := info.GetMetadata()[errorInfoHTTPError]
+		resp := &InvokeResponse{Data: []byte(msg), StatusCode: code}
+		if msg == "" {
+			msg = http.StatusText(code)
+		}
+		return resp, NewHTTPErrorf(code, "failed to invoke method %s of app %s: %s", o.method, output.AppID, msg)
+	}
+	return nil, err
+}
@@ -0,0 +1,72 @@
+package context
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// SendObserver is notified every time Send finishes calling an output,
+// e.g. the metrics plugin counts the calls per output name and building block type.
+type SendObserver func(ctx RuntimeContext, outputName string, output *Output, duration time.Duration, err error)
+
+var (
+	sendObserversMu sync.RWMutex
+	sendObservers   []SendObserver
+
+	resiliencyObserversMu sync.RWMutex
+	resiliencyObservers   []ResiliencyObserver
+
+	runtimeContextsMu sync.RWMutex
+	runtimeContexts   []RuntimeContext
+)
+
+// ResiliencyObserver is notified of the decisions made by the resiliency policies of the outputs in Send,
+// c is the context of the Send carrying the span of the invocation.
+type ResiliencyObserver func(c context.Context, ctx RuntimeContext, event *ResiliencyEvent)
+
+// AddSendObserver registers an observer for the output calls of all functions in the process.
+func AddSendObserver(observer SendObserver) {
+	sendObserversMu.Lock()
+	defer sendObserversMu.Unlock()
+	sendObservers = append(sendObservers, observer)
+}
+
+func notifySendObservers(ctx RuntimeContext, outputName string, output *Output, duration time.Duration, err error) {
+	sendObserversMu.RLock()
+	defer sendObserversMu.RUnlock()
+	for _, observer := range sendObservers {
+		observer(ctx, outputName, output, duration, err)
+	}
+}
+
+// AddResiliencyObserver registers an observer for the resiliency decisions of all functions in the process.
+func AddResiliencyObserver(observer ResiliencyObserver) {
+	resiliencyObserversMu.Lock()
+	defer resiliencyObserversMu.Unlock()
+	resiliencyObservers = append(resiliencyObservers, observer)
+}
+
+func notifyResiliencyObservers(c context.Context, ctx RuntimeContext, event *ResiliencyEvent) {
+	resiliencyObserversMu.RLock()
+	defer resiliencyObserversMu.RUnlock()
+	for _, observer := range resiliencyObservers {
+		observer(c, ctx, event)
+	}
+}
+
+// VisitRuntimeContexts calls fn with each function context parsed in the process, e.g. the metrics plugin
+// exports the in-flight invocations counted by GetInFlight, which the clones of a context share.
+func VisitRuntimeContexts(fn func(ctx RuntimeContext)) {
+	runtimeContextsMu.RLock()
+	defer runtimeContextsMu.RUnlock()
+	for _, ctx := range runtimeContexts {
+		fn(ctx)
+	}
+}
+
+func addRuntimeContext(ctx RuntimeContext) {
+	runtimeContextsMu.Lock()
+	defer runtimeContextsMu.Unlock()
+	runtimeContexts = append(runtimeContexts, ctx)
+}
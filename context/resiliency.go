@@ -0,0 +1,287 @@
+package context
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"math/rand"
+	"sync"
+	"time"
+)
+
+const (
+	defaultInitialInterval       = 100 * time.Millisecond
+	defaultMaxInterval           = 10 * time.Second
+	defaultMultiplier            = 2
+	defaultJitter                = 0.5
+	defaultCircuitBreakerTimeout = 30 * time.Second
+)
+
+const (
+	CircuitBreakerStateClosed   = "closed"
+	CircuitBreakerStateOpen     = "open"
+	CircuitBreakerStateHalfOpen = "half-open"
+)
+
+// ErrCircuitOpen is wrapped by the error of Send rejected by the open circuit breaker of an output.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// ResiliencyPolicy is enforced by Send on every call to the output, the durations are in the format of time.ParseDuration.
+type ResiliencyPolicy struct {
+	// MaxAttempts is the number of attempts including the first one, the call is not retried if it is 0 or 1.
+	MaxAttempts int `json:"maxAttempts,omitempty"`
+	// InitialInterval is the backoff before the first retry, 100ms by default.
+	InitialInterval string `json:"initialInterval,omitempty"`
+	// MaxInterval caps the backoff, 10s by default.
+	MaxInterval string `json:"maxInterval,omitempty"`
+	// Multiplier grows the backoff after each retry, 2 by default.
+	Multiplier float64 `json:"multiplier,omitempty"`
+	// Jitter randomizes the backoff by the fraction between 0 and 1, 0.5 by default.
+	Jitter *float64 `json:"jitter,omitempty"`
+	// Timeout bounds each attempt, the attempts are not bounded if it is empty.
+	Timeout string `json:"timeout,omitempty"`
+	// CircuitBreaker stops calling the output after consecutive failures.
+	CircuitBreaker *CircuitBreakerPolicy `json:"circuitBreaker,omitempty"`
+
+	initialInterval time.Duration
+	maxInterval     time.Duration
+	timeout         time.Duration
+	breaker         *circuitBreaker
+}
+
+// CircuitBreakerPolicy opens the circuit after ConsecutiveFailures failed attempts, the calls are rejected
+// until Timeout elapses, then a trial call closes the circuit on success or opens it again on failure.
+type CircuitBreakerPolicy struct {
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+	// Timeout is how long the circuit stays open, 30s by default.
+	Timeout string `json:"timeout,omitempty"`
+}
+
+// ResiliencyDecision is a decision made by the resiliency policy of an output in Send.
+type ResiliencyDecision string
+
+const (
+	// ResiliencyRetry retries the call after the backoff.
+	ResiliencyRetry ResiliencyDecision = "retry"
+	// ResiliencyGiveUp returns the error of the last attempt to the user.
+	ResiliencyGiveUp ResiliencyDecision = "give_up"
+	// ResiliencyCircuitOpen opens the circuit breaker after the consecutive failures.
+	ResiliencyCircuitOpen ResiliencyDecision = "circuit_open"
+	// ResiliencyCircuitClose closes the circuit breaker after a successful trial call.
+	ResiliencyCircuitClose ResiliencyDecision = "circuit_close"
+	// ResiliencyCircuitReject rejects the call while the circuit breaker is open.
+	ResiliencyCircuitReject ResiliencyDecision = "circuit_reject"
+)
+
+// ResiliencyEvent describes a decision of the resiliency policy.
+type ResiliencyEvent struct {
+	OutputName string
+	Output     *Output
+	Decision   ResiliencyDecision
+	// Attempt is the number of the attempt the decision is made after, it is 0 for a rejected call.
+	Attempt int
+	// Backoff is the delay before the next attempt of a retry.
+	Backoff time.Duration
+	Err     error
+}
+
+// parse validates the policy and sets the defaults.
+func (p *ResiliencyPolicy) parse() error {
+	var err error
+	if p.MaxAttempts < 0 {
+		return fmt.Errorf("invalid max attempts: %d", p.MaxAttempts)
+	}
+	if p.initialInterval, err = parsePolicyDuration(p.InitialInterval, defaultInitialInterval); err != nil {
+		return fmt.Errorf("error parsing initial interval: %s", p.InitialInterval)
+	}
+	if p.maxInterval, err = parsePolicyDuration(p.MaxInterval, defaultMaxInterval); err != nil {
+		return fmt.Errorf("error parsing max interval: %s", p.MaxInterval)
+	}
+	if p.timeout, err = parsePolicyDuration(p.Timeout, 0); err != nil {
+		return fmt.Errorf("error parsing timeout: %s", p.Timeout)
+	}
+	if p.Multiplier == 0 {
+		p.Multiplier = defaultMultiplier
+	} else if p.Multiplier < 1 {
+		return fmt.Errorf("invalid multiplier: %v", p.Multiplier)
+	}
+	if p.Jitter == nil {
+		jitter := defaultJitter
+		p.Jitter = &jitter
+	} else if *p.Jitter < 0 || *p.Jitter > 1 {
+		return fmt.Errorf("invalid jitter: %v", *p.Jitter)
+	}
+
+	if cb := p.CircuitBreaker; cb != nil {
+		if cb.ConsecutiveFailures <= 0 {
+			return fmt.Errorf("invalid consecutive failures of circuit breaker: %d", cb.ConsecutiveFailures)
+		}
+		timeout, err := parsePolicyDuration(cb.Timeout, defaultCircuitBreakerTimeout)
+		if err != nil {
+			return fmt.Errorf("error parsing timeout of circuit breaker: %s", cb.Timeout)
+		}
+		p.breaker = &circuitBreaker{
+			threshold: cb.ConsecutiveFailures,
+			timeout:   timeout,
+			state:     CircuitBreakerStateClosed,
+		}
+	}
+	return nil
+}
+
+func parsePolicyDuration(value string, defaultValue time.Duration) (time.Duration, error) {
+	if value == "" {
+		return defaultValue, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil || d < 0 {
+		return 0, errors.New("invalid duration")
+	}
+	return d, nil
+}
+
+// backoff returns the exponential delay before the retry following the attempt, randomized by the jitter.
+func (p *ResiliencyPolicy) backoff(attempt int) time.Duration {
+	interval := float64(p.initialInterval) * math.Pow(p.Multiplier, float64(attempt-1))
+	if interval > float64(p.maxInterval) {
+		interval = float64(p.maxInterval)
+	}
+	delta := *p.Jitter * interval
+	return time.Duration(interval - delta + rand.Float64()*2*delta)
+}
+
+// GetCircuitBreakerState returns the state of the circuit breaker,
+// it is empty if the policy has no circuit breaker.
+func (p *ResiliencyPolicy) GetCircuitBreakerState() string {
+	if p == nil || p.breaker == nil {
+		return ""
+	}
+	return p.breaker.getState()
+}
+
+// circuitBreaker is shared by all invocations of the function calling the output.
+type circuitBreaker struct {
+	mu        sync.Mutex
+	threshold int
+	timeout   time.Duration
+	state     string
+	failures  int
+	openedAt  time.Time
+	trial     bool
+}
+
+func (cb *circuitBreaker) getState() string {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if cb.state == CircuitBreakerStateOpen && time.Since(cb.openedAt) >= cb.timeout {
+		return CircuitBreakerStateHalfOpen
+	}
+	return cb.state
+}
+
+// allow reports whether a call is allowed, only one trial call is allowed when the open circuit times out.
+func (cb *circuitBreaker) allow() bool {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	switch cb.state {
+	case CircuitBreakerStateOpen:
+		if time.Since(cb.openedAt) < cb.timeout {
+			return false
+		}
+		cb.state = CircuitBreakerStateHalfOpen
+		cb.trial = true
+		return true
+	case CircuitBreakerStateHalfOpen:
+		if cb.trial {
+			return false
+		}
+		cb.trial = true
+		return true
+	}
+	return true
+}
+
+// done records the result of an allowed call and returns the decision if the state of the circuit changes.
+func (cb *circuitBreaker) done(err error) ResiliencyDecision {
+	cb.mu.Lock()
+	defer cb.mu.Unlock()
+	if err == nil {
+		cb.failures = 0
+		if cb.state != CircuitBreakerStateClosed {
+			cb.state = CircuitBreakerStateClosed
+			cb.trial = false
+			return ResiliencyCircuitClose
+		}
+		return ""
+	}
+
+	cb.failures++
+	if cb.state == CircuitBreakerStateHalfOpen || cb.failures >= cb.threshold {
+		cb.state = CircuitBreakerStateOpen
+		cb.openedAt = time.Now()
+		cb.trial = false
+		return ResiliencyCircuitOpen
+	}
+	return ""
+}
+
+// callWithResiliency calls the output with the resiliency policy of the output, call is given the context of the attempt.
+func callWithResiliency(c context.Context, ctx RuntimeContext, outputName string, output *Output, call func(context.Context) error) error {
+	policy := output.Resiliency
+	if policy == nil {
+		return call(c)
+	}
+
+	maxAttempts := policy.MaxAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if policy.breaker != nil && !policy.breaker.allow() {
+			err = fmt.Errorf("output %s: %w", outputName, ErrCircuitOpen)
+			notifyResiliencyObservers(c, ctx, &ResiliencyEvent{OutputName: outputName, Output: output, Decision: ResiliencyCircuitReject, Attempt: attempt - 1, Err: err})
+			return err
+		}
+
+		err = callAttempt(c, policy.timeout, call)
+
+		if policy.breaker != nil {
+			if decision := policy.breaker.done(err); decision != "" {
+				notifyResiliencyObservers(c, ctx, &ResiliencyEvent{OutputName: outputName, Output: output, Decision: decision, Attempt: attempt, Err: err})
+			}
+		}
+		if err == nil {
+			return nil
+		}
+
+		// the user gives up when the context of Send is done, and the errors to be dropped are permanent
+		if attempt >= maxAttempts || c.Err() != nil || (IsTypedError(err) && GetErrorDisposition(err) == DispositionDrop) {
+			if maxAttempts > 1 {
+				notifyResiliencyObservers(c, ctx, &ResiliencyEvent{OutputName: outputName, Output: output, Decision: ResiliencyGiveUp, Attempt: attempt, Err: err})
+			}
+			return err
+		}
+
+		backoff := policy.backoff(attempt)
+		notifyResiliencyObservers(c, ctx, &ResiliencyEvent{OutputName: outputName, Output: output, Decision: ResiliencyRetry, Attempt: attempt, Backoff: backoff, Err: err})
+		timer := time.NewTimer(backoff)
+		select {
+		case <-timer.C:
+		case <-c.Done():
+			timer.Stop()
+			return err
+		}
+	}
+}
+
+func callAttempt(c context.Context, timeout time.Duration, call func(context.Context) error) error {
+	if timeout <= 0 {
+		return call(c)
+	}
+	attemptCtx, cancel := context.WithTimeout(c, timeout)
+	defer cancel()
+	return call(attemptCtx)
+}
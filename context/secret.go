@@ -0,0 +1,105 @@
+package context
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// secretRefPattern matches the references to secrets in the metadata, e.g. {{secret:vault/kafka-password}}
+// refers to the kafka-password secret of the vault secret store.
+var secretRefPattern = regexp.MustCompile(`\{\{secret:([^/{}]+)/([^{}]+)\}\}`)
+
+// SecretStore is a Dapr secret store declared in the secretStores of FUNC_CONTEXT, such as secretstores.hashicorp.vault,
+// the functions refer to it by its name in the secretStores.
+type SecretStore struct {
+	ComponentName string            `json:"componentName"`
+	ComponentType string            `json:"componentType"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+}
+
+func (ctx *FunctionContext) getSecretStore(storeName string) (*SecretStore, error) {
+	store, ok := ctx.SecretStores[storeName]
+	if !ok {
+		return nil, fmt.Errorf("secret store %s not found", storeName)
+	}
+	if ctx.daprClient == nil {
+		return nil, errDaprClientNotInitialized
+	}
+	return store, nil
+}
+
+func (ctx *FunctionContext) GetSecret(storeName, key string) (map[string]string, error) {
+	store, err := ctx.getSecretStore(storeName)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.daprClient.GetSecret(ctx.stateContext(), store.ComponentName, key, store.Metadata)
+}
+
+func (ctx *FunctionContext) GetBulkSecret(storeName string) (map[string]map[string]string, error) {
+	store, err := ctx.getSecretStore(storeName)
+	if err != nil {
+		return nil, err
+	}
+	return ctx.daprClient.GetBulkSecret(ctx.stateContext(), store.ComponentName, store.Metadata)
+}
+
+// resolveSecretRefs replaces the secret references in the metadata of the inputs, outputs and state stores
+// with the secrets, so that the credentials never appear in FUNC_CONTEXT.
+func (ctx *FunctionContext) resolveSecretRefs() error {
+	var metadata []map[string]string
+	for _, in := range ctx.Inputs {
+		metadata = append(metadata, in.Metadata)
+	}
+	for _, out := range ctx.Outputs {
+		metadata = append(metadata, out.Metadata)
+	}
+	for _, store := range ctx.States {
+		metadata = append(metadata, store.Metadata)
+	}
+
+	for _, md := range metadata {
+		for k, v := range md {
+			resolved, err := ctx.resolveSecretRef(v)
+			if err != nil {
+				return fmt.Errorf("error resolving metadata %s: %s", k, err.Error())
+			}
+			md[k] = resolved
+		}
+	}
+	return nil
+}
+
+// resolveSecretRef replaces the secret references in value, a secret with multiple values
+// is referred to by the key of the value, e.g. {{secret:kubernetes/kafka/password}}.
+func (ctx *FunctionContext) resolveSecretRef(value string) (string, error) {
+	var err error
+	resolved := secretRefPattern.ReplaceAllStringFunc(value, func(ref string) string {
+		if err != nil {
+			return ref
+		}
+		match := secretRefPattern.FindStringSubmatch(ref)
+		storeName, name, key := match[1], match[2], match[2]
+		if parts := strings.SplitN(name, "/", 2); len(parts) == 2 {
+			name, key = parts[0], parts[1]
+		}
+
+		var secret map[string]string
+		if secret, err = ctx.GetSecret(storeName, name); err != nil {
+			err = fmt.Errorf("failed to get secret %s of store %s: %v", name, storeName, err)
+			return ref
+		}
+		if v, ok := secret[key]; ok {
+			return v
+		}
+		if len(secret) == 1 {
+			for _, v := range secret {
+				return v
+			}
+		}
+		err = fmt.Errorf("secret %s of store %s has no value %s", name, storeName, key)
+		return ref
+	})
+	return resolved, err
+}
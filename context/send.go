@@ -0,0 +1,105 @@
+package context
+
+import (
+	"math"
+	"strconv"
+	"time"
+)
+
+const (
+	// the metadata keys understood by the Dapr components
+	partitionKeyMetadataKey = "partitionKey"
+	ttlMetadataKey          = "ttlInSeconds"
+	contentTypeMetadataKey  = "contentType"
+)
+
+// SendOption configures a single call of Send, the options override the settings of the output in FUNC_CONTEXT.
+type SendOption func(*sendOptions)
+
+type sendOptions struct {
+	metadata     map[string]string
+	operation    string
+	contentType  string
+	partitionKey string
+	ttl          time.Duration
+}
+
+// SendWithMetadata merges metadata over the metadata of the output.
+func SendWithMetadata(metadata map[string]string) SendOption {
+	return func(o *sendOptions) {
+		if o.metadata == nil {
+			o.metadata = map[string]string{}
+		}
+		for k, v := range metadata {
+			o.metadata[k] = v
+		}
+	}
+}
+
+// SendWithOperation overrides the operation of a binding output, such as create, get or delete.
+func SendWithOperation(operation string) SendOption {
+	return func(o *sendOptions) {
+		o.operation = operation
+	}
+}
+
+// SendWithContentType sets the content type of the data, which is the data content type of
+// the event published onto a topic, and the contentType metadata of a binding.
+func SendWithContentType(contentType string) SendOption {
+	return func(o *sendOptions) {
+		o.contentType = contentType
+	}
+}
+
+// SendWithPartitionKey sets the partitionKey metadata, e.g. the key of a Kafka message.
+func SendWithPartitionKey(key string) SendOption {
+	return func(o *sendOptions) {
+		o.partitionKey = key
+	}
+}
+
+// SendWithTTL sets the ttlInSeconds metadata, the ttl is rounded up to whole seconds.
+func SendWithTTL(ttl time.Duration) SendOption {
+	return func(o *sendOptions) {
+		o.ttl = ttl
+	}
+}
+
+func newSendOptions(opts ...SendOption) *sendOptions {
+	o := &sendOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// apply returns a copy of the output with the operation and the metadata of this call.
+func (o *sendOptions) apply(output *Output) *Output {
+	target := *output
+	if o.operation != "" {
+		target.Operation = o.operation
+	}
+
+	metadata := map[string]string{}
+	for k, v := range output.Metadata {
+		metadata[k] = v
+	}
+	for k, v := range o.metadata {
+		metadata[k] = v
+	}
+	if o.partitionKey != "" {
+		metadata[partitionKeyMetadataKey] = o.partitionKey
+	}
+	if o.ttl > 0 {
+		metadata[ttlMetadataKey] = strconv.FormatInt(int64(math.Ceil(o.ttl.Seconds())), 10)
+	}
+	if o.contentType != "" && output.GetType() == OpenFuncBinding {
+		metadata[contentTypeMetadataKey] = o.contentType
+	}
+	if len(metadata) > 0 {
+		target.Metadata = metadata
+	} else {
+		target.Metadata = nil
+	}
+	return &target
+}
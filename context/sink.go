@@ -0,0 +1,178 @@
+package context
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// TransportKnative sends the CloudEvents to a Knative Eventing sink, e.g. a broker or a channel.
+	TransportKnative = "knative"
+
+	// the environment variables injected by the SinkBinding of Knative Eventing
+	sinkEnvName        = "K_SINK"
+	ceOverridesEnvName = "K_CE_OVERRIDES"
+
+	// the retries of the cloudevents client if the output has no resiliency policy
+	sinkRetryDelay = 50 * time.Millisecond
+	sinkMaxRetries = 3
+)
+
+// getSink returns the uri of the output, or the sink injected by the SinkBinding.
+func (o *Output) getSink() string {
+	if o.Uri != "" {
+		return o.Uri
+	}
+	return os.Getenv(sinkEnvName)
+}
+
+// parseSink validates the sink of a knative output.
+func (o *Output) parseSink() error {
+	sink := o.getSink()
+	if sink == "" {
+		return fmt.Errorf("the uri is not set and %s is empty", sinkEnvName)
+	}
+	u, err := url.Parse(sink)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("invalid sink: %s", sink)
+	}
+	return nil
+}
+
+// newOutputEvent returns the CloudEvent sent to the output, which is the CloudEvent built by
+// the user, or a new InnerEvent of the function carrying the data.
+func newOutputEvent(req *OutputRequest) cloudevents.Event {
+	if req.Event != nil {
+		return req.Event.Clone()
+	}
+	event := NewInnerEvent(req.Context).GetCloudEvent()
+	event.SetSubject(req.OutputName)
+	contentType := req.ContentType
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	// keep the data as it is instead of data_base64 in structured mode
+	event.SetDataContentType(contentType)
+	event.DataEncoded = req.Data
+	return event
+}
+
+// KnativeTransport sends the CloudEvents to the uri of the output, or the K_SINK of the SinkBinding,
+// in binary mode by default or in structured mode. The metadata of the output, including the
+// trace context, are sent as the extensions of the CloudEvent.
+type KnativeTransport struct {
+	once   sync.Once
+	client cloudevents.Client
+	err    error
+	opts   []cehttp.Option
+}
+
+// NewKnativeTransport creates a KnativeTransport with the options of the cloudevents http protocol.
+func NewKnativeTransport(opts ...cehttp.Option) *KnativeTransport {
+	return &KnativeTransport{opts: opts}
+}
+
+func (t *KnativeTransport) getClient() (cloudevents.Client, error) {
+	t.once.Do(func() {
+		t.client, t.err = cloudevents.NewClientHTTP(t.opts...)
+	})
+	return t.client, t.err
+}
+
+func (t *KnativeTransport) Send(c context.Context, req *OutputRequest) ([]byte, error) {
+	client, err := t.getClient()
+	if err != nil {
+		return nil, err
+	}
+
+	target := req.Output
+	sink := target.getSink()
+	event := newOutputEvent(req)
+	for k, v := range target.Metadata {
+		event.SetExtension(extensionName(k), v)
+	}
+	if err := applyCEOverrides(&event); err != nil {
+		klog.Warningf("failed to apply %s: %v", ceOverridesEnvName, err)
+	}
+
+	c = cloudevents.ContextWithTarget(c, sink)
+	if target.CloudEventMode == CloudEventModeStructured {
+		c = binding.WithForceStructured(c)
+	} else {
+		c = binding.WithForceBinary(c)
+	}
+	// the resiliency policy of the output retries the whole call instead
+	if target.Resiliency == nil {
+		c = cloudevents.ContextWithRetriesExponentialBackoff(c, sinkRetryDelay, sinkMaxRetries)
+	}
+
+	reply, result := client.Request(c, event)
+	if code := sinkStatusCode(result); code != 0 && (code < 200 || code >= 300) {
+		return nil, NewHTTPErrorf(code, "sink %s responded %d: %v", sink, code, result)
+	}
+	if !cloudevents.IsACK(result) {
+		return nil, result
+	}
+	if reply != nil {
+		return reply.Data(), nil
+	}
+	return nil, nil
+}
+
+// sinkStatusCode returns the http status code of the result of the cloudevents client.
+func sinkStatusCode(result error) int {
+	var rr *cehttp.RetriesResult
+	if errors.As(result, &rr) {
+		result = rr.Result
+	}
+	var hr *cehttp.Result
+	if errors.As(result, &hr) {
+		return hr.StatusCode
+	}
+	return 0
+}
+
+// extensionName converts the key of the metadata to the name of a CloudEvent extension,
+// which consists of lower-case letters and digits.
+func extensionName(key string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			return r
+		case r >= 'A' && r <= 'Z':
+			return r + 'a' - 'A'
+		default:
+			return -1
+		}
+	}, key)
+}
+
+// applyCEOverrides sets the extensions of K_CE_OVERRIDES injected by the SinkBinding.
+func applyCEOverrides(event *cloudevents.Event) error {
+	data := os.Getenv(ceOverridesEnvName)
+	if data == "" {
+		return nil
+	}
+	overrides := struct {
+		Extensions map[string]string `json:"extensions"`
+	}{}
+	if err := json.Unmarshal([]byte(data), &overrides); err != nil {
+		return err
+	}
+	for k, v := range overrides.Extensions {
+		event.SetExtension(k, v)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package context
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	dapr "github.com/dapr/go-sdk/client"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StateStore is a Dapr state store declared in the states of FUNC_CONTEXT, such as state.redis,
+// the functions refer to it by its name in the states.
+type StateStore struct {
+	ComponentName string            `json:"componentName"`
+	ComponentType string            `json:"componentType"`
+	Metadata      map[string]string `json:"metadata,omitempty"`
+}
+
+// The state items of the Dapr client.
+type (
+	StateItem        = dapr.StateItem
+	BulkStateItem    = dapr.BulkStateItem
+	SetStateItem     = dapr.SetStateItem
+	DeleteStateItem  = dapr.DeleteStateItem
+	StateOperation   = dapr.StateOperation
+	ETag             = dapr.ETag
+	StateConsistency = dapr.StateConsistency
+)
+
+const (
+	StateOperationUpsert     = dapr.StateOperationTypeUpsert
+	StateOperationDelete     = dapr.StateOperationTypeDelete
+	StateConsistencyEventual = dapr.StateConsistencyEventual
+	StateConsistencyStrong   = dapr.StateConsistencyStrong
+)
+
+// StateOption configures a single state operation.
+type StateOption func(*stateOptions)
+
+type stateOptions struct {
+	metadata    map[string]string
+	etag        *ETag
+	consistency StateConsistency
+	parallelism int32
+}
+
+// StateWithMetadata merges metadata over the metadata of the state store.
+func StateWithMetadata(metadata map[string]string) StateOption {
+	return func(o *stateOptions) {
+		if o.metadata == nil {
+			o.metadata = map[string]string{}
+		}
+		for k, v := range metadata {
+			o.metadata[k] = v
+		}
+	}
+}
+
+// StateWithETag saves or deletes the state only if its ETag matches,
+// the operation fails with an error satisfying IsETagMismatch otherwise.
+func StateWithETag(etag string) StateOption {
+	return func(o *stateOptions) {
+		o.etag = &ETag{Value: etag}
+	}
+}
+
+// StateWithConsistency sets the consistency of the operation, the state store decides it by default.
+func StateWithConsistency(consistency StateConsistency) StateOption {
+	return func(o *stateOptions) {
+		o.consistency = consistency
+	}
+}
+
+// StateWithParallelism sets the number of the keys GetBulkState gets in parallel.
+func StateWithParallelism(parallelism int32) StateOption {
+	return func(o *stateOptions) {
+		o.parallelism = parallelism
+	}
+}
+
+// IsETagMismatch reports whether the state operation failed because the ETag does not match.
+func IsETagMismatch(err error) bool {
+	for ; err != nil; err = errors.Unwrap(err) {
+		if s, ok := status.FromError(err); ok {
+			return s.Code() == codes.Aborted
+		}
+	}
+	return false
+}
+
+func newStateOptions(store *StateStore, opts ...StateOption) *stateOptions {
+	o := &stateOptions{}
+	StateWithMetadata(store.Metadata)(o)
+	for _, opt := range opts {
+		opt(o)
+	}
+	return o
+}
+
+// stateOptions returns the options of the Dapr client, the first write wins if the ETag is given.
+func (o *stateOptions) stateOptions() *dapr.StateOptions {
+	so := &dapr.StateOptions{
+		Concurrency: dapr.StateConcurrencyLastWrite,
+		Consistency: o.consistency,
+	}
+	if o.etag != nil {
+		so.Concurrency = dapr.StateConcurrencyFirstWrite
+	}
+	return so
+}
+
+// mergeMetadata merges the metadata of the item over the metadata of the state store.
+func mergeMetadata(base map[string]string, metadata map[string]string) map[string]string {
+	if len(base) == 0 {
+		return metadata
+	}
+	merged := map[string]string{}
+	for k, v := range base {
+		merged[k] = v
+	}
+	for k, v := range metadata {
+		merged[k] = v
+	}
+	return merged
+}
+
+// getStateStore returns the state store and the dapr client for the state operations.
+func (ctx *FunctionContext) getStateStore(storeName string) (*StateStore, dapr.Client, error) {
+	store, ok := ctx.States[storeName]
+	if !ok {
+		return nil, nil, fmt.Errorf("state store %s not found", storeName)
+	}
+	if ctx.daprClient == nil {
+		return nil, nil, errDaprClientNotInitialized
+	}
+	return store, ctx.daprClient, nil
+}
+
+func (ctx *FunctionContext) stateContext() context.Context {
+	if c := ctx.GetNativeContext(); c != nil {
+		return c
+	}
+	return context.Background()
+}
+
+func (ctx *FunctionContext) GetState(storeName, key string, opts ...StateOption) (*StateItem, error) {
+	store, client, err := ctx.getStateStore(storeName)
+	if err != nil {
+		return nil, err
+	}
+	o := newStateOptions(store, opts...)
+	if o.consistency != dapr.StateConsistencyUndefined {
+		return client.GetStateWithConsistency(ctx.stateContext(), store.ComponentName, key, o.metadata, o.consistency)
+	}
+	return client.GetState(ctx.stateContext(), store.ComponentName, key, o.metadata)
+}
+
+func (ctx *FunctionContext) SaveState(storeName, key string, value []byte, opts ...StateOption) error {
+	store, client, err := ctx.getStateStore(storeName)
+	if err != nil {
+		return err
+	}
+	o := newStateOptions(store, opts...)
+	return client.SaveBulkState(ctx.stateContext(), store.ComponentName, &SetStateItem{
+		Key:      key,
+		Value:    value,
+		Etag:     o.etag,
+		Metadata: o.metadata,
+		Options:  o.stateOptions(),
+	})
+}
+
+func (ctx *FunctionContext) DeleteState(storeName, key string, opts ...StateOption) error {
+	store, client, err := ctx.getStateStore(storeName)
+	if err != nil {
+		return err
+	}
+	o := newStateOptions(store, opts...)
+	return client.DeleteStateWithETag(ctx.stateContext(), store.ComponentName, key, o.etag, o.metadata, o.stateOptions())
+}
+
+func (ctx *FunctionContext) GetBulkState(storeName string, keys []string, opts ...StateOption) ([]*BulkStateItem, error) {
+	store, client, err := ctx.getStateStore(storeName)
+	if err != nil {
+		return nil, err
+	}
+	o := newStateOptions(store, opts...)
+	return client.GetBulkState(ctx.stateContext(), store.ComponentName, keys, o.metadata, o.parallelism)
+}
+
+func (ctx *FunctionContext) SaveBulkState(storeName string, items ...*SetStateItem) error {
+	store, client, err := ctx.getStateStore(storeName)
+	if err != nil {
+		return err
+	}
+	// the items of the caller are not changed by the metadata of the store
+	sent := make([]*SetStateItem, 0, len(items))
+	for _, item := range items {
+		copied := *item
+		copied.Metadata = mergeMetadata(store.Metadata, item.Metadata)
+		sent = append(sent, &copied)
+	}
+	return client.SaveBulkState(ctx.stateContext(), store.ComponentName, sent...)
+}
+
+func (ctx *FunctionContext) DeleteBulkState(storeName string, items ...*DeleteStateItem) error {
+	store, client, err := ctx.getStateStore(storeName)
+	if err != nil {
+		return err
+	}
+	sent := make([]*DeleteStateItem, 0, len(items))
+	for _, item := range items {
+		copied := *item
+		copied.Metadata = mergeMetadata(store.Metadata, item.Metadata)
+		sent = append(sent, &copied)
+	}
+	return client.DeleteBulkStateItems(ctx.stateContext(), store.ComponentName, sent)
+}
+
+func (ctx *FunctionContext) ExecuteStateTransaction(storeName string, ops []*StateOperation, opts ...StateOption) error {
+	store, client, err := ctx.getStateStore(storeName)
+	if err != nil {
+		return err
+	}
+	o := newStateOptions(store, opts...)
+	return client.ExecuteStateTransaction(ctx.stateContext(), store.ComponentName, o.metadata, ops)
+}
@@ -0,0 +1,278 @@
+package context
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	dapr "github.com/dapr/go-sdk/client"
+)
+
+// The transports shipped with the framework, an output selects its transport by the transport field in FUNC_CONTEXT.
+const (
+	// TransportDapr calls the Dapr bindings and pubsub of the output, it is the default transport.
+	TransportDapr = "dapr"
+	// TransportHTTP posts the data to the uri of the output, e.g. a webhook.
+	TransportHTTP = "http"
+	// TransportMemory keeps the data in memory, for the tests and the self-hosted mode.
+	TransportMemory = "memory"
+)
+
+// defaultHTTPTimeout bounds the requests of the http transport, unless the resiliency policy
+// of the output sets the timeout of the attempts.
+const defaultHTTPTimeout = 30 * time.Second
+
+// The modes of the CloudEvents sent by the http transport, the data is sent as it is by default.
+const (
+	CloudEventModeBinary     = "binary"
+	CloudEventModeStructured = "structured"
+)
+
+// OutputRequest is a call of Send to an output.
+type OutputRequest struct {
+	// Context is the context of the function calling Send.
+	Context    RuntimeContext
+	OutputName string
+	// Output is a copy of the output with the options of Send applied, e.g. the metadata and the operation.
+	Output      *Output
+	Data        []byte
+	ContentType string
+	// Event is the CloudEvent of SendCloudEvent, the Data is the event in the structured format.
+	Event *cloudevents.Event
+}
+
+// OutputTransport sends the data of Send to an output.
+type OutputTransport interface {
+	Send(c context.Context, req *OutputRequest) ([]byte, error)
+}
+
+var (
+	transportsMu sync.RWMutex
+	transports   = map[string]OutputTransport{
+		TransportDapr:    daprTransport{},
+		TransportHTTP:    NewHTTPTransport(http.DefaultClient),
+		TransportMemory:  DefaultMemoryTransport,
+		TransportKnative: NewKnativeTransport(),
+	}
+)
+
+// RegisterTransport registers a transport for the outputs whose transport is name,
+// the transports should be registered before the function context is parsed.
+func RegisterTransport(name string, transport OutputTransport) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+	transports[name] = transport
+}
+
+func getTransport(name string) (OutputTransport, bool) {
+	if name == "" {
+		name = TransportDapr
+	}
+	transportsMu.RLock()
+	defer transportsMu.RUnlock()
+	t, ok := transports[name]
+	return t, ok
+}
+
+// GetTransport returns the transport of the output, which is dapr by default.
+func (o *Output) GetTransport() string {
+	if o.Transport == "" {
+		return TransportDapr
+	}
+	return o.Transport
+}
+
+// usesDapr reports whether the output is served by the Dapr sidecar.
+func (o *Output) usesDapr() bool {
+	return o.GetTransport() == TransportDapr
+}
+
+// parseTransport validates the output of a transport other than dapr.
+func (o *Output) parseTransport() error {
+	if _, ok := getTransport(o.Transport); !ok {
+		return fmt.Errorf("transport %s is not registered", o.Transport)
+	}
+	switch o.GetTransport() {
+	case TransportHTTP:
+		u, err := url.Parse(o.Uri)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("invalid uri of http transport: %s", o.Uri)
+		}
+	case TransportKnative:
+		if err := o.parseSink(); err != nil {
+			return err
+		}
+	}
+	switch o.CloudEventMode {
+	case "", CloudEventModeBinary, CloudEventModeStructured:
+	default:
+		return fmt.Errorf("invalid cloudevent mode: %s", o.CloudEventMode)
+	}
+	return nil
+}
+
+// daprTransport publishes the data onto the topic or invokes the binding of the output through Dapr.
+type daprTransport struct{}
+
+func (daprTransport) Send(c context.Context, req *OutputRequest) ([]byte, error) {
+	client := req.Context.GetContext().daprClient
+	if client == nil {
+		return nil, errDaprClientNotInitialized
+	}
+
+	target := req.Output
+	switch target.GetType() {
+	case OpenFuncTopic:
+		var opts []dapr.PublishEventOption
+		if req.ContentType != "" {
+			opts = append(opts, dapr.PublishEventWithContentType(req.ContentType))
+		}
+		if len(target.Metadata) > 0 {
+			opts = append(opts, dapr.PublishEventWithMetadata(target.Metadata))
+		}
+		return nil, client.PublishEvent(c, target.ComponentName, target.Uri, req.Data, opts...)
+	case OpenFuncBinding:
+		in := &dapr.InvokeBindingRequest{
+			Name:      target.ComponentName,
+			Operation: target.Operation,
+			Data:      req.Data,
+			Metadata:  target.Metadata,
+		}
+		response, err := client.InvokeBinding(c, in)
+		if err != nil || response == nil {
+			return nil, err
+		}
+		return response.Data, nil
+	default:
+		return nil, fmt.Errorf("output %s of type %s can not be sent through dapr", req.OutputName, target.GetType())
+	}
+}
+
+// HTTPTransport posts the data to the uri of the output, the metadata are sent as the headers,
+// and the operation of the output overrides the method. The data is sent as a CloudEvent if
+// the cloudEventMode of the output is binary or structured.
+type HTTPTransport struct {
+	client  *http.Client
+	timeout time.Duration
+}
+
+// NewHTTPTransport creates an HTTPTransport sending the requests with client, the requests without
+// a deadline, e.g. the timeout of the resiliency policy of the output, are bounded by 30s.
+func NewHTTPTransport(client *http.Client) *HTTPTransport {
+	return &HTTPTransport{client: client, timeout: defaultHTTPTimeout}
+}
+
+func (t *HTTPTransport) Send(c context.Context, req *OutputRequest) ([]byte, error) {
+	if _, ok := c.Deadline(); !ok && t.timeout > 0 {
+		var cancel context.CancelFunc
+		c, cancel = context.WithTimeout(c, t.timeout)
+		defer cancel()
+	}
+
+	target := req.Output
+	method := http.MethodPost
+	if target.Operation != "" {
+		method = strings.ToUpper(target.Operation)
+	}
+	r, err := http.NewRequestWithContext(c, method, target.Uri, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range target.Metadata {
+		r.Header.Set(k, v)
+	}
+
+	if target.CloudEventMode == "" {
+		contentType := req.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		r.Header.Set("Content-Type", contentType)
+		r.Body = ioutil.NopCloser(bytes.NewReader(req.Data))
+		r.ContentLength = int64(len(req.Data))
+	} else {
+		event := newOutputEvent(req)
+		wc := binding.WithForceBinary(c)
+		if target.CloudEventMode == CloudEventModeStructured {
+			wc = binding.WithForceStructured(c)
+		}
+		if err := cehttp.WriteRequest(wc, binding.ToMessage(&event), r); err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := t.client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return body, NewHTTPErrorf(resp.StatusCode, "%s %s responded %s", method, target.Uri, resp.Status)
+	}
+	return body, nil
+}
+
+// MemoryTransport keeps the calls of Send in memory, the calls are answered by the handlers of the outputs.
+type MemoryTransport struct {
+	mu       sync.Mutex
+	requests map[string][]*OutputRequest
+	handlers map[string]func(c context.Context, req *OutputRequest) ([]byte, error)
+}
+
+// DefaultMemoryTransport is the transport of the outputs whose transport is memory.
+var DefaultMemoryTransport = NewMemoryTransport()
+
+// NewMemoryTransport creates an empty MemoryTransport.
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{
+		requests: map[string][]*OutputRequest{},
+		handlers: map[string]func(c context.Context, req *OutputRequest) ([]byte, error){},
+	}
+}
+
+func (t *MemoryTransport) Send(c context.Context, req *OutputRequest) ([]byte, error) {
+	t.mu.Lock()
+	t.requests[req.OutputName] = append(t.requests[req.OutputName], req)
+	handler := t.handlers[req.OutputName]
+	t.mu.Unlock()
+
+	if handler != nil {
+		return handler(c, req)
+	}
+	return nil, c.Err()
+}
+
+// Handle answers the calls to the output with handler, e.g. to feed the data to another function.
+func (t *MemoryTransport) Handle(outputName string, handler func(c context.Context, req *OutputRequest) ([]byte, error)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.handlers[outputName] = handler
+}
+
+// Requests returns the calls to the output.
+func (t *MemoryTransport) Requests(outputName string) []*OutputRequest {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]*OutputRequest{}, t.requests[outputName]...)
+}
+
+// Reset clears the calls and the handlers.
+func (t *MemoryTransport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.requests = map[string][]*OutputRequest{}
+	t.handlers = map[string]func(c context.Context, req *OutputRequest) ([]byte, error){}
+}
@@ -0,0 +1,292 @@
+package context
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+)
+
+func TestHTTPTransport(t *testing.T) {
+	var calls int32
+	var requests []*http.Request
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, string(body))
+		switch r.URL.Path {
+		case "/unavailable":
+			// succeeds on the second attempt
+			if atomic.AddInt32(&calls, 1) == 1 {
+				w.WriteHeader(http.StatusServiceUnavailable)
+				return
+			}
+		case "/bad":
+			atomic.AddInt32(&calls, 1)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		case "/slow":
+			select {
+			case <-r.Context().Done():
+			case <-time.After(5 * time.Second):
+			}
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	ctx, err := ParseRuntimeContext([]byte(`{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Async",
+  "outputs": {
+    "webhook": {"uri": "` + server.URL + `/hook", "transport": "http", "metadata": {"X-Token": "secret"}},
+    "binary": {"uri": "` + server.URL + `/binary", "transport": "http", "cloudEventMode": "binary"},
+    "structured": {"uri": "` + server.URL + `/structured", "transport": "http", "cloudEventMode": "structured"},
+    "unavailable": {"uri": "` + server.URL + `/unavailable", "transport": "http", "resiliency": {"maxAttempts": 3, "initialInterval": "1ms"}},
+    "bad": {"uri": "` + server.URL + `/bad", "transport": "http", "resiliency": {"maxAttempts": 3, "initialInterval": "1ms"}}
+  }
+}`))
+	if err != nil {
+		t.Fatalf("Error parse function context: %s", err.Error())
+	}
+	if ctx.HasDaprComponents() {
+		t.Fatal("Error parse function context: the http outputs do not need dapr")
+	}
+
+	// the outputs are sent without the dapr client
+	resp, err := ctx.Send("webhook", []byte("hello"), SendWithContentType("text/plain"))
+	if err != nil {
+		t.Fatalf("Error send to webhook: %s", err.Error())
+	}
+	if string(resp) != "ok" {
+		t.Fatalf("Error send to webhook: unexpected response %s", resp)
+	}
+	r := requests[0]
+	if r.Method != http.MethodPost || r.Header.Get("Content-Type") != "text/plain" ||
+		r.Header.Get("X-Token") != "secret" || bodies[0] != "hello" {
+		t.Fatalf("Error send to webhook: unexpected request %s %v %s", r.Method, r.Header, bodies[0])
+	}
+
+	if _, err := ctx.Send("binary", []byte("hello"), SendWithContentType("text/plain")); err != nil {
+		t.Fatalf("Error send to binary: %s", err.Error())
+	}
+	r = requests[1]
+	if r.Header.Get("Ce-Source") != "function-test" || r.Header.Get("Ce-Subject") != "binary" ||
+		r.Header.Get("Content-Type") != "text/plain" || bodies[1] != "hello" {
+		t.Fatalf("Error send to binary: unexpected request %v %s", r.Header, bodies[1])
+	}
+
+	if _, err := ctx.Send("structured", []byte(`{"hello":"world"}`), SendWithContentType("application/json")); err != nil {
+		t.Fatalf("Error send to structured: %s", err.Error())
+	}
+	r = requests[2]
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), "application/cloudevents+json") ||
+		!strings.Contains(bodies[2], `"data":{"hello":"world"}`) || !strings.Contains(bodies[2], `"subject":"structured"`) {
+		t.Fatalf("Error send to structured: unexpected request %v %s", r.Header, bodies[2])
+	}
+
+	// the server errors are retried, the client errors are not
+	if _, err := ctx.Send("unavailable", []byte("hello")); err != nil {
+		t.Fatalf("Error send to unavailable: %s", err.Error())
+	}
+	if calls != 2 {
+		t.Fatalf("Error send to unavailable: expected 2 calls, got %d", calls)
+	}
+	atomic.StoreInt32(&calls, 0)
+	_, err = ctx.Send("bad", []byte("hello"))
+	if GetErrorStatusCode(err) != http.StatusBadRequest {
+		t.Fatalf("Error send to bad: unexpected error %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("Error send to bad: expected 1 call, got %d", calls)
+	}
+
+	// the requests without a deadline are bounded by the timeout of the transport
+	transport := &HTTPTransport{client: http.DefaultClient, timeout: 10 * time.Millisecond}
+	start := time.Now()
+	_, err = transport.Send(context.Background(), &OutputRequest{Context: ctx, OutputName: "slow", Output: &Output{Uri: server.URL + "/slow"}})
+	if err == nil || time.Since(start) > time.Second {
+		t.Fatalf("Error send to slow: expected timeout, got %v after %s", err, time.Since(start))
+	}
+}
+
+func TestMemoryTransport(t *testing.T) {
+	defer DefaultMemoryTransport.Reset()
+
+	ctx, err := ParseRuntimeContext([]byte(`{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Async",
+  "outputs": {
+    "sink": {"transport": "memory", "metadata": {"key": "value"}},
+    "echo": {"transport": "memory"}
+  }
+}`))
+	if err != nil {
+		t.Fatalf("Error parse function context: %s", err.Error())
+	}
+
+	DefaultMemoryTransport.Handle("echo", func(c context.Context, req *OutputRequest) ([]byte, error) {
+		return append([]byte("echo: "), req.Data...), nil
+	})
+	if _, err := ctx.Send("sink", []byte("hello"), SendWithOperation("create")); err != nil {
+		t.Fatalf("Error send to sink: %s", err.Error())
+	}
+	resp, err := ctx.Send("echo", []byte("hello"))
+	if err != nil || string(resp) != "echo: hello" {
+		t.Fatalf("Error send to echo: %s %v", resp, err)
+	}
+
+	requests := DefaultMemoryTransport.Requests("sink")
+	if len(requests) != 1 {
+		t.Fatalf("Error send to sink: expected 1 request, got %d", len(requests))
+	}
+	req := requests[0]
+	if string(req.Data) != "hello" || req.Output.Operation != "create" || req.Output.Metadata["key"] != "value" {
+		t.Fatalf("Error send to sink: unexpected request %+v", req)
+	}
+	// the output in the context is not changed by the options
+	if ctx.GetOutputs()["sink"].Operation != "" {
+		t.Fatal("Error send to sink: the output is changed")
+	}
+}
+
+type failingTransport struct{}
+
+func (failingTransport) Send(c context.Context, req *OutputRequest) ([]byte, error) {
+	return nil, NewDropError(context.Canceled)
+}
+
+func TestRegisterTransport(t *testing.T) {
+	RegisterTransport("failing", failingTransport{})
+	ctx, err := ParseRuntimeContext([]byte(`{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Async",
+  "outputs": {
+    "out": {"transport": "failing"}
+  }
+}`))
+	if err != nil {
+		t.Fatalf("Error parse function context: %s", err.Error())
+	}
+	if _, err := ctx.Send("out", []byte("hello")); err == nil {
+		t.Fatal("Error send to out: expected error")
+	}
+
+	for _, output := range []*Output{
+		{Transport: "unknown"},
+		{Transport: TransportHTTP, Uri: "hook"},
+		{Transport: TransportHTTP, Uri: "ftp://localhost/hook"},
+		{Transport: TransportHTTP, Uri: "http://localhost/hook", CloudEventMode: "batched"},
+	} {
+		if err := output.parseTransport(); err == nil {
+			t.Fatalf("Error parse transport: expected error of %+v", output)
+		}
+	}
+}
+
+func TestKnativeTransport(t *testing.T) {
+	var failures int32
+	var requests []*http.Request
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests = append(requests, r)
+		bodies = append(bodies, string(body))
+		switch r.URL.Path {
+		case "/unavailable":
+			atomic.AddInt32(&failures, 1)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		case "/reply":
+			w.Header().Set("Ce-Id", "reply")
+			w.Header().Set("Ce-Source", "broker")
+			w.Header().Set("Ce-Type", "reply")
+			w.Header().Set("Ce-Specversion", "1.0")
+			w.Header().Set("Content-Type", "text/plain")
+			_, _ = w.Write([]byte("pong"))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	os.Setenv(sinkEnvName, server.URL+"/broker")
+	os.Setenv(ceOverridesEnvName, `{"extensions": {"cluster": "test"}}`)
+	defer os.Unsetenv(sinkEnvName)
+	defer os.Unsetenv(ceOverridesEnvName)
+
+	ctx, err := ParseRuntimeContext([]byte(`{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Knative",
+  "outputs": {
+    "sink": {"transport": "knative", "metadata": {"X-Trace-Id": "abc"}},
+    "structured": {"transport": "knative", "cloudEventMode": "structured"},
+    "reply": {"transport": "knative", "uri": "` + server.URL + `/reply"},
+    "unavailable": {"transport": "knative", "uri": "` + server.URL + `/unavailable"}
+  }
+}`))
+	if err != nil {
+		t.Fatalf("Error parse function context: %s", err.Error())
+	}
+	if ctx.HasDaprComponents() {
+		t.Fatal("Error parse function context: the knative outputs do not need dapr")
+	}
+
+	// the data is sent as the InnerEvent of the function to K_SINK in binary mode
+	if _, err := ctx.Send("sink", []byte("hello"), SendWithContentType("text/plain")); err != nil {
+		t.Fatalf("Error send to sink: %s", err.Error())
+	}
+	r := requests[0]
+	if r.URL.Path != "/broker" || r.Header.Get("Ce-Source") != "function-test" || r.Header.Get("Ce-Subject") != "sink" ||
+		r.Header.Get("Ce-Xtraceid") != "abc" || r.Header.Get("Ce-Cluster") != "test" || bodies[0] != "hello" {
+		t.Fatalf("Error send to sink: unexpected request %s %v %s", r.URL.Path, r.Header, bodies[0])
+	}
+
+	// the CloudEvent built by the user is sent in structured mode
+	event := cloudevents.NewEvent()
+	event.SetID("1")
+	event.SetSource("user")
+	event.SetType("order.created")
+	_ = event.SetData(cloudevents.ApplicationJSON, map[string]string{"order": "apple"})
+	if _, err := ctx.SendCloudEvent(context.Background(), "structured", event); err != nil {
+		t.Fatalf("Error send to structured: %s", err.Error())
+	}
+	r = requests[1]
+	if !strings.HasPrefix(r.Header.Get("Content-Type"), cloudevents.ApplicationCloudEventsJSON) ||
+		!strings.Contains(bodies[1], `"type":"order.created"`) || !strings.Contains(bodies[1], `"data":{"order":"apple"}`) {
+		t.Fatalf("Error send to structured: unexpected request %v %s", r.Header, bodies[1])
+	}
+
+	// the reply event of the sink is returned
+	resp, err := ctx.Send("reply", []byte("ping"))
+	if err != nil || string(resp) != "pong" {
+		t.Fatalf("Error send to reply: %s %v", resp, err)
+	}
+
+	// the failures are retried by the cloudevents client
+	_, err = ctx.Send("unavailable", []byte("hello"))
+	if GetErrorStatusCode(err) != http.StatusServiceUnavailable {
+		t.Fatalf("Error send to unavailable: unexpected error %v", err)
+	}
+	if failures != sinkMaxRetries+1 {
+		t.Fatalf("Error send to unavailable: expected %d calls, got %d", sinkMaxRetries+1, failures)
+	}
+
+	os.Unsetenv(sinkEnvName)
+	if err := (&Output{Transport: TransportKnative}).parseTransport(); err == nil {
+		t.Fatal("Error parse transport: expected error without K_SINK")
+	}
+}
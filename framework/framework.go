@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"k8s.io/klog/v2"
@@ -14,7 +16,9 @@ import (
 	"github.com/OpenFunction/functions-framework-go/internal/functions"
 	"github.com/OpenFunction/functions-framework-go/internal/registry"
 	"github.com/OpenFunction/functions-framework-go/plugin"
+	"github.com/OpenFunction/functions-framework-go/plugin/opentelemetry"
 	plgExample "github.com/OpenFunction/functions-framework-go/plugin/plugin-example"
+	"github.com/OpenFunction/functions-framework-go/plugin/prometheus"
 	"github.com/OpenFunction/functions-framework-go/plugin/skywalking"
 	"github.com/OpenFunction/functions-framework-go/runtime"
 	"github.com/OpenFunction/functions-framework-go/runtime/async"
@@ -29,6 +33,9 @@ type functionsFrameworkImpl struct {
 	pluginMap      map[string]plugin.Plugin
 	runtime        runtime.Interface
 	registry       *registry.Registry
+	probe          *runtime.Probe
+	daprContexts   []ofctx.RuntimeContext
+	endpoints      map[string]bool
 }
 
 // Framework is the interface for the function conversion.
@@ -38,6 +45,9 @@ type Framework interface {
 	Start(ctx context.Context) error
 	TryRegisterFunctions(ctx context.Context) error
 	GetRuntime() runtime.Interface
+	// AddReadinessCheck adds a check that has to pass before the readiness endpoint reports ready,
+	// for example a database ping.
+	AddReadinessCheck(name string, check runtime.ReadinessCheck)
 }
 
 func NewFramework() (*functionsFrameworkImpl, error) {
@@ -46,8 +56,8 @@ func NewFramework() (*functionsFrameworkImpl, error) {
 	// Set the function registry
 	fwk.registry = registry.Default()
 
-	// Parse OpenFunction FunctionContext
-	if ctx, err := ofctx.GetRuntimeContext(); err != nil {
+	// Parse OpenFunction FunctionContext, with the section of the function served as FUNCTION_TARGET
+	if ctx, err := ofctx.GetRuntimeContextForFunction(os.Getenv("FUNCTION_TARGET")); err != nil {
 		klog.Errorf("failed to parse OpenFunction FunctionContext: %v\n", err)
 		return nil, err
 	} else {
@@ -66,6 +76,12 @@ func NewFramework() (*functionsFrameworkImpl, error) {
 		return nil, err
 	}
 
+	// Serve the health and readiness endpoints
+	fwk.probe = runtime.NewProbe()
+	fwk.probe.AddReadinessCheck("dapr", fwk.checkDaprClients)
+	fwk.runtime.RegisterManagementHandler(runtime.HealthzPath, http.HandlerFunc(fwk.probe.ServeHealthz))
+	fwk.runtime.RegisterManagementHandler(runtime.ReadyzPath, http.HandlerFunc(fwk.probe.ServeReadyz))
+
 	return fwk, nil
 }
 
@@ -88,6 +104,7 @@ func (fwk *functionsFrameworkImpl) Register(ctx context.Context, fn interface{})
 			klog.Errorf("failed to register function: %v", err)
 			return err
 		}
+		fwk.trackDaprClient(fwk.funcContext)
 	} else if fnCloudEvent, ok := fn.(func(context.Context, cloudevents.Event) error); ok {
 		rf, err := functions.New(functions.WithFunctionName(fwk.funcContext.GetName()), functions.WithCloudEvent(fnCloudEvent), functions.WithFunctionPath(fwk.funcContext.GetHttpPattern()))
 		if err != nil {
@@ -97,6 +114,15 @@ func (fwk *functionsFrameworkImpl) Register(ctx context.Context, fn interface{})
 			klog.Errorf("failed to register function: %v", err)
 			return err
 		}
+	} else if fnCloudEventReply, ok := fn.(func(context.Context, cloudevents.Event) (*cloudevents.Event, error)); ok {
+		rf, err := functions.New(functions.WithFunctionName(fwk.funcContext.GetName()), functions.WithCloudEventReply(fnCloudEventReply), functions.WithFunctionPath(fwk.funcContext.GetHttpPattern()))
+		if err != nil {
+			klog.Errorf("failed to register function: %v", err)
+		}
+		if err := fwk.runtime.RegisterCloudEventFunction(ctx, fwk.funcContext, fwk.prePlugins, fwk.postPlugins, rf); err != nil {
+			klog.Errorf("failed to register function: %v", err)
+			return err
+		}
 	} else {
 		err := errors.New("unrecognized function")
 		klog.Errorf("failed to register function: %v", err)
@@ -111,26 +137,14 @@ func (fwk *functionsFrameworkImpl) TryRegisterFunctions(ctx context.Context) err
 
 	// if FUNCTION_TARGET is provided
 	if len(target) > 0 {
-		if fn, ok := fwk.registry.GetRegisteredFunction(target); ok {
-			klog.Infof("registering function: %s on path: %s", target, fn.GetPath())
-			switch fn.GetFunctionType() {
-			case functions.HTTPType:
-				if err := fwk.Register(ctx, fn.GetHTTPFunction()); err != nil {
-					klog.Errorf("failed to register function: %v", err)
-					return err
-				}
-			case functions.CloudEventType:
-				if err := fwk.Register(ctx, fn.GetCloudEventFunction()); err != nil {
-					klog.Errorf("failed to register function: %v", err)
-					return err
-				}
-			case functions.OpenFunctionType:
-				if err := fwk.Register(ctx, fn.GetOpenFunctionFunction()); err != nil {
-					klog.Errorf("failed to register function: %v", err)
-					return err
-				}
-			default:
-				return fmt.Errorf("Unkown function type: %s", fn.GetFunctionType())
+		if rf, ok := fwk.registry.GetRegisteredFunction(target); ok {
+			// the function without a path of its own is served at the path of the function context
+			if rf.GetPath() == functions.DefaultPath {
+				rf = rf.CopyWithPath(fwk.funcContext.GetHttpPattern())
+			}
+			klog.Infof("registering function: %s on path: %s", target, rf.GetPath())
+			if err := fwk.registerFunction(ctx, fwk.funcContext, fwk.prePlugins, fwk.postPlugins, rf); err != nil {
+				return err
 			}
 		} else {
 			return fmt.Errorf("function not found: %s", target)
@@ -138,36 +152,24 @@ func (fwk *functionsFrameworkImpl) TryRegisterFunctions(ctx context.Context) err
 	} else {
 		// if FUNCTION_TARGET is not provided but user uses declarative function, by default all registered functions will be deployed.
 		funcNames := fwk.registry.GetFunctionNames()
-		if len(funcNames) > 1 && fwk.funcContext.GetRuntime() == ofctx.Async {
-			return errors.New("only one function is allowed in async runtime")
-		} else if len(funcNames) > 0 {
+		if len(funcNames) > 0 {
 			klog.Info("no 'FUNCTION_TARGET' is provided, register all the functions in the registry")
 			for _, name := range funcNames {
 				if rf, ok := fwk.registry.GetRegisteredFunction(name); ok {
 					klog.Infof("registering function: %s on path: %s", rf.GetName(), rf.GetPath())
-					// Parse OpenFunction FunctionContext
-					if ctx, err := ofctx.GetRuntimeContext(); err != nil {
+					// Parse OpenFunction FunctionContext with the section of this function
+					if ctx, err := ofctx.GetRuntimeContextForFunction(rf.GetName()); err != nil {
 						klog.Errorf("failed to parse OpenFunction FunctionContext: %v\n", err)
 						return err
 					} else {
 						fwk.funcContextMap[rf.GetName()] = ctx
 					}
-					switch rf.GetFunctionType() {
-					case functions.HTTPType:
-						if err := fwk.runtime.RegisterHTTPFunction(fwk.funcContextMap[rf.GetName()], fwk.prePlugins, fwk.postPlugins, rf); err != nil {
-							klog.Errorf("failed to register function: %v", err)
-							return err
-						}
-					case functions.CloudEventType:
-						if err := fwk.runtime.RegisterCloudEventFunction(ctx, fwk.funcContextMap[rf.GetName()], fwk.prePlugins, fwk.postPlugins, rf); err != nil {
-							klog.Errorf("failed to register function: %v", err)
-							return err
-						}
-					case functions.OpenFunctionType:
-						if err := fwk.runtime.RegisterOpenFunction(fwk.funcContextMap[rf.GetName()], fwk.prePlugins, fwk.postPlugins, rf); err != nil {
-							klog.Errorf("failed to register function: %v", err)
-							return err
-						}
+					funcContext := fwk.funcContextMap[rf.GetName()]
+					prePlugins := fwk.getPlugins(funcContext.GetPrePlugins())
+					postPlugins := fwk.getPlugins(funcContext.GetPostPlugins())
+					fwk.serveEndpoints(append(append([]plugin.Plugin{}, prePlugins...), postPlugins...))
+					if err := fwk.registerFunction(ctx, funcContext, prePlugins, postPlugins, rf); err != nil {
+						return err
 					}
 				}
 			}
@@ -176,6 +178,40 @@ func (fwk *functionsFrameworkImpl) TryRegisterFunctions(ctx context.Context) err
 	return nil
 }
 
+// registerFunction registers the function with the runtime as it is registered in the registry,
+// so that the options of the function, e.g. the timeout and the inputs, are kept.
+func (fwk *functionsFrameworkImpl) registerFunction(
+	ctx context.Context,
+	funcContext ofctx.RuntimeContext,
+	prePlugins []plugin.Plugin,
+	postPlugins []plugin.Plugin,
+	rf *functions.RegisteredFunction,
+) error {
+	switch rf.GetFunctionType() {
+	case functions.HTTPType:
+		if err := fwk.runtime.RegisterHTTPFunction(funcContext, prePlugins, postPlugins, rf); err != nil {
+			klog.Errorf("failed to register function: %v", err)
+			return err
+		}
+	case functions.CloudEventType:
+		if err := fwk.runtime.RegisterCloudEventFunction(ctx, funcContext, prePlugins, postPlugins, rf); err != nil {
+			klog.Errorf("failed to register function: %v", err)
+			return err
+		}
+	case functions.OpenFunctionType:
+		if err := fwk.runtime.RegisterOpenFunction(funcContext, prePlugins, postPlugins, rf); err != nil {
+			klog.Errorf("failed to register function: %v", err)
+			return err
+		}
+		fwk.trackDaprClient(funcContext)
+	default:
+		return fmt.Errorf("Unkown function type: %s", rf.GetFunctionType())
+	}
+	return nil
+}
+
+// Start registers the functions and serves them until ctx is cancelled or
+// the process receives SIGTERM or SIGINT, then shuts the runtime down gracefully.
 func (fwk *functionsFrameworkImpl) Start(ctx context.Context) error {
 
 	err := fwk.TryRegisterFunctions(ctx)
@@ -184,31 +220,80 @@ func (fwk *functionsFrameworkImpl) Start(ctx context.Context) error {
 		return err
 	}
 
-	err = fwk.runtime.Start(ctx)
-	if err != nil {
-		klog.Error("failed to start runtime service")
-		return err
+	fwk.probe.SetReady(true)
+
+	ctx, stop := signal.NotifyContext(ctx, syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- fwk.runtime.Start(ctx)
+	}()
+
+	select {
+	case err = <-errCh:
+		if err != nil {
+			klog.Error("failed to start runtime service")
+			return err
+		}
+		return nil
+	case <-ctx.Done():
+		return fwk.shutdown(errCh)
 	}
-	return nil
+}
+
+func (fwk *functionsFrameworkImpl) shutdown(errCh <-chan error) error {
+	// Stop reporting ready so that no new work is routed to the function
+	fwk.probe.SetReady(false)
+
+	timeout := fwk.funcContext.GetShutdownTimeout()
+	klog.Infof("shutting down, waiting up to %s for in-flight invocations", timeout)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	stopErr := fwk.runtime.Stop(ctx)
+	if stopErr != nil {
+		klog.Errorf("failed to stop runtime gracefully: %v", stopErr)
+	}
+	if err := <-errCh; err != nil {
+		klog.Errorf("runtime service exited with error: %v", err)
+	}
+
+	// The in-flight invocations share the dapr client with these contexts, Stop has waited for them,
+	// including the functions abandoned on timeout, unless the shutdown timeout is exceeded
+	fwk.funcContext.DestroyDaprClient()
+	for _, funcContext := range fwk.funcContextMap {
+		funcContext.DestroyDaprClient()
+	}
+
+	for name, plg := range fwk.pluginMap {
+		if s, ok := plg.(plugin.Shutdowner); ok {
+			if err := s.Shutdown(ctx); err != nil {
+				klog.Errorf("failed to shut down plugin %s: %v", name, err)
+			}
+		}
+	}
+
+	return stopErr
 }
 
 func (fwk *functionsFrameworkImpl) RegisterPlugins(customPlugins map[string]plugin.Plugin) {
 	// Register default plugins
 	fwk.pluginMap = map[string]plugin.Plugin{
-		plgExample.Name: plgExample.New(),
-		skywalking.Name: skywalking.New(),
+		plgExample.Name:    plgExample.New(),
+		skywalking.Name:    skywalking.New(),
+		opentelemetry.Name: opentelemetry.New(),
+		prometheus.Name:    prometheus.New(),
 	}
 
-	// Register custom plugins
-	if customPlugins != nil {
-		for name, plg := range customPlugins {
-			if _, ok := fwk.pluginMap[name]; !ok {
-				fwk.pluginMap[name] = plg
-			} else {
-				// Skip the registration of plugin with name that already exist
-				continue
-			}
+	// Register custom plugins, which replace the default plugins with the same name,
+	// e.g. the opentelemetry plugin exporting to an in-memory exporter in tests
+	for name, plg := range customPlugins {
+		if _, ok := fwk.pluginMap[name]; ok {
+			klog.Infof("replacing the default plugin %s with the custom one", name)
 		}
+		fwk.pluginMap[name] = plg
 	}
 
 	klog.Infoln("Plugins for pre-hook stage:")
@@ -226,12 +311,66 @@ func (fwk *functionsFrameworkImpl) RegisterPlugins(customPlugins map[string]plug
 			fwk.postPlugins = append(fwk.postPlugins, plg)
 		}
 	}
+
+	// Serve the endpoints of the enabled plugins, e.g. the metrics
+	fwk.serveEndpoints(append(append([]plugin.Plugin{}, fwk.prePlugins...), fwk.postPlugins...))
+}
+
+// getPlugins looks up the registered plugins by name, unknown names are skipped.
+func (fwk *functionsFrameworkImpl) getPlugins(names []string) []plugin.Plugin {
+	var plugins []plugin.Plugin
+	for _, plgName := range names {
+		if plg, ok := fwk.pluginMap[plgName]; ok {
+			plugins = append(plugins, plg)
+		}
+	}
+	return plugins
+}
+
+// serveEndpoints mounts the endpoints of the given plugins, each plugin is served once.
+func (fwk *functionsFrameworkImpl) serveEndpoints(plugins []plugin.Plugin) {
+	if fwk.endpoints == nil {
+		fwk.endpoints = map[string]bool{}
+	}
+	for _, plg := range plugins {
+		if e, ok := plg.(plugin.Endpoint); ok && !fwk.endpoints[plg.Name()] {
+			pattern, handler := e.Endpoint()
+			klog.Infof("serving endpoint %s of plugin %s", pattern, plg.Name())
+			fwk.runtime.RegisterManagementHandler(pattern, handler)
+			fwk.endpoints[plg.Name()] = true
+		}
+	}
 }
 
 func (fwk *functionsFrameworkImpl) GetRuntime() runtime.Interface {
 	return fwk.runtime
 }
 
+func (fwk *functionsFrameworkImpl) AddReadinessCheck(name string, check runtime.ReadinessCheck) {
+	fwk.probe.AddReadinessCheck(name, check)
+}
+
+// trackDaprClient records the contexts whose OpenFunction needs a dapr client to serve,
+// the runtimes initialize the client only when the function uses Dapr components.
+func (fwk *functionsFrameworkImpl) trackDaprClient(ctx ofctx.RuntimeContext) {
+	if ctx.HasDaprComponents() {
+		fwk.daprContexts = append(fwk.daprContexts, ctx)
+	}
+}
+
+func (fwk *functionsFrameworkImpl) checkDaprClients(ctx context.Context) error {
+	if testMode := os.Getenv(ofctx.TestModeEnvName); testMode == ofctx.TestModeOn {
+		return nil
+	}
+
+	for _, funcContext := range fwk.daprContexts {
+		if funcContext.GetContext().GetDaprClient() == nil {
+			return fmt.Errorf("dapr client of function %s is not connected", funcContext.GetName())
+		}
+	}
+	return nil
+}
+
 func createRuntime(fwk *functionsFrameworkImpl) error {
 	var err error
 
@@ -4,21 +4,38 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cpb "github.com/dapr/dapr/pkg/proto/common/v1"
 	"github.com/dapr/dapr/pkg/proto/runtime/v1"
+	dapr "github.com/dapr/go-sdk/client"
 	"github.com/dapr/go-sdk/service/common"
 	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/anypb"
 
 	ofctx "github.com/OpenFunction/functions-framework-go/context"
 	"github.com/OpenFunction/functions-framework-go/functions"
+	internalfunctions "github.com/OpenFunction/functions-framework-go/internal/functions"
+	"github.com/OpenFunction/functions-framework-go/internal/registry"
+	"github.com/OpenFunction/functions-framework-go/plugin"
+	"github.com/OpenFunction/functions-framework-go/plugin/opentelemetry"
 	"github.com/OpenFunction/functions-framework-go/runtime/async"
 )
 
@@ -167,6 +184,103 @@ func TestCloudEventFunction(t *testing.T) {
 	}
 }
 
+func TestCloudEventReplyFunction(t *testing.T) {
+	env := `{
+  "name": "function-demo",
+  "version": "v1.0.0",
+  "port": "8080",
+  "runtime": "Knative",
+  "httpPattern": "/ce"
+}`
+	ctx := context.Background()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+	fwk.RegisterPlugins(nil)
+
+	err = fwk.Register(ctx, func(ctx context.Context, ce cloudevents.Event) (*cloudevents.Event, error) {
+		switch string(ce.Data()) {
+		case "ignore":
+			return nil, nil
+		case "bad":
+			return nil, ofctx.NewHTTPErrorf(http.StatusBadRequest, "bad order")
+		}
+		reply := cloudevents.NewEvent()
+		reply.SetID("reply-" + ce.ID())
+		reply.SetSource("function-demo")
+		reply.SetType("order.accepted")
+		_ = reply.SetData("text/plain", append([]byte("accepted: "), ce.Data()...))
+		return &reply, nil
+	})
+	if err != nil {
+		t.Fatalf("failed to register CloudEvents function: %v", err)
+	}
+
+	srv := httptest.NewServer(fwk.GetRuntime().GetHandler().(http.Handler))
+	defer srv.Close()
+
+	post := func(structured bool, data string) *http.Response {
+		event := cloudevents.NewEvent()
+		event.SetID("1")
+		event.SetSource("broker")
+		event.SetType("order.created")
+		_ = event.SetData("text/plain", []byte(data))
+
+		var req *http.Request
+		if structured {
+			body, _ := json.Marshal(event)
+			req, _ = http.NewRequest("POST", srv.URL+"/ce", bytes.NewBuffer(body))
+			req.Header.Set("Content-Type", cloudevents.ApplicationCloudEventsJSON)
+		} else {
+			req, _ = http.NewRequest("POST", srv.URL+"/ce", bytes.NewBufferString(data))
+			req.Header.Set("Content-Type", "text/plain")
+			req.Header.Set("Ce-Specversion", "1.0")
+			req.Header.Set("Ce-Id", event.ID())
+			req.Header.Set("Ce-Source", event.Source())
+			req.Header.Set("Ce-Type", event.Type())
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatalf("failed to do client.Do: %v", err)
+		}
+		return resp
+	}
+
+	// the reply is written in binary mode for a binary request
+	resp := post(false, "apple")
+	body, _ := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, "order.accepted", resp.Header.Get("Ce-Type"))
+	assert.Equal(t, "reply-1", resp.Header.Get("Ce-Id"))
+	assert.Equal(t, "accepted: apple", string(body))
+
+	// the reply is written in structured mode for a structured request
+	resp = post(true, "banana")
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.True(t, strings.HasPrefix(resp.Header.Get("Content-Type"), cloudevents.ApplicationCloudEventsJSON))
+	reply := cloudevents.NewEvent()
+	if assert.NoError(t, json.Unmarshal(body, &reply)) {
+		assert.Equal(t, "order.accepted", reply.Type())
+		assert.Equal(t, "accepted: banana", string(reply.Data()))
+	}
+
+	// no event is replied
+	resp = post(false, "ignore")
+	body, _ = ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get("Ce-Id"))
+	assert.Empty(t, body)
+
+	resp = post(false, "bad")
+	resp.Body.Close()
+	assert.Equal(t, http.StatusBadRequest, resp.StatusCode)
+}
+
 func TestMultipleFunctions(t *testing.T) {
 	env := `{
   "name": "function-demo",
@@ -475,28 +589,1827 @@ func TestAsyncPubsubTopic(t *testing.T) {
 	stopTestServer(t, s)
 }
 
-func createFramework(env string) (Framework, error) {
-	os.Setenv(ofctx.ModeEnvName, ofctx.SelfHostMode)
-	os.Setenv(ofctx.TestModeEnvName, ofctx.TestModeOn)
-	os.Setenv(ofctx.FunctionContextEnvName, env)
-	fwk, err := NewFramework()
+func TestGracefulShutdown(t *testing.T) {
+	port, err := freePort()
 	if err != nil {
-		return nil, err
-	} else {
-		return fwk, nil
+		t.Fatalf("failed to get a free port: %v", err)
 	}
-}
+	env := fmt.Sprintf(`{
+  "name": "function-demo",
+  "version": "v1.0.0",
+  "port": "%s",
+  "runtime": "Knative",
+  "httpPattern": "/slow",
+  "shutdownTimeout": "5s"
+}`, port)
 
-func startTestServer(server common.Service) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+
+	fwk.RegisterPlugins(nil)
+
+	received := make(chan struct{})
+	if err := fwk.Register(ctx, func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		time.Sleep(500 * time.Millisecond)
+		fmt.Fprint(w, "Hello World!")
+	}); err != nil {
+		t.Fatalf("failed to register HTTP function: %v", err)
+	}
+
+	stopped := make(chan error, 1)
 	go func() {
-		if err := server.Start(); err != nil && err.Error() != "closed" {
-			panic(err)
+		stopped <- fwk.Start(ctx)
+	}()
+
+	type result struct {
+		body string
+		err  error
+	}
+	respCh := make(chan result, 1)
+	go func() {
+		var resp *http.Response
+		var err error
+		// wait for the server to be listening
+		for i := 0; i < 50; i++ {
+			if resp, err = http.Get(fmt.Sprintf("http://localhost:%s/slow", port)); err == nil {
+				break
+			}
+			time.Sleep(100 * time.Millisecond)
+		}
+		if err != nil {
+			respCh <- result{err: err}
+			return
+		}
+		defer resp.Body.Close()
+		body, err := ioutil.ReadAll(resp.Body)
+		respCh <- result{body: string(body), err: err}
+	}()
+
+	select {
+	case <-received:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the request did not reach the function")
+	}
+	cancel()
+
+	res := <-respCh
+	assert.NoError(t, res.err)
+	assert.Equal(t, "Hello World!", res.body)
+
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return after the context was cancelled")
+	}
+
+	_, err = http.Get(fmt.Sprintf("http://localhost:%s/slow", port))
+	assert.Error(t, err, "the server should not accept new requests after shutdown")
+}
+
+func TestHealthEndpoints(t *testing.T) {
+	port, err := freePort()
+	if err != nil {
+		t.Fatalf("failed to get a free port: %v", err)
+	}
+	env := fmt.Sprintf(`{
+  "name": "function-demo",
+  "version": "v1.0.0",
+  "port": "%s",
+  "runtime": "Knative",
+  "httpPattern": "/http"
+}`, port)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+
+	fwk.RegisterPlugins(nil)
+	// Start registers the declarative functions of the other tests, which serve the same path
+	fwk.(*functionsFrameworkImpl).registry = registry.New()
+
+	if err := fwk.Register(ctx, fakeHTTPFunction); err != nil {
+		t.Fatalf("failed to register HTTP function: %v", err)
+	}
+
+	get := func(path string) (int, string) {
+		resp, err := http.Get(fmt.Sprintf("http://localhost:%s%s", port, path))
+		if err != nil {
+			return 0, err.Error()
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		return resp.StatusCode, string(body)
+	}
+
+	// the probes are served before the functions are registered
+	handler := fwk.GetRuntime().GetHandler().(http.Handler)
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+
+	var dbReady int32
+	fwk.AddReadinessCheck("database", func(ctx context.Context) error {
+		if atomic.LoadInt32(&dbReady) == 0 {
+			return fmt.Errorf("database is not reachable")
 		}
+		return nil
+	})
+
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- fwk.Start(ctx)
 	}()
+
+	var code int
+	var body string
+	for i := 0; i < 50; i++ {
+		if code, body = get("/healthz"); code == http.StatusOK {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Equal(t, http.StatusOK, code)
+
+	code, body = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Contains(t, body, "database")
+
+	atomic.StoreInt32(&dbReady, 1)
+	code, _ = get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+
+	_, body = get("/http")
+	assert.Equal(t, "Hello World!", body)
+
+	cancel()
+	assert.NoError(t, <-stopped)
 }
 
-func stopTestServer(t *testing.T, server common.Service) {
-	assert.NotNil(t, server)
-	err := server.Stop()
-	assert.Nilf(t, err, "error stopping server")
+func TestAsyncHealthEndpoints(t *testing.T) {
+	env := `{
+  "name": "function-demo",
+  "version": "v1",
+  "runtime": "Async",
+  "port": "50003",
+  "inputs": {
+    "cron": {
+      "uri": "test",
+      "componentName": "test",
+      "componentType": "bindings.kafka"
+    }
+  }
+}`
+	ctx := context.Background()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+
+	fwk.RegisterPlugins(nil)
+
+	if err := fwk.Register(ctx, fakeBindingsFunction); err != nil {
+		t.Fatalf("failed to register OpenFunction function: %v", err)
+	}
+
+	handler := fwk.GetRuntime().(*async.Runtime).GetManagementHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, rec.Code)
+	assert.Contains(t, rec.Body.String(), "not registered")
+}
+
+func TestAsyncMetricsEndpoint(t *testing.T) {
+	env := `{
+  "name": "function-metrics",
+  "version": "v1",
+  "runtime": "Async",
+  "port": "50003",
+  "inputs": {
+    "cron": {
+      "uri": "test",
+      "componentName": "test",
+      "componentType": "bindings.kafka"
+    }
+  },
+  "prePlugins": ["prometheus"],
+  "postPlugins": ["prometheus"]
+}`
+	ctx := context.Background()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+
+	fwk.RegisterPlugins(nil)
+
+	if err := fwk.Register(ctx, fakeBindingsFunction); err != nil {
+		t.Fatalf("failed to register OpenFunction function: %v", err)
+	}
+
+	s := fwk.GetRuntime().GetHandler().(*async.FakeServer)
+	startTestServer(s)
+	defer stopTestServer(t, s)
+
+	_, err = s.OnBindingEvent(ctx, &runtime.BindingEventRequest{Name: "test", Data: []byte("hello")})
+	assert.NoError(t, err)
+
+	rec := httptest.NewRecorder()
+	handler := fwk.GetRuntime().(*async.Runtime).GetManagementHandler()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(),
+		`openfunction_function_invocations_total{code="200",function="function-metrics",input="cron",outcome="success",runtime="Async"} 1`)
+}
+
+func freePort() (string, error) {
+	l, err := net.Listen("tcp", ":0")
+	if err != nil {
+		return "", err
+	}
+	defer l.Close()
+	return fmt.Sprintf("%d", l.Addr().(*net.TCPAddr).Port), nil
+}
+
+func TestPerFunctionContext(t *testing.T) {
+	env := `{
+  "name": "function-demo",
+  "version": "v1.0.0",
+  "port": "8080",
+  "runtime": "Knative",
+  "prePlugins": ["plugin-example"],
+  "postPlugins": ["plugin-example"],
+  "outputs": {
+    "shared": {
+      "uri": "shared",
+      "componentName": "kafka-server",
+      "componentType": "bindings.kafka"
+    }
+  },
+  "functions": {
+    "foo": {
+      "prePlugins": [],
+      "postPlugins": [],
+      "outputs": {
+        "echo": {
+          "uri": "echo",
+          "componentName": "echo",
+          "componentType": "bindings.http"
+        }
+      }
+    }
+  }
+}`
+	ctx := context.Background()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+	fwk.RegisterPlugins(nil)
+
+	impl := fwk.(*functionsFrameworkImpl)
+	impl.registry = registry.New()
+	assert.NoError(t, impl.registry.RegisterOpenFunction("foo", fakeBindingsFunction, internalfunctions.WithFunctionPath("/foo")))
+	assert.NoError(t, impl.registry.RegisterOpenFunction("bar", fakeBindingsFunction, internalfunctions.WithFunctionPath("/bar")))
+
+	if err := fwk.TryRegisterFunctions(ctx); err != nil {
+		t.Fatalf("failed to start registering functions: %v", err)
+	}
+
+	foo := impl.funcContextMap["foo"]
+	if assert.NotNil(t, foo) {
+		assert.Len(t, foo.GetOutputs(), 2)
+		assert.Contains(t, foo.GetOutputs(), "echo")
+		assert.Empty(t, foo.GetPrePlugins())
+		assert.Empty(t, impl.getPlugins(foo.GetPostPlugins()))
+	}
+
+	bar := impl.funcContextMap["bar"]
+	if assert.NotNil(t, bar) {
+		assert.Len(t, bar.GetOutputs(), 1)
+		assert.NotContains(t, bar.GetOutputs(), "echo")
+		assert.Equal(t, []string{"plugin-example"}, bar.GetPrePlugins())
+		assert.Len(t, impl.getPlugins(bar.GetPostPlugins()), 1)
+	}
+}
+
+func TestCustomPlugins(t *testing.T) {
+	env := `{
+  "name": "function-demo",
+  "version": "v1.0.0",
+  "port": "8080",
+  "runtime": "Knative",
+  "pluginsTracing": {
+    "enabled": true,
+    "provider": {
+      "name": "opentelemetry"
+    }
+  }
+}`
+	ctx := context.Background()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+	// the custom plugin replaces the default plugin with the same name
+	exporter := tracetest.NewInMemoryExporter()
+	fwk.RegisterPlugins(map[string]plugin.Plugin{opentelemetry.Name: opentelemetry.NewWithExporter(exporter)})
+
+	impl := fwk.(*functionsFrameworkImpl)
+	impl.registry = registry.New()
+	assert.NoError(t, impl.registry.RegisterOpenFunction("traced", fakeBindingsFunction, internalfunctions.WithFunctionPath("/traced")))
+
+	if err := fwk.TryRegisterFunctions(ctx); err != nil {
+		t.Fatalf("failed to start registering functions: %v", err)
+	}
+
+	srv := httptest.NewServer(fwk.GetRuntime().GetHandler().(http.Handler))
+	defer srv.Close()
+
+	resp, err := http.Post(srv.URL+"/traced", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("http.Post: %v", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Len(t, exporter.GetSpans(), 1)
+}
+
+func TestAsyncMultipleFunctions(t *testing.T) {
+	env := `{
+  "name": "function-demo",
+  "version": "v1",
+  "runtime": "Async",
+  "port": "50003",
+  "inputs": {
+    "kafka-in": {
+      "uri": "kafka",
+      "componentName": "kafka",
+      "componentType": "bindings.kafka"
+    },
+    "cron": {
+      "uri": "cron",
+      "componentName": "cron",
+      "componentType": "bindings.cron"
+    }
+  }
+}`
+	ctx := context.Background()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+	fwk.RegisterPlugins(nil)
+
+	reply := func(data string) func(ofctx.Context, []byte) (ofctx.Out, error) {
+		return func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+			return ctx.ReturnOnSuccess().WithData([]byte(data)), nil
+		}
+	}
+
+	impl := fwk.(*functionsFrameworkImpl)
+	impl.registry = registry.New()
+	assert.NoError(t, impl.registry.RegisterOpenFunction("kafka-handler", reply("kafka"), internalfunctions.WithInputs("kafka-in")))
+	assert.NoError(t, impl.registry.RegisterOpenFunction("cron-handler", reply("cron"), internalfunctions.WithInputs("cron")))
+
+	if err := fwk.TryRegisterFunctions(ctx); err != nil {
+		t.Fatalf("failed to start registering functions: %v", err)
+	}
+
+	s := fwk.GetRuntime().GetHandler().(*async.FakeServer)
+	startTestServer(s)
+
+	for _, name := range []string{"kafka", "cron"} {
+		out, err := s.OnBindingEvent(ctx, &runtime.BindingEventRequest{Name: name})
+		assert.NoError(t, err)
+		if assert.NotNil(t, out) {
+			assert.Equal(t, name, string(out.Data))
+		}
+	}
+
+	stopTestServer(t, s)
+
+	t.Run("functions claim the same input", func(t *testing.T) {
+		fwk, err := createFramework(env)
+		if err != nil {
+			t.Fatalf("failed to create framework: %v", err)
+		}
+		fwk.RegisterPlugins(nil)
+
+		impl := fwk.(*functionsFrameworkImpl)
+		impl.registry = registry.New()
+		assert.NoError(t, impl.registry.RegisterOpenFunction("foo", reply("foo")))
+		assert.NoError(t, impl.registry.RegisterOpenFunction("bar", reply("bar"), internalfunctions.WithInputs("cron")))
+
+		err = fwk.TryRegisterFunctions(ctx)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "binding cron of input cron is already claimed by function")
+		}
+	})
+
+	t.Run("function selects an undefined input", func(t *testing.T) {
+		fwk, err := createFramework(env)
+		if err != nil {
+			t.Fatalf("failed to create framework: %v", err)
+		}
+		fwk.RegisterPlugins(nil)
+
+		impl := fwk.(*functionsFrameworkImpl)
+		impl.registry = registry.New()
+		assert.NoError(t, impl.registry.RegisterOpenFunction("foo", reply("foo"), internalfunctions.WithInputs("mqtt")))
+
+		err = fwk.TryRegisterFunctions(ctx)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "input mqtt of function foo is not defined")
+		}
+	})
+
+	t.Run("function selects an input twice", func(t *testing.T) {
+		fwk, err := createFramework(env)
+		if err != nil {
+			t.Fatalf("failed to create framework: %v", err)
+		}
+		fwk.RegisterPlugins(nil)
+
+		impl := fwk.(*functionsFrameworkImpl)
+		impl.registry = registry.New()
+		assert.NoError(t, impl.registry.RegisterOpenFunction("foo", reply("foo"), internalfunctions.WithInputs("cron", "cron")))
+
+		err = fwk.TryRegisterFunctions(ctx)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "input cron of function foo is selected more than once")
+		}
+	})
+
+	t.Run("inputs of a function share a binding", func(t *testing.T) {
+		fwk, err := createFramework(`{
+  "name": "function-demo",
+  "version": "v1",
+  "runtime": "Async",
+  "port": "50003",
+  "inputs": {
+    "cron": {"uri": "cron", "componentName": "cron", "componentType": "bindings.cron"},
+    "schedule": {"uri": "schedule", "componentName": "cron", "componentType": "bindings.cron"}
+  }
+}`)
+		if err != nil {
+			t.Fatalf("failed to create framework: %v", err)
+		}
+		fwk.RegisterPlugins(nil)
+
+		impl := fwk.(*functionsFrameworkImpl)
+		impl.registry = registry.New()
+		assert.NoError(t, impl.registry.RegisterOpenFunction("foo", reply("foo")))
+
+		err = fwk.TryRegisterFunctions(ctx)
+		if assert.Error(t, err) {
+			assert.Contains(t, err.Error(), "binding cron of input")
+			assert.Contains(t, err.Error(), "is already claimed by another input of function foo")
+		}
+	})
+}
+
+func TestOpenFunctionErrors(t *testing.T) {
+	env := `{
+  "name": "function-demo",
+  "version": "v1.0.0",
+  "port": "8080",
+  "runtime": "Knative"
+}`
+	ctx := context.Background()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+	fwk.RegisterPlugins(nil)
+
+	fail := func(out func(ofctx.Context) ofctx.Out, err error) func(ofctx.Context, []byte) (ofctx.Out, error) {
+		return func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+			return out(ctx), err
+		}
+	}
+	onError := func(ctx ofctx.Context) ofctx.Out { return ctx.ReturnOnInternalError() }
+	onCreated := func(ctx ofctx.Context) ofctx.Out { return ctx.ReturnOnSuccess().WithCode(http.StatusCreated) }
+
+	impl := fwk.(*functionsFrameworkImpl)
+	impl.registry = registry.New()
+	for path, fn := range map[string]func(ofctx.Context, []byte) (ofctx.Out, error){
+		"/not-found": fail(onError, ofctx.NewHTTPErrorf(http.StatusNotFound, "user not found")),
+		"/retry":     fail(onError, ofctx.NewRetryableError(errors.New("database is down"))),
+		"/drop":      fail(onError, ofctx.NewDropError(errors.New("invalid payload"))),
+		"/plain":     fail(onError, errors.New("internal details")),
+		"/created":   fail(onCreated, nil),
+	} {
+		assert.NoError(t, impl.registry.RegisterOpenFunction(strings.TrimPrefix(path, "/"), fn, internalfunctions.WithFunctionPath(path)))
+	}
+
+	if err := fwk.TryRegisterFunctions(ctx); err != nil {
+		t.Fatalf("failed to start registering functions: %v", err)
+	}
+
+	srv := httptest.NewServer(fwk.GetRuntime().GetHandler().(http.Handler))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		path   string
+		code   int
+		status string
+		body   string
+	}{
+		{"/not-found", http.StatusNotFound, "error", "user not found"},
+		{"/retry", http.StatusServiceUnavailable, "error", "database is down"},
+		{"/drop", http.StatusUnprocessableEntity, "error", "invalid payload"},
+		{"/plain", http.StatusInternalServerError, "error", ""},
+		{"/created", http.StatusCreated, "success", ""},
+	} {
+		resp, err := http.Post(srv.URL+tc.path, "text/plain", nil)
+		if err != nil {
+			t.Fatalf("http.Post: %v", err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, tc.code, resp.StatusCode, tc.path)
+		assert.Equal(t, tc.status, resp.Header.Get("X-OpenFunction-Status"), tc.path)
+		assert.Equal(t, tc.body, string(body), tc.path)
+	}
+}
+
+func TestOpenFunctionResponse(t *testing.T) {
+	env := `{
+  "name": "function-demo",
+  "version": "v1.0.0",
+  "port": "8080",
+  "runtime": "Knative"
+}`
+	ctx := context.Background()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+	fwk.RegisterPlugins(nil)
+
+	impl := fwk.(*functionsFrameworkImpl)
+	impl.registry = registry.New()
+	for path, fn := range map[string]func(ofctx.Context, []byte) (ofctx.Out, error){
+		"/created": func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+			return ctx.ReturnOnSuccess().WithCode(http.StatusCreated).
+				WithHeader("X-Request-Id", "a123").
+				WithContentType("application/json").
+				WithData([]byte(`{"id":1}`)), nil
+		},
+		"/no-content": func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+			return ctx.ReturnOnSuccess().WithCode(http.StatusNoContent).WithData([]byte("ignored")), nil
+		},
+		"/redirect": func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+			return ctx.ReturnOnSuccess().WithCode(http.StatusFound).WithHeader("Location", "/created"), nil
+		},
+		"/bad-request": func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+			return ctx.ReturnOnSuccess().WithCode(http.StatusBadRequest).
+				WithContentType("application/json").
+				WithData([]byte(`{"error":"missing id"}`)), nil
+		},
+	} {
+		assert.NoError(t, impl.registry.RegisterOpenFunction(strings.TrimPrefix(path, "/"), fn, internalfunctions.WithFunctionPath(path)))
+	}
+
+	if err := fwk.TryRegisterFunctions(ctx); err != nil {
+		t.Fatalf("failed to start registering functions: %v", err)
+	}
+
+	srv := httptest.NewServer(fwk.GetRuntime().GetHandler().(http.Handler))
+	defer srv.Close()
+	client := &http.Client{
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	for _, tc := range []struct {
+		path   string
+		code   int
+		header map[string]string
+		body   string
+	}{
+		{"/created", http.StatusCreated, map[string]string{"Content-Type": "application/json", "X-Request-Id": "a123"}, `{"id":1}`},
+		{"/no-content", http.StatusNoContent, nil, ""},
+		{"/redirect", http.StatusFound, map[string]string{"Location": "/created"}, ""},
+		{"/bad-request", http.StatusBadRequest, map[string]string{"Content-Type": "application/json", "X-OpenFunction-Status": "error"}, `{"error":"missing id"}`},
+	} {
+		resp, err := client.Get(srv.URL + tc.path)
+		if err != nil {
+			t.Fatalf("client.Get: %v", err)
+		}
+		body, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		assert.NoError(t, err)
+		assert.Equal(t, tc.code, resp.StatusCode, tc.path)
+		for key, value := range tc.header {
+			assert.Equal(t, value, resp.Header.Get(key), tc.path)
+		}
+		assert.Equal(t, tc.body, string(body), tc.path)
+	}
+}
+
+func TestAsyncPubsubErrors(t *testing.T) {
+	env := `{
+  "name": "function-demo",
+  "version": "v1",
+  "runtime": "Async",
+  "port": "50003",
+  "inputs": {
+    "sub": {
+      "uri": "my_topic",
+      "componentName": "msg",
+      "componentType": "pubsub.kafka"
+    },
+    "kafka": {
+      "uri": "kafka",
+      "componentName": "kafka",
+      "componentType": "bindings.kafka"
+    }
+  }
+}`
+	ctx := context.Background()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+	fwk.RegisterPlugins(nil)
+
+	if err := fwk.Register(ctx, func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		switch string(in) {
+		case "retry":
+			return ctx.ReturnOnInternalError(), ofctx.NewRetryableError(errors.New("database is down"))
+		case "drop":
+			return ctx.ReturnOnInternalError(), ofctx.NewDropError(errors.New("invalid payload"))
+		case "legacy-retry":
+			out := ctx.ReturnOnInternalError()
+			out.GetOut().Metadata = map[string]string{"retry": "true"}
+			return out, errors.New("failed")
+		case "plain":
+			return ctx.ReturnOnInternalError(), errors.New("failed")
+		default:
+			return ctx.ReturnOnSuccess(), nil
+		}
+	}); err != nil {
+		t.Fatalf("failed to register function: %v", err)
+	}
+
+	s := fwk.GetRuntime().GetHandler().(*async.FakeServer)
+	startTestServer(s)
+
+	for _, tc := range []struct {
+		data   string
+		status runtime.TopicEventResponse_TopicEventResponseStatus
+	}{
+		{"ok", runtime.TopicEventResponse_SUCCESS},
+		{"retry", runtime.TopicEventResponse_RETRY},
+		{"drop", runtime.TopicEventResponse_DROP},
+		{"legacy-retry", runtime.TopicEventResponse_RETRY},
+		{"plain", runtime.TopicEventResponse_DROP},
+	} {
+		out, _ := s.OnTopicEvent(ctx, &runtime.TopicEventRequest{
+			Id:              tc.data,
+			DataContentType: "text/plain",
+			Data:            []byte(tc.data),
+			Topic:           "my_topic",
+			PubsubName:      "msg",
+		})
+		if assert.NotNil(t, out, tc.data) {
+			assert.Equal(t, tc.status, out.Status, tc.data)
+		}
+	}
+
+	for _, tc := range []struct {
+		data    string
+		failure bool
+	}{
+		{"ok", false},
+		{"retry", true},
+		{"drop", false},
+		{"plain", true},
+	} {
+		_, err := s.OnBindingEvent(ctx, &runtime.BindingEventRequest{Name: "kafka", Data: []byte(tc.data)})
+		assert.Equal(t, tc.failure, err != nil, tc.data)
+	}
+
+	stopTestServer(t, s)
+}
+
+// postHookRecorder records the errors seen by the post hooks.
+type postHookRecorder struct {
+	mu     sync.Mutex
+	errors []error
+}
+
+func (p *postHookRecorder) Name() string    { return "recorder" }
+func (p *postHookRecorder) Version() string { return "v1" }
+func (p *postHookRecorder) Init() plugin.Plugin {
+	return p
+}
+func (p *postHookRecorder) ExecPreHook(ctx ofctx.RuntimeContext, plugins map[string]plugin.Plugin) error {
+	return nil
+}
+func (p *postHookRecorder) ExecPostHook(ctx ofctx.RuntimeContext, plugins map[string]plugin.Plugin) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.errors = append(p.errors, ctx.GetError())
+	return nil
+}
+func (p *postHookRecorder) Get(fieldName string) (interface{}, bool) {
+	return nil, false
+}
+
+func TestFunctionPanic(t *testing.T) {
+	env := `{
+  "name": "function-demo",
+  "version": "v1.0.0",
+  "port": "8080",
+  "runtime": "Knative",
+  "postPlugins": ["recorder"]
+}`
+	ctx := context.Background()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+	recorder := &postHookRecorder{}
+	fwk.RegisterPlugins(map[string]plugin.Plugin{recorder.Name(): recorder})
+
+	impl := fwk.(*functionsFrameworkImpl)
+	impl.registry = registry.New()
+	assert.NoError(t, impl.registry.RegisterOpenFunction("ofn", func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		panic("boom")
+	}, internalfunctions.WithFunctionPath("/ofn")))
+	assert.NoError(t, impl.registry.RegisterOpenFunction("nil-out", func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		return nil, errors.New("failed")
+	}, internalfunctions.WithFunctionPath("/nil-out")))
+	assert.NoError(t, impl.registry.RegisterHTTP("http", func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}, internalfunctions.WithFunctionPath("/http")))
+	assert.NoError(t, impl.registry.RegisterCloudEvent("ce", func(ctx context.Context, ce cloudevents.Event) error {
+		panic("boom")
+	}, internalfunctions.WithFunctionPath("/ce")))
+
+	if err := fwk.TryRegisterFunctions(ctx); err != nil {
+		t.Fatalf("failed to start registering functions: %v", err)
+	}
+
+	srv := httptest.NewServer(fwk.GetRuntime().GetHandler().(http.Handler))
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		path   string
+		status string
+	}{
+		{"/ofn", "crash"},
+		{"/nil-out", "error"},
+		{"/http", "crash"},
+	} {
+		resp, err := http.Post(srv.URL+tc.path, "text/plain", nil)
+		if err != nil {
+			t.Fatalf("http.Post: %v", err)
+		}
+		resp.Body.Close()
+		assert.Equal(t, http.StatusInternalServerError, resp.StatusCode, tc.path)
+		assert.Equal(t, tc.status, resp.Header.Get("X-OpenFunction-Status"), tc.path)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, srv.URL+"/ce", bytes.NewBuffer([]byte(`{"msg":"hello"}`)))
+	if err != nil {
+		t.Fatalf("http.NewRequest: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Ce-Specversion", "1.0")
+	req.Header.Set("Ce-Type", "test")
+	req.Header.Set("Ce-Source", "test")
+	req.Header.Set("Ce-Id", "a123")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("http.Do: %v", err)
+	}
+	resp.Body.Close()
+	assert.Equal(t, http.StatusInternalServerError, resp.StatusCode)
+
+	// the post hooks are executed after the panics
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	if assert.Len(t, recorder.errors, 4) {
+		for _, err := range recorder.errors {
+			assert.Error(t, err)
+		}
+		assert.ErrorIs(t, recorder.errors[0], ofctx.ErrFunctionPanic)
+	}
+}
+
+func TestAsyncFunctionPanic(t *testing.T) {
+	for policy, status := range map[string]runtime.TopicEventResponse_TopicEventResponseStatus{
+		"":      runtime.TopicEventResponse_DROP,
+		"drop":  runtime.TopicEventResponse_DROP,
+		"retry": runtime.TopicEventResponse_RETRY,
+	} {
+		env := fmt.Sprintf(`{
+  "name": "function-demo",
+  "version": "v1",
+  "runtime": "Async",
+  "port": "50003",
+  "panicPolicy": %q,
+  "inputs": {
+    "sub": {
+      "uri": "my_topic",
+      "componentName": "msg",
+      "componentType": "pubsub.kafka"
+    }
+  }
+}`, policy)
+		ctx := context.Background()
+		fwk, err := createFramework(env)
+		if err != nil {
+			t.Fatalf("failed to create framework: %v", err)
+		}
+		fwk.RegisterPlugins(nil)
+
+		if err := fwk.Register(ctx, func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+			panic("boom")
+		}); err != nil {
+			t.Fatalf("failed to register function: %v", err)
+		}
+
+		s := fwk.GetRuntime().GetHandler().(*async.FakeServer)
+		startTestServer(s)
+
+		out, err := s.OnTopicEvent(ctx, &runtime.TopicEventRequest{
+			Id:              "a123",
+			DataContentType: "text/plain",
+			Data:            []byte("test"),
+			Topic:           "my_topic",
+			PubsubName:      "msg",
+		})
+		assert.ErrorIs(t, err, ofctx.ErrFunctionPanic)
+		if assert.NotNil(t, out) {
+			assert.Equal(t, status, out.Status, policy)
+		}
+
+		stopTestServer(t, s)
+	}
+}
+
+func TestFunctionTimeout(t *testing.T) {
+	env := `{
+  "name": "function-demo",
+  "version": "v1.0.0",
+  "port": "8080",
+  "runtime": "Knative",
+  "postPlugins": ["recorder"]
+}`
+	ctx := context.Background()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+	recorder := &postHookRecorder{}
+	fwk.RegisterPlugins(map[string]plugin.Plugin{recorder.Name(): recorder})
+
+	hang := make(chan struct{})
+	defer close(hang)
+
+	impl := fwk.(*functionsFrameworkImpl)
+	impl.registry = registry.New()
+	assert.NoError(t, impl.registry.RegisterOpenFunction("hang", func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		// ignores the context
+		<-hang
+		return ctx.ReturnOnSuccess(), nil
+	}, internalfunctions.WithFunctionPath("/hang"), internalfunctions.WithTimeout(50*time.Millisecond)))
+	var cancelled int32
+	assert.NoError(t, impl.registry.RegisterOpenFunction("cancel", func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		<-ctx.GetNativeContext().Done()
+		// the function is still waited for after the deadline
+		time.Sleep(10 * time.Millisecond)
+		atomic.AddInt32(&cancelled, 1)
+		return ctx.ReturnOnInternalError(), ctx.GetNativeContext().Err()
+	}, internalfunctions.WithFunctionPath("/cancel"), internalfunctions.WithTimeout(50*time.Millisecond)))
+	assert.NoError(t, impl.registry.RegisterHTTP("http", func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}, internalfunctions.WithFunctionPath("/http"), internalfunctions.WithTimeout(50*time.Millisecond)))
+	assert.NoError(t, impl.registry.RegisterOpenFunction("fast", func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		return ctx.ReturnOnSuccess(), nil
+	}, internalfunctions.WithFunctionPath("/fast"), internalfunctions.WithTimeout(time.Second)))
+
+	if err := fwk.TryRegisterFunctions(ctx); err != nil {
+		t.Fatalf("failed to start registering functions: %v", err)
+	}
+
+	srv := httptest.NewServer(fwk.GetRuntime().GetHandler().(http.Handler))
+	defer srv.Close()
+
+	for path, code := range map[string]int{
+		"/hang":   http.StatusGatewayTimeout,
+		"/cancel": http.StatusGatewayTimeout,
+		"/http":   http.StatusGatewayTimeout,
+		"/fast":   http.StatusOK,
+	} {
+		resp, err := http.Post(srv.URL+path, "text/plain", nil)
+		if err != nil {
+			t.Fatalf("http.Post: %v", err)
+		}
+		resp.Body.Close()
+		assert.Equal(t, code, resp.StatusCode, path)
+	}
+	// the cancelled function returns before the response
+	assert.Equal(t, int32(1), atomic.LoadInt32(&cancelled))
+
+	// the post hooks see the timeout
+	recorder.mu.Lock()
+	defer recorder.mu.Unlock()
+	timeouts := 0
+	for _, err := range recorder.errors {
+		if errors.Is(err, context.DeadlineExceeded) {
+			timeouts++
+		}
+	}
+	assert.Equal(t, 3, timeouts)
+}
+
+func TestAsyncFunctionTimeout(t *testing.T) {
+	env := `{
+  "name": "function-demo",
+  "version": "v1",
+  "runtime": "Async",
+  "port": "50003",
+  "timeout": "50ms",
+  "inputs": {
+    "sub": {
+      "uri": "my_topic",
+      "componentName": "msg",
+      "componentType": "pubsub.kafka"
+    }
+  }
+}`
+	ctx := context.Background()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+	fwk.RegisterPlugins(nil)
+
+	if err := fwk.Register(ctx, func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		<-ctx.GetNativeContext().Done()
+		return ctx.ReturnOnInternalError(), ctx.GetNativeContext().Err()
+	}); err != nil {
+		t.Fatalf("failed to register function: %v", err)
+	}
+
+	s := fwk.GetRuntime().GetHandler().(*async.FakeServer)
+	startTestServer(s)
+
+	out, err := s.OnTopicEvent(ctx, &runtime.TopicEventRequest{
+		Id:              "a123",
+		DataContentType: "text/plain",
+		Data:            []byte("test"),
+		Topic:           "my_topic",
+		PubsubName:      "msg",
+	})
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	if assert.NotNil(t, out) {
+		assert.Equal(t, runtime.TopicEventResponse_RETRY, out.Status)
+	}
+
+	stopTestServer(t, s)
+}
+
+func TestMaxConcurrency(t *testing.T) {
+	env := `{
+  "name": "function-demo",
+  "version": "v1.0.0",
+  "port": "8080",
+  "runtime": "Knative"
+}`
+	ctx := context.Background()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+	fwk.RegisterPlugins(nil)
+
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	blocking := func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		started <- struct{}{}
+		<-release
+		return ctx.ReturnOnSuccess(), nil
+	}
+
+	impl := fwk.(*functionsFrameworkImpl)
+	impl.registry = registry.New()
+	assert.NoError(t, impl.registry.RegisterOpenFunction("reject", blocking,
+		internalfunctions.WithFunctionPath("/reject"),
+		internalfunctions.WithMaxConcurrency(1)))
+	assert.NoError(t, impl.registry.RegisterOpenFunction("wait", blocking,
+		internalfunctions.WithFunctionPath("/wait"),
+		internalfunctions.WithMaxConcurrency(1),
+		internalfunctions.WithConcurrencyPolicy(internalfunctions.ConcurrencyPolicyWait)))
+	assert.NoError(t, impl.registry.RegisterOpenFunction("abandoned", blocking,
+		internalfunctions.WithFunctionPath("/abandoned"),
+		internalfunctions.WithMaxConcurrency(1),
+		internalfunctions.WithTimeout(20*time.Millisecond)))
+
+	if err := fwk.TryRegisterFunctions(ctx); err != nil {
+		t.Fatalf("failed to start registering functions: %v", err)
+	}
+
+	srv := httptest.NewServer(fwk.GetRuntime().GetHandler().(http.Handler))
+	defer srv.Close()
+
+	post := func(path string, codes chan<- int) {
+		resp, err := http.Post(srv.URL+path, "text/plain", nil)
+		if err != nil {
+			codes <- 0
+			return
+		}
+		resp.Body.Close()
+		codes <- resp.StatusCode
+	}
+
+	t.Run("reject", func(t *testing.T) {
+		codes := make(chan int, 2)
+		go post("/reject", codes)
+		<-started
+		post("/reject", codes)
+		assert.Equal(t, http.StatusTooManyRequests, <-codes)
+		release <- struct{}{}
+		assert.Equal(t, http.StatusOK, <-codes)
+	})
+
+	t.Run("wait", func(t *testing.T) {
+		codes := make(chan int, 2)
+		go post("/wait", codes)
+		<-started
+		go post("/wait", codes)
+		release <- struct{}{}
+		<-started
+		release <- struct{}{}
+		assert.Equal(t, http.StatusOK, <-codes)
+		assert.Equal(t, http.StatusOK, <-codes)
+	})
+
+	t.Run("abandoned", func(t *testing.T) {
+		codes := make(chan int, 2)
+		// the function ignoring the context is abandoned on timeout, but keeps its slot until it returns
+		post("/abandoned", codes)
+		<-started
+		assert.Equal(t, http.StatusGatewayTimeout, <-codes)
+		post("/abandoned", codes)
+		assert.Equal(t, http.StatusTooManyRequests, <-codes)
+		release <- struct{}{}
+		assert.Eventually(t, func() bool {
+			go post("/abandoned", codes)
+			select {
+			case <-started:
+				release <- struct{}{}
+				return <-codes == http.StatusOK
+			case code := <-codes:
+				return code == http.StatusOK
+			}
+		}, time.Second, 10*time.Millisecond)
+	})
+}
+
+func TestAsyncMaxConcurrency(t *testing.T) {
+	env := `{
+  "name": "function-demo",
+  "version": "v1",
+  "runtime": "Async",
+  "port": "50003",
+  "inputs": {
+    "sub": {
+      "uri": "my_topic",
+      "componentName": "msg",
+      "componentType": "pubsub.kafka"
+    }
+  }
+}`
+	ctx := context.Background()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+	fwk.RegisterPlugins(nil)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	impl := fwk.(*functionsFrameworkImpl)
+	impl.registry = registry.New()
+	assert.NoError(t, impl.registry.RegisterOpenFunction("blocking", func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		started <- struct{}{}
+		<-release
+		return ctx.ReturnOnSuccess(), nil
+	}, internalfunctions.WithMaxConcurrency(1)))
+
+	if err := fwk.TryRegisterFunctions(ctx); err != nil {
+		t.Fatalf("failed to start registering functions: %v", err)
+	}
+
+	s := fwk.GetRuntime().GetHandler().(*async.FakeServer)
+	startTestServer(s)
+
+	event := &runtime.TopicEventRequest{
+		Id:              "a123",
+		DataContentType: "text/plain",
+		Data:            []byte("test"),
+		Topic:           "my_topic",
+		PubsubName:      "msg",
+	}
+	statuses := make(chan runtime.TopicEventResponse_TopicEventResponseStatus, 1)
+	go func() {
+		out, _ := s.OnTopicEvent(ctx, event)
+		statuses <- out.Status
+	}()
+	<-started
+
+	out, err := s.OnTopicEvent(ctx, event)
+	assert.Error(t, err)
+	if assert.NotNil(t, out) {
+		assert.Equal(t, runtime.TopicEventResponse_RETRY, out.Status)
+	}
+
+	close(release)
+	assert.Equal(t, runtime.TopicEventResponse_SUCCESS, <-statuses)
+
+	stopTestServer(t, s)
+}
+
+func TestAsyncGracefulShutdown(t *testing.T) {
+	env := `{
+  "name": "function-demo",
+  "version": "v1",
+  "runtime": "Async",
+  "port": "50004",
+  "inputs": {
+    "sub": {
+      "uri": "my_topic",
+      "componentName": "msg",
+      "componentType": "pubsub.kafka"
+    }
+  }
+}`
+	ctx := context.Background()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+	fwk.RegisterPlugins(nil)
+
+	release := make(chan struct{})
+	impl := fwk.(*functionsFrameworkImpl)
+	impl.registry = registry.New()
+	assert.NoError(t, impl.registry.RegisterOpenFunction("stuck", func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		// ignores the cancellation, so that it is abandoned on timeout
+		<-release
+		return ctx.ReturnOnSuccess(), nil
+	}, internalfunctions.WithTimeout(10*time.Millisecond)))
+
+	if err := fwk.TryRegisterFunctions(ctx); err != nil {
+		t.Fatalf("failed to start registering functions: %v", err)
+	}
+
+	// the handlers are called directly, Stop closes the listener of the server which is not started
+	s := fwk.GetRuntime().GetHandler().(*async.FakeServer)
+
+	event := &runtime.TopicEventRequest{
+		Id:              "a123",
+		DataContentType: "text/plain",
+		Data:            []byte("test"),
+		Topic:           "my_topic",
+		PubsubName:      "msg",
+	}
+	_, err = s.OnTopicEvent(ctx, event)
+	assert.Error(t, err)
+
+	// the abandoned function is drained
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- fwk.GetRuntime().Stop(ctx)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned before the abandoned function")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the events arriving while stopping are redelivered
+	out, err := s.OnTopicEvent(ctx, event)
+	assert.Error(t, err)
+	if assert.NotNil(t, out) {
+		assert.Equal(t, runtime.TopicEventResponse_RETRY, out.Status)
+	}
+
+	close(release)
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return after the function")
+	}
+}
+
+func TestKnativeGracefulShutdown(t *testing.T) {
+	env := `{
+  "name": "function-demo",
+  "version": "v1.0.0",
+  "port": "8080",
+  "runtime": "Knative"
+}`
+	ctx := context.Background()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+	fwk.RegisterPlugins(nil)
+
+	release := make(chan struct{})
+	impl := fwk.(*functionsFrameworkImpl)
+	impl.registry = registry.New()
+	assert.NoError(t, impl.registry.RegisterOpenFunction("stuck", func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		// ignores the cancellation, so that it is abandoned on timeout
+		<-release
+		return ctx.ReturnOnSuccess(), nil
+	}, internalfunctions.WithFunctionPath("/stuck"), internalfunctions.WithTimeout(10*time.Millisecond)))
+
+	if err := fwk.TryRegisterFunctions(ctx); err != nil {
+		t.Fatalf("failed to start registering functions: %v", err)
+	}
+
+	// the runtime server is not started, Stop waits for the invocations served by the test server
+	srv := httptest.NewServer(fwk.GetRuntime().GetHandler().(http.Handler))
+	defer srv.Close()
+
+	post := func() int {
+		resp, err := http.Post(srv.URL+"/stuck", "text/plain", nil)
+		if err != nil {
+			t.Fatalf("http.Post: %v", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+	assert.Equal(t, http.StatusGatewayTimeout, post())
+
+	// the abandoned function is drained
+	stopped := make(chan error, 1)
+	go func() {
+		stopped <- fwk.GetRuntime().Stop(ctx)
+	}()
+	select {
+	case <-stopped:
+		t.Fatal("Stop returned before the abandoned function")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// the requests arriving while stopping are rejected
+	assert.Equal(t, http.StatusServiceUnavailable, post())
+
+	close(release)
+	select {
+	case err := <-stopped:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop did not return after the function")
+	}
+
+	t.Run("bounded by the context", func(t *testing.T) {
+		fwk, err := createFramework(env)
+		if err != nil {
+			t.Fatalf("failed to create framework: %v", err)
+		}
+		fwk.RegisterPlugins(nil)
+
+		hang := make(chan struct{})
+		defer close(hang)
+		impl := fwk.(*functionsFrameworkImpl)
+		impl.registry = registry.New()
+		assert.NoError(t, impl.registry.RegisterOpenFunction("stuck", func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+			<-hang
+			return ctx.ReturnOnSuccess(), nil
+		}, internalfunctions.WithFunctionPath("/stuck"), internalfunctions.WithTimeout(10*time.Millisecond)))
+
+		if err := fwk.TryRegisterFunctions(ctx); err != nil {
+			t.Fatalf("failed to start registering functions: %v", err)
+		}
+
+		srv := httptest.NewServer(fwk.GetRuntime().GetHandler().(http.Handler))
+		defer srv.Close()
+
+		resp, err := http.Post(srv.URL+"/stuck", "text/plain", nil)
+		if err != nil {
+			t.Fatalf("http.Post: %v", err)
+		}
+		resp.Body.Close()
+
+		stopCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer cancel()
+		assert.ErrorIs(t, fwk.GetRuntime().Stop(stopCtx), context.DeadlineExceeded)
+	})
+}
+
+// initPanicPlugin panics when it is initialized for an invocation.
+type initPanicPlugin struct {
+	postHookRecorder
+}
+
+func (p *initPanicPlugin) Name() string { return "init-panic" }
+func (p *initPanicPlugin) Init() plugin.Plugin {
+	panic("failed to init")
+}
+
+func TestAsyncPluginInitPanic(t *testing.T) {
+	env := `{
+  "name": "function-demo",
+  "version": "v1",
+  "runtime": "Async",
+  "port": "50004",
+  "prePlugins": ["init-panic"],
+  "inputs": {
+    "sub": {
+      "uri": "my_topic",
+      "componentName": "msg",
+      "componentType": "pubsub.kafka"
+    }
+  }
+}`
+	ctx := context.Background()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+	plg := &initPanicPlugin{}
+	fwk.RegisterPlugins(map[string]plugin.Plugin{plg.Name(): plg})
+
+	impl := fwk.(*functionsFrameworkImpl)
+	impl.registry = registry.New()
+	assert.NoError(t, impl.registry.RegisterOpenFunction("foo", fakePubsubFunction, internalfunctions.WithMaxConcurrency(1)))
+
+	if err := fwk.TryRegisterFunctions(ctx); err != nil {
+		t.Fatalf("failed to start registering functions: %v", err)
+	}
+
+	// the handlers are called directly, Stop closes the listener of the server which is not started
+	s := fwk.GetRuntime().GetHandler().(*async.FakeServer)
+
+	event := &runtime.TopicEventRequest{
+		Id:              "a123",
+		DataContentType: "text/plain",
+		Data:            []byte("test"),
+		Topic:           "my_topic",
+		PubsubName:      "msg",
+	}
+	// the slot is released after the panic, so that the second event is not rejected as too many
+	for i := 0; i < 2; i++ {
+		_, err = s.OnTopicEvent(ctx, event)
+		if assert.Error(t, err) {
+			assert.ErrorIs(t, err, ofctx.ErrFunctionPanic)
+		}
+	}
+
+	// the events are not counted as in-flight after the panic
+	stopCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	assert.NoError(t, fwk.GetRuntime().Stop(stopCtx))
+}
+
+func TestKnativeDuplicatePath(t *testing.T) {
+	env := `{
+  "name": "function-demo",
+  "version": "v1",
+  "runtime": "Knative",
+  "port": "8080",
+  "inputs": {
+    "a": {"uri": "a", "componentName": "a", "componentType": "bindings.kafka"},
+    "b": {"uri": "b", "componentName": "b", "componentType": "bindings.kafka"}
+  }
+}`
+	ctx := context.Background()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+	fwk.RegisterPlugins(nil)
+
+	// the functions serving selected inputs pass the registry, but can not share a path under knative
+	impl := fwk.(*functionsFrameworkImpl)
+	impl.registry = registry.New()
+	assert.NoError(t, impl.registry.RegisterOpenFunction("first", fakeBindingsFunction, internalfunctions.WithInputs("a")))
+	assert.NoError(t, impl.registry.RegisterOpenFunction("second", fakeBindingsFunction, internalfunctions.WithInputs("b")))
+
+	err = fwk.TryRegisterFunctions(ctx)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "function path already registered")
+	}
+}
+
+func TestFunctionTarget(t *testing.T) {
+	env := `{
+  "name": "function-demo",
+  "version": "v1.0.0",
+  "port": "8080",
+  "runtime": "Knative",
+  "httpPattern": "/target"
+}`
+	ctx := context.Background()
+	os.Setenv("FUNCTION_TARGET", "target")
+	defer os.Unsetenv("FUNCTION_TARGET")
+
+	// the options of the function are kept when it is served as the target
+	t.Run("timeout", func(t *testing.T) {
+		fwk, err := createFramework(env)
+		if err != nil {
+			t.Fatalf("failed to create framework: %v", err)
+		}
+		fwk.RegisterPlugins(nil)
+
+		impl := fwk.(*functionsFrameworkImpl)
+		impl.registry = registry.New()
+		assert.NoError(t, impl.registry.RegisterOpenFunction("target", func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+			<-ctx.GetNativeContext().Done()
+			return ctx.ReturnOnInternalError(), ctx.GetNativeContext().Err()
+		}, internalfunctions.WithTimeout(50*time.Millisecond)))
+
+		if err := fwk.TryRegisterFunctions(ctx); err != nil {
+			t.Fatalf("failed to start registering functions: %v", err)
+		}
+
+		srv := httptest.NewServer(fwk.GetRuntime().GetHandler().(http.Handler))
+		defer srv.Close()
+
+		// the function without a path is served at the path of the function context
+		resp, err := http.Post(srv.URL+"/target", "text/plain", nil)
+		if err != nil {
+			t.Fatalf("http.Post: %v", err)
+		}
+		resp.Body.Close()
+		assert.Equal(t, http.StatusGatewayTimeout, resp.StatusCode)
+	})
+
+	t.Run("max concurrency", func(t *testing.T) {
+		fwk, err := createFramework(env)
+		if err != nil {
+			t.Fatalf("failed to create framework: %v", err)
+		}
+		fwk.RegisterPlugins(nil)
+
+		started := make(chan struct{}, 1)
+		release := make(chan struct{})
+		impl := fwk.(*functionsFrameworkImpl)
+		impl.registry = registry.New()
+		assert.NoError(t, impl.registry.RegisterOpenFunction("target", func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+			started <- struct{}{}
+			<-release
+			return ctx.ReturnOnSuccess(), nil
+		}, internalfunctions.WithMaxConcurrency(1)))
+
+		if err := fwk.TryRegisterFunctions(ctx); err != nil {
+			t.Fatalf("failed to start registering functions: %v", err)
+		}
+
+		srv := httptest.NewServer(fwk.GetRuntime().GetHandler().(http.Handler))
+		defer srv.Close()
+
+		codes := make(chan int, 1)
+		go func() {
+			resp, err := http.Post(srv.URL+"/target", "text/plain", nil)
+			if err != nil {
+				codes <- 0
+				return
+			}
+			resp.Body.Close()
+			codes <- resp.StatusCode
+		}()
+		<-started
+
+		resp, err := http.Post(srv.URL+"/target", "text/plain", nil)
+		if err != nil {
+			t.Fatalf("http.Post: %v", err)
+		}
+		resp.Body.Close()
+		assert.Equal(t, http.StatusTooManyRequests, resp.StatusCode)
+
+		close(release)
+		assert.Equal(t, http.StatusOK, <-codes)
+	})
+
+	t.Run("inputs", func(t *testing.T) {
+		fwk, err := createFramework(`{
+  "name": "function-demo",
+  "version": "v1",
+  "runtime": "Async",
+  "port": "50005",
+  "inputs": {
+    "kafka-in": {"uri": "kafka", "componentName": "kafka", "componentType": "bindings.kafka"},
+    "cron": {"uri": "cron", "componentName": "cron", "componentType": "bindings.cron"}
+  }
+}`)
+		if err != nil {
+			t.Fatalf("failed to create framework: %v", err)
+		}
+		fwk.RegisterPlugins(nil)
+
+		impl := fwk.(*functionsFrameworkImpl)
+		impl.registry = registry.New()
+		assert.NoError(t, impl.registry.RegisterOpenFunction("target", fakeBindingsFunction, internalfunctions.WithInputs("kafka-in")))
+
+		if err := fwk.TryRegisterFunctions(ctx); err != nil {
+			t.Fatalf("failed to start registering functions: %v", err)
+		}
+
+		// the server is not started, the handlers are called directly
+		s := fwk.GetRuntime().GetHandler().(*async.FakeServer)
+		_, err = s.OnBindingEvent(ctx, &runtime.BindingEventRequest{Name: "kafka"})
+		assert.NoError(t, err)
+		_, err = s.OnBindingEvent(ctx, &runtime.BindingEventRequest{Name: "cron"})
+		assert.Error(t, err)
+	})
+
+	t.Run("function section", func(t *testing.T) {
+		fwk, err := createFramework(`{
+  "name": "function-demo",
+  "version": "v1.0.0",
+  "port": "8080",
+  "runtime": "Knative",
+  "httpPattern": "/target",
+  "prePlugins": ["plugin-example"],
+  "postPlugins": ["plugin-example"],
+  "functions": {
+    "target": {
+      "prePlugins": [],
+      "outputs": {
+        "echo": {"uri": "echo", "componentName": "echo", "componentType": "bindings.http"}
+      }
+    }
+  }
+}`)
+		if err != nil {
+			t.Fatalf("failed to create framework: %v", err)
+		}
+		fwk.RegisterPlugins(nil)
+
+		impl := fwk.(*functionsFrameworkImpl)
+		impl.registry = registry.New()
+		assert.NoError(t, impl.registry.RegisterOpenFunction("target", fakeBindingsFunction))
+
+		if err := fwk.TryRegisterFunctions(ctx); err != nil {
+			t.Fatalf("failed to start registering functions: %v", err)
+		}
+		assert.Contains(t, impl.funcContext.GetOutputs(), "echo")
+		assert.Empty(t, impl.prePlugins)
+		assert.Len(t, impl.postPlugins, 1)
+	})
+}
+
+func createFramework(env string) (Framework, error) {
+	os.Setenv(ofctx.ModeEnvName, ofctx.SelfHostMode)
+	os.Setenv(ofctx.TestModeEnvName, ofctx.TestModeOn)
+	os.Setenv(ofctx.FunctionContextEnvName, env)
+	fwk, err := NewFramework()
+	if err != nil {
+		return nil, err
+	} else {
+		return fwk, nil
+	}
+}
+
+func startTestServer(server common.Service) {
+	go func() {
+		if err := server.Start(); err != nil && err.Error() != "closed" {
+			panic(err)
+		}
+	}()
+}
+
+func stopTestServer(t *testing.T, server common.Service) {
+	assert.NotNil(t, server)
+	err := server.Stop()
+	assert.Nilf(t, err, "error stopping server")
+}
+
+// fakeDaprClient records the calls to the bindings made by Send.
+type fakeDaprClient struct {
+	dapr.Client
+	mu       sync.Mutex
+	bindings []*dapr.InvokeBindingRequest
+}
+
+func (c *fakeDaprClient) InvokeBinding(ctx context.Context, in *dapr.InvokeBindingRequest) (*dapr.BindingEvent, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.bindings = append(c.bindings, in)
+	return &dapr.BindingEvent{}, nil
+}
+
+func TestAsyncDeadLetter(t *testing.T) {
+	env := `{
+  "name": "function-demo",
+  "version": "v1",
+  "runtime": "Async",
+  "port": "50003",
+  "inputs": {
+    "sub": {
+      "uri": "my_topic",
+      "componentName": "msg",
+      "componentType": "pubsub.kafka",
+      "deadLetter": "dlq",
+      "maxDeliveryAttempts": 2
+    },
+    "kafka": {
+      "uri": "kafka",
+      "componentName": "kafka",
+      "componentType": "bindings.kafka",
+      "deadLetter": "dlq"
+    },
+    "queue": {
+      "uri": "queue",
+      "componentName": "queue",
+      "componentType": "bindings.rabbitmq",
+      "deadLetter": "dlq",
+      "maxDeliveryAttempts": 2
+    }
+  },
+  "outputs": {
+    "dlq": {
+      "uri": "dlq",
+      "componentName": "dlq",
+      "componentType": "bindings.kafka"
+    }
+  }
+}`
+	ctx := context.Background()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+	fwk.RegisterPlugins(nil)
+
+	if err := fwk.Register(ctx, func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		switch string(in) {
+		case "retry":
+			return ctx.ReturnOnInternalError(), ofctx.NewRetryableError(errors.New("database is down"))
+		case "internal":
+			return ctx.ReturnOnInternalError(), nil
+		default:
+			return ctx.ReturnOnSuccess(), nil
+		}
+	}); err != nil {
+		t.Fatalf("failed to register function: %v", err)
+	}
+	client := &fakeDaprClient{}
+	fwk.(*functionsFrameworkImpl).funcContext.SetDaprClient(client)
+
+	s := fwk.GetRuntime().GetHandler().(*async.FakeServer)
+	startTestServer(s)
+
+	// the event is redelivered until the max delivery attempts
+	event := &runtime.TopicEventRequest{
+		Id:              "a123",
+		DataContentType: "text/plain",
+		Data:            []byte("retry"),
+		Topic:           "my_topic",
+		PubsubName:      "msg",
+	}
+	out, _ := s.OnTopicEvent(ctx, event)
+	if assert.NotNil(t, out) {
+		assert.Equal(t, runtime.TopicEventResponse_RETRY, out.Status)
+	}
+	assert.Len(t, client.bindings, 0)
+	out, err = s.OnTopicEvent(ctx, event)
+	assert.NoError(t, err)
+	if assert.NotNil(t, out) {
+		assert.Equal(t, runtime.TopicEventResponse_SUCCESS, out.Status)
+	}
+	if assert.Len(t, client.bindings, 1) {
+		in := client.bindings[0]
+		assert.Equal(t, "dlq", in.Name)
+		assert.Equal(t, []byte("retry"), in.Data)
+		assert.Equal(t, "sub", in.Metadata["deadLetterInput"])
+		assert.Equal(t, "database is down", in.Metadata["deadLetterError"])
+		assert.Equal(t, "2", in.Metadata["deadLetterAttempts"])
+	}
+
+	// the failed binding event is not redelivered, so it is forwarded at once
+	_, err = s.OnBindingEvent(ctx, &runtime.BindingEventRequest{Name: "kafka", Data: []byte("internal")})
+	assert.NoError(t, err)
+	if assert.Len(t, client.bindings, 2) {
+		in := client.bindings[1]
+		assert.Equal(t, []byte("internal"), in.Data)
+		assert.Equal(t, "kafka", in.Metadata["deadLetterInput"])
+		assert.Equal(t, "1", in.Metadata["deadLetterAttempts"])
+	}
+
+	_, err = s.OnBindingEvent(ctx, &runtime.BindingEventRequest{Name: "kafka", Data: []byte("ok")})
+	assert.NoError(t, err)
+	assert.Len(t, client.bindings, 2)
+
+	// the redeliveries of a binding event are counted by its delivery id
+	queueEvent := func(id string, metadata map[string]string) *runtime.BindingEventRequest {
+		m := map[string]string{"MessageId": id}
+		for k, v := range metadata {
+			m[k] = v
+		}
+		return &runtime.BindingEventRequest{Name: "queue", Data: []byte("retry"), Metadata: m}
+	}
+	_, err = s.OnBindingEvent(ctx, queueEvent("1", nil))
+	assert.Error(t, err)
+	_, err = s.OnBindingEvent(ctx, queueEvent("2", nil))
+	assert.Error(t, err)
+	assert.Len(t, client.bindings, 2)
+	_, err = s.OnBindingEvent(ctx, queueEvent("1", map[string]string{"redelivered": "true"}))
+	assert.NoError(t, err)
+	if assert.Len(t, client.bindings, 3) {
+		in := client.bindings[2]
+		assert.Equal(t, "queue", in.Metadata["deadLetterInput"])
+		assert.Equal(t, "2", in.Metadata["deadLetterAttempts"])
+	}
+
+	stopTestServer(t, s)
+}
+
+func TestAsyncServiceInvocation(t *testing.T) {
+	env := `{
+  "name": "function-demo",
+  "version": "v1",
+  "runtime": "Async",
+  "port": "50003",
+  "inputs": {
+    "orders": {
+      "uri": "orders",
+      "componentType": "invoke"
+    }
+  }
+}`
+	ctx := context.Background()
+	fwk, err := createFramework(env)
+	if err != nil {
+		t.Fatalf("failed to create framework: %v", err)
+	}
+	fwk.RegisterPlugins(nil)
+
+	if err := fwk.Register(ctx, func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		assert.NotNil(t, ctx.GetInvocationEvent())
+		switch string(in) {
+		case "bad":
+			return ctx.ReturnOnInternalError(), ofctx.NewHTTPError(http.StatusBadRequest, errors.New("bad order"))
+		case "unavailable":
+			return ctx.ReturnOnSuccess().WithCode(http.StatusServiceUnavailable).WithData([]byte("try later")), nil
+		}
+		out := ctx.ReturnOnSuccess().WithData([]byte(fmt.Sprintf(`{"order":%q}`, in)))
+		return out.WithContentType("application/json"), nil
+	}); err != nil {
+		t.Fatalf("failed to register function: %v", err)
+	}
+
+	s := fwk.GetRuntime().GetHandler().(*async.FakeServer)
+	startTestServer(s)
+
+	out, err := s.OnInvoke(ctx, &cpb.InvokeRequest{
+		Method:      "orders",
+		Data:        &anypb.Any{Value: []byte("apple")},
+		ContentType: "text/plain",
+	})
+	assert.NoError(t, err)
+	if assert.NotNil(t, out) {
+		assert.Equal(t, "application/json", out.ContentType)
+		assert.Equal(t, []byte(`{"order":"apple"}`), out.Data.Value)
+	}
+
+	// the caller gets the status code of the typed error or the Out as Dapr returns it for a failed app
+	assertStatus := func(in string, code codes.Code, httpCode string, msg string) {
+		_, err := s.OnInvoke(ctx, &cpb.InvokeRequest{
+			Method: "orders",
+			Data:   &anypb.Any{Value: []byte(in)},
+		})
+		st, ok := status.FromError(err)
+		if !assert.True(t, ok) || !assert.Equal(t, code, st.Code()) || !assert.Len(t, st.Details(), 1) {
+			return
+		}
+		info := st.Details()[0].(*errdetails.ErrorInfo)
+		assert.Equal(t, httpCode, info.GetMetadata()["http.code"])
+		assert.Equal(t, msg, info.GetMetadata()["http.error_message"])
+	}
+	assertStatus("bad", codes.InvalidArgument, "400", "bad order")
+	assertStatus("unavailable", codes.Unavailable, "503", "try later")
+
+	stopTestServer(t, s)
 }
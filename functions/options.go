@@ -6,7 +6,18 @@ import (
 
 type FunctionOption = functions.FunctionOption
 
+type ConcurrencyPolicy = functions.ConcurrencyPolicy
+
+const (
+	ConcurrencyPolicyReject = functions.ConcurrencyPolicyReject
+	ConcurrencyPolicyWait   = functions.ConcurrencyPolicyWait
+)
+
 var (
-	WithFunctionPath    = functions.WithFunctionPath
-	WithFunctionMethods = functions.WithFunctionMethods
+	WithFunctionPath      = functions.WithFunctionPath
+	WithFunctionMethods   = functions.WithFunctionMethods
+	WithInputs            = functions.WithInputs
+	WithTimeout           = functions.WithTimeout
+	WithMaxConcurrency    = functions.WithMaxConcurrency
+	WithConcurrencyPolicy = functions.WithConcurrencyPolicy
 )
@@ -28,6 +28,14 @@ func CloudEvent(name string, fn func(context.Context, cloudevents.Event) error,
 	}
 }
 
+// CloudEventReply registers a CloudEvent function replying with the returned event,
+// e.g. for the request/reply of Knative Eventing through the broker
+func CloudEventReply(name string, fn func(context.Context, cloudevents.Event) (*cloudevents.Event, error), options ...FunctionOption) {
+	if err := registry.Default().RegisterCloudEventReply(name, fn, options...); err != nil {
+		log.Fatalf("failure to register function: %s", err)
+	}
+}
+
 // OpenFunction registers a OpenFunction function that becomes the function handler
 // served at "/" when environment variable `FUNCTION_TARGET=name`
 func OpenFunction(name string, fn func(ofctx.Context, []byte) (ofctx.Out, error), options ...FunctionOption) {
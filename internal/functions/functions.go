@@ -7,33 +7,50 @@ import (
 	"net/http"
 	"regexp"
 	"strings"
+	"time"
 
 	ofctx "github.com/OpenFunction/functions-framework-go/context"
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 )
 
 const (
-	HTTPType         = "http"
-	CloudEventType   = "cloudevent"
-	OpenFunctionType = "openfunction"
-	defaultPath      = "/"
+	HTTPType            = "http"
+	CloudEventType      = "cloudevent"
+	OpenFunctionType    = "openfunction"
+	DefaultPath         = "/"
 	functionNamePattern = "^[A-Za-z](?:[-_A-Za-z0-9]{0,61}[A-Za-z0-9])?$"
 )
 
 // RegisteredFunction represents a function that has been
 // registered with the registry.
 type RegisteredFunction struct {
-	functionName    string                                         // The name of the function
-	functionPath    string                                         // The path of the function, default is '/'
-	functionType    string                                         // The type of the function, not using it currently
-	functionMethods []string                                       // The allowed method of the function. Empty if allow all
-	httpFn          func(http.ResponseWriter, *http.Request)       // Optional: The user's HTTP function
-	cloudEventFn    func(context.Context, cloudevents.Event) error // Optional: The user's CloudEvent function
-	openFunctionFn  func(ofctx.Context, []byte) (ofctx.Out, error) // Optional: The user's OpenFunction function
+	functionName      string                                                               // The name of the function
+	functionPath      string                                                               // The path of the function, default is '/'
+	functionType      string                                                               // The type of the function, not using it currently
+	functionMethods   []string                                                             // The allowed method of the function. Empty if allow all
+	functionInputs    []string                                                             // The inputs served by the function in async runtime. Empty if serve all
+	timeout           time.Duration                                                        // The timeout of an invocation. Zero if use the default of the function context
+	maxConcurrency    int                                                                  // The max number of concurrent invocations. Zero if unlimited
+	policy            ConcurrencyPolicy                                                    // What to do with the invocations exceeding the max concurrency
+	httpFn            func(http.ResponseWriter, *http.Request)                             // Optional: The user's HTTP function
+	cloudEventFn      func(context.Context, cloudevents.Event) error                       // Optional: The user's CloudEvent function
+	cloudEventReplyFn func(context.Context, cloudevents.Event) (*cloudevents.Event, error) // Optional: The user's CloudEvent function replying with an event
+	openFunctionFn    func(ofctx.Context, []byte) (ofctx.Out, error)                       // Optional: The user's OpenFunction function
 }
 
 type FunctionOption func() (func(*RegisteredFunction), error)
 
+// ConcurrencyPolicy decides how to handle the invocations exceeding the max concurrency of a function.
+type ConcurrencyPolicy string
+
+const (
+	// ConcurrencyPolicyReject rejects the excess invocations with 429 Too Many Requests,
+	// or with a retry for the events of the async runtime.
+	ConcurrencyPolicyReject ConcurrencyPolicy = "reject"
+	// ConcurrencyPolicyWait queues the excess invocations until an invocation finishes.
+	ConcurrencyPolicyWait ConcurrencyPolicy = "wait"
+)
+
 func (rf *RegisteredFunction) setup(options ...FunctionOption) error {
 	if rf == nil {
 		return nil
@@ -64,6 +81,10 @@ func (rf *RegisteredFunction) setup(options ...FunctionOption) error {
 		return errors.New("Not allow to set function methods for CloudEvent function")
 	}
 
+	if rf.GetFunctionType() != OpenFunctionType && len(rf.GetFunctionInputs()) > 0 {
+		return errors.New("Only allow to set function inputs for OpenFunction function")
+	}
+
 	return nil
 }
 
@@ -75,6 +96,13 @@ func (rf *RegisteredFunction) GetPath() string {
 	return rf.functionPath
 }
 
+// CopyWithPath returns a copy of the function served at the path.
+func (rf *RegisteredFunction) CopyWithPath(path string) *RegisteredFunction {
+	copied := *rf
+	copied.functionPath = path
+	return &copied
+}
+
 func (rf *RegisteredFunction) GetFunctionType() string {
 	return rf.functionType
 }
@@ -83,6 +111,25 @@ func (rf *RegisteredFunction) GetFunctionMethods() []string {
 	return rf.functionMethods
 }
 
+func (rf *RegisteredFunction) GetFunctionInputs() []string {
+	return rf.functionInputs
+}
+
+func (rf *RegisteredFunction) GetTimeout() time.Duration {
+	return rf.timeout
+}
+
+func (rf *RegisteredFunction) GetMaxConcurrency() int {
+	return rf.maxConcurrency
+}
+
+func (rf *RegisteredFunction) GetConcurrencyPolicy() ConcurrencyPolicy {
+	if rf.policy == "" {
+		return ConcurrencyPolicyReject
+	}
+	return rf.policy
+}
+
 func (rf *RegisteredFunction) GetHTTPFunction() func(http.ResponseWriter, *http.Request) {
 	return rf.httpFn
 }
@@ -91,6 +138,10 @@ func (rf *RegisteredFunction) GetCloudEventFunction() func(context.Context, clou
 	return rf.cloudEventFn
 }
 
+func (rf *RegisteredFunction) GetCloudEventReplyFunction() func(context.Context, cloudevents.Event) (*cloudevents.Event, error) {
+	return rf.cloudEventReplyFn
+}
+
 func (rf *RegisteredFunction) GetOpenFunctionFunction() func(ofctx.Context, []byte) (ofctx.Out, error) {
 	return rf.openFunctionFn
 }
@@ -110,7 +161,7 @@ func properOption(setter func(*RegisteredFunction)) FunctionOption {
 }
 
 func New(options ...FunctionOption) (*RegisteredFunction, error) {
-	rf := &RegisteredFunction{functionPath: defaultPath}
+	rf := &RegisteredFunction{functionPath: DefaultPath}
 
 	if err := rf.setup(options...); err != nil {
 		return nil, err
@@ -149,9 +200,9 @@ func isValidFunctionName(name string) bool {
 //
 // For example:
 //
-//     WithFunctionPath("/products/")
-//     WithFunctionPath("/products/{key}")
-//     WithFunctionPath("/articles/{category}/{id:[0-9]+}")
+//	WithFunctionPath("/products/")
+//	WithFunctionPath("/products/{key}")
+//	WithFunctionPath("/articles/{category}/{id:[0-9]+}")
 //
 // Variable names must be unique in a given route. They can be retrieved
 // calling ofnctx.Vars(request).
@@ -187,6 +238,55 @@ func WithFunctionMethods(methods ...string) FunctionOption {
 	})
 }
 
+// WithInputs selects the inputs in the function context served by the function, e.g.:
+// "kafka-in", "cron". It is used by the async runtime to route the events of
+// several functions deployed in one pod, all the inputs are served by default.
+func WithInputs(inputs ...string) FunctionOption {
+	if len(inputs) == 0 {
+		return failedOption(errors.New("Empty function inputs"))
+	}
+
+	return properOption(func(rf *RegisteredFunction) {
+		rf.functionInputs = inputs
+	})
+}
+
+// WithTimeout bounds the execution time of an invocation, the native context of the function
+// is cancelled when the timeout is exceeded and the invocation fails with a timeout error.
+func WithTimeout(timeout time.Duration) FunctionOption {
+	if timeout <= 0 {
+		return failedOption(fmt.Errorf("Invalid function timeout: %s", timeout))
+	}
+
+	return properOption(func(rf *RegisteredFunction) {
+		rf.timeout = timeout
+	})
+}
+
+// WithMaxConcurrency limits the number of the concurrent invocations of the function,
+// the excess invocations are handled as the policy set by WithConcurrencyPolicy.
+func WithMaxConcurrency(n int) FunctionOption {
+	if n <= 0 {
+		return failedOption(fmt.Errorf("Invalid function max concurrency: %d", n))
+	}
+
+	return properOption(func(rf *RegisteredFunction) {
+		rf.maxConcurrency = n
+	})
+}
+
+// WithConcurrencyPolicy sets how to handle the invocations exceeding the max concurrency,
+// ConcurrencyPolicyReject is used by default.
+func WithConcurrencyPolicy(policy ConcurrencyPolicy) FunctionOption {
+	if policy != ConcurrencyPolicyReject && policy != ConcurrencyPolicyWait {
+		return failedOption(fmt.Errorf("Invalid function concurrency policy: %s", policy))
+	}
+
+	return properOption(func(rf *RegisteredFunction) {
+		rf.policy = policy
+	})
+}
+
 func WithHTTP(fn func(http.ResponseWriter, *http.Request)) FunctionOption {
 	if fn == nil {
 		return failedOption(errors.New("Function is nil"))
@@ -209,6 +309,19 @@ func WithCloudEvent(fn func(context.Context, cloudevents.Event) error) FunctionO
 	})
 }
 
+// WithCloudEventReply registers a CloudEvent function whose event is the reply to the request,
+// a nil event replies with no event.
+func WithCloudEventReply(fn func(context.Context, cloudevents.Event) (*cloudevents.Event, error)) FunctionOption {
+	if fn == nil {
+		return failedOption(errors.New("Function is nil"))
+	}
+
+	return properOption(func(rf *RegisteredFunction) {
+		rf.functionType = CloudEventType
+		rf.cloudEventReplyFn = fn
+	})
+}
+
 func WithOpenFunction(fn func(ofctx.Context, []byte) (ofctx.Out, error)) FunctionOption {
 	if fn == nil {
 		return failedOption(errors.New("Function is nil"))
@@ -6,6 +6,7 @@ import (
 	"net/http"
 	"testing"
 	"reflect"
+	"time"
 
 	ofctx "github.com/OpenFunction/functions-framework-go/context"
 	cloudevents "github.com/cloudevents/sdk-go/v2"
@@ -77,6 +78,27 @@ func TestNewCloudEventFunction(t *testing.T) {
 	}
 }
 
+func TestNewCloudEventReplyFunction(t *testing.T) {
+	fn, err := New(WithFunctionName("foo"), WithCloudEventReply(func(ctx context.Context, ce cloudevents.Event) (*cloudevents.Event, error) {
+		return &ce, nil
+	}))
+	if err != nil {
+		t.Fatalf("Fail to Create cloudevent reply function, error: %s", err)
+	}
+
+	if fn.GetFunctionType() != CloudEventType {
+		t.Errorf("Expected function type to be %s, got %s", CloudEventType, fn.GetFunctionType())
+	}
+
+	if fn.GetCloudEventReplyFunction() == nil || fn.GetCloudEventFunction() != nil {
+		t.Errorf("Expected only the reply function to be registered")
+	}
+
+	if _, err := New(WithFunctionName("foo"), WithCloudEventReply(nil)); err == nil {
+		t.Errorf("Expected error of nil function")
+	}
+}
+
 func TestNewOpenFunctionFunction(t *testing.T) {
 
 	name := "foo"
@@ -105,3 +127,79 @@ func TestNewOpenFunctionFunction(t *testing.T) {
 		t.Errorf("Expected function methods to be %s, got %s", methods, fn.GetFunctionMethods())
 	}
 }
+
+func TestNewOpenFunctionFunctionWithInputs(t *testing.T) {
+
+	name := "foo"
+	inputs := []string{"kafka-in", "cron"}
+	fn, err := New(WithFunctionName(name), WithInputs(inputs...), WithOpenFunction(func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		return ctx.ReturnOnSuccess(), nil
+	}))
+	if err != nil {
+		t.Fatalf("Fail to Create openfunction function with name: %s, inputs: %s", name, inputs)
+	}
+
+	if !reflect.DeepEqual(fn.GetFunctionInputs(), inputs) {
+		t.Errorf("Expected function inputs to be %s, got %s", inputs, fn.GetFunctionInputs())
+	}
+
+	_, err = New(WithFunctionName(name), WithInputs(inputs...), WithHTTP(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "Hello World!")
+	}))
+	if err == nil {
+		t.Error("Expected fail to create http function with inputs, but succeed")
+	}
+}
+
+func TestNewFunctionWithTimeout(t *testing.T) {
+
+	name := "foo"
+	timeout := 3 * time.Second
+	fn, err := New(WithFunctionName(name), WithTimeout(timeout), WithOpenFunction(func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		return ctx.ReturnOnSuccess(), nil
+	}))
+	if err != nil {
+		t.Fatalf("Fail to Create openfunction function with name: %s, timeout: %s", name, timeout)
+	}
+
+	if fn.GetTimeout() != timeout {
+		t.Errorf("Expected function timeout to be %s, got %s", timeout, fn.GetTimeout())
+	}
+
+	_, err = New(WithFunctionName(name), WithTimeout(0), WithOpenFunction(func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		return ctx.ReturnOnSuccess(), nil
+	}))
+	if err == nil {
+		t.Error("Expected fail to create function with zero timeout, but succeed")
+	}
+}
+
+func TestNewFunctionWithMaxConcurrency(t *testing.T) {
+
+	name := "foo"
+	fn, err := New(WithFunctionName(name), WithMaxConcurrency(10), WithOpenFunction(func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		return ctx.ReturnOnSuccess(), nil
+	}))
+	if err != nil {
+		t.Fatalf("Fail to Create openfunction function with name: %s, max concurrency: %d", name, 10)
+	}
+
+	if fn.GetMaxConcurrency() != 10 || fn.GetConcurrencyPolicy() != ConcurrencyPolicyReject {
+		t.Errorf("Expected function max concurrency to be 10 with policy %s, got %d with policy %s", ConcurrencyPolicyReject, fn.GetMaxConcurrency(), fn.GetConcurrencyPolicy())
+	}
+
+	fn, err = New(WithFunctionName(name), WithMaxConcurrency(10), WithConcurrencyPolicy(ConcurrencyPolicyWait), WithOpenFunction(func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		return ctx.ReturnOnSuccess(), nil
+	}))
+	if err != nil || fn.GetConcurrencyPolicy() != ConcurrencyPolicyWait {
+		t.Errorf("Expected function concurrency policy to be %s", ConcurrencyPolicyWait)
+	}
+
+	if _, err := New(WithFunctionName(name), WithMaxConcurrency(0)); err == nil {
+		t.Error("Expected fail to create function with zero max concurrency, but succeed")
+	}
+
+	if _, err := New(WithFunctionName(name), WithConcurrencyPolicy("drop")); err == nil {
+		t.Error("Expected fail to create function with invalid concurrency policy, but succeed")
+	}
+}
@@ -92,6 +92,31 @@ func (r *Registry) RegisterCloudEvent(name string, fn func(context.Context, clou
 	return nil
 }
 
+// RegisterCloudEventReply a CloudEvent function replying with an event with a given name
+func (r *Registry) RegisterCloudEventReply(name string, fn func(context.Context, cloudevents.Event) (*cloudevents.Event, error), options ...functions.FunctionOption) error {
+	if _, ok := r.functions[name]; ok {
+		return fmt.Errorf("function name already registered: %s", name)
+	}
+
+	// append at the end to overwrite any option from user
+	options = append(options, functions.WithFunctionName(name))
+	options = append(options, functions.WithCloudEventReply(fn))
+
+	function, err := functions.New(options...)
+	if err != nil {
+		return err
+	}
+
+	path := function.GetPath()
+	if _, ok := r.paths[path]; ok {
+		return fmt.Errorf("function path already registered: %s", path)
+	}
+
+	r.functions[name] = function
+	r.paths[path] = name
+	return nil
+}
+
 // RegisterOpenFunction a OpenFunction function with a given name
 func (r *Registry) RegisterOpenFunction(name string, fn func(ofctx.Context, []byte) (ofctx.Out, error), options ...functions.FunctionOption) error {
 
@@ -108,6 +133,13 @@ func (r *Registry) RegisterOpenFunction(name string, fn func(ofctx.Context, []by
 		return err
 	}
 
+	// the functions serving selected inputs are routed by the inputs in the async runtime, which checks
+	// the inputs claimed by the functions, and the knative runtime checks their paths instead
+	if len(function.GetFunctionInputs()) > 0 {
+		r.functions[name] = function
+		return nil
+	}
+
 	path := function.GetPath()
 	if _, ok := r.paths[path]; ok {
 		return fmt.Errorf("function path already registered: %s", path)
@@ -54,6 +54,21 @@ func TestRegisterCloudEvent(t *testing.T) {
 	}
 }
 
+func TestRegisterCloudEventReply(t *testing.T) {
+	registry := New()
+	registry.RegisterCloudEventReply("cereplyfn", func(ctx context.Context, ce cloudevents.Event) (*cloudevents.Event, error) {
+		return &ce, nil
+	})
+
+	fn, ok := registry.GetRegisteredFunction("cereplyfn")
+	if !ok {
+		t.Fatalf("Expected function to be registered")
+	}
+	if fn.GetFunctionType() != functions.CloudEventType || fn.GetCloudEventReplyFunction() == nil {
+		t.Errorf("Expected function to be a CloudEvent function replying with an event")
+	}
+}
+
 func TestRegisterOpenFunction(t *testing.T) {
 	registry := New()
 	registry.RegisterOpenFunction("ofnfn", func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
@@ -107,3 +122,17 @@ func TestRegisterMultipleFunctionsError(t *testing.T) {
 		t.Error("Expected error registering function with same name")
 	}
 }
+
+func TestRegisterFunctionsWithInputs(t *testing.T) {
+	registry := New()
+	if err := registry.RegisterOpenFunction("kafka-handler", func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		return ctx.ReturnOnSuccess(), nil
+	}, functions.WithInputs("kafka-in")); err != nil {
+		t.Error("Expected \"kafka-handler\" function to be registered")
+	}
+	if err := registry.RegisterOpenFunction("cron-handler", func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		return ctx.ReturnOnSuccess(), nil
+	}, functions.WithInputs("cron")); err != nil {
+		t.Error("Expected \"cron-handler\" function to be registered on the same path")
+	}
+}
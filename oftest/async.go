@@ -0,0 +1,200 @@
+package oftest
+
+import (
+	"context"
+	"fmt"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	cpb "github.com/dapr/dapr/pkg/proto/common/v1"
+	pb "github.com/dapr/dapr/pkg/proto/runtime/v1"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	ofctx "github.com/OpenFunction/functions-framework-go/context"
+	"github.com/OpenFunction/functions-framework-go/functions"
+	internalfunctions "github.com/OpenFunction/functions-framework-go/internal/functions"
+	"github.com/OpenFunction/functions-framework-go/runtime/async"
+)
+
+// the port of the fake runtime, which is never listened on
+const harnessPort = "50001"
+
+// Result is the response of the Async runtime to an event delivered by AsyncHarness.
+type Result struct {
+	// Data is the response of a binding event or a service invocation.
+	Data []byte
+	// ContentType is the content type of the response of a service invocation.
+	ContentType string
+	// Retry reports whether Dapr would redeliver the event.
+	Retry bool
+	// Dropped reports whether Dapr would drop the failed topic event.
+	Dropped bool
+	// Err is the error returned by the handler to Dapr.
+	Err error
+	// Outputs are the calls to the outputs made on handling the event.
+	Outputs []*Call
+}
+
+// AsyncHarness runs the functions in the Async runtime in-process, the events are delivered to the handlers
+// of the runtime as Dapr does through the AppCallback protocol, and the outputs are served by a FakeDaprClient.
+// The events should be delivered one by one for the outputs of each Result to be accurate.
+type AsyncHarness struct {
+	ctx     *ofctx.FunctionContext
+	client  *FakeDaprClient
+	runtime *async.Runtime
+	server  *async.FakeServer
+}
+
+// NewAsyncHarness creates the Async runtime for the context built by builder.
+func NewAsyncHarness(builder *ContextBuilder) (*AsyncHarness, error) {
+	ctx, client, err := builder.WithRuntime(ofctx.Async).Build()
+	if err != nil {
+		return nil, err
+	}
+	rt, err := async.NewFakeAsyncRuntime(harnessPort, "")
+	if err != nil {
+		return nil, err
+	}
+	return &AsyncHarness{
+		ctx:     ctx,
+		client:  client,
+		runtime: rt,
+		server:  rt.GetHandler().(*async.FakeServer),
+	}, nil
+}
+
+// Register registers the function on the inputs of the context, or the inputs selected by functions.WithInputs.
+func (h *AsyncHarness) Register(fn func(ofctx.Context, []byte) (ofctx.Out, error), opts ...functions.FunctionOption) error {
+	options := append([]functions.FunctionOption{
+		internalfunctions.WithFunctionName(h.ctx.GetName()),
+		internalfunctions.WithOpenFunction(fn),
+	}, opts...)
+	rf, err := internalfunctions.New(options...)
+	if err != nil {
+		return err
+	}
+	return h.runtime.RegisterOpenFunction(h.ctx, nil, nil, rf)
+}
+
+// Context returns the context of the functions.
+func (h *AsyncHarness) Context() *ofctx.FunctionContext {
+	return h.ctx
+}
+
+// Client returns the FakeDaprClient serving the outputs, which is nil if the builder replaces the Dapr client.
+func (h *AsyncHarness) Client() *FakeDaprClient {
+	return h.client
+}
+
+// Close stops the runtime.
+func (h *AsyncHarness) Close() error {
+	return h.runtime.Stop(context.Background())
+}
+
+// DeliverBindingEvent delivers the data of the binding of the input.
+func (h *AsyncHarness) DeliverBindingEvent(c context.Context, inputName string, data []byte, metadata map[string]string) *Result {
+	input, err := h.getInput(inputName, ofctx.OpenFuncBinding)
+	if err != nil {
+		return &Result{Err: err}
+	}
+	return h.deliver(func(result *Result) {
+		out, err := h.server.OnBindingEvent(c, &pb.BindingEventRequest{
+			Name:     input.ComponentName,
+			Data:     data,
+			Metadata: metadata,
+		})
+		if out != nil {
+			result.Data = out.Data
+		}
+		// the binding redelivers the event on the errors
+		result.Err, result.Retry = err, err != nil
+	})
+}
+
+// DeliverTopicEvent delivers a raw payload published to the topic of the input without a CloudEvent envelope.
+func (h *AsyncHarness) DeliverTopicEvent(c context.Context, inputName string, data []byte, contentType string) *Result {
+	input, err := h.getInput(inputName, ofctx.OpenFuncTopic)
+	if err != nil {
+		return &Result{Err: err}
+	}
+	return h.deliverTopicEvent(c, &pb.TopicEventRequest{
+		DataContentType: contentType,
+		Data:            data,
+		Topic:           input.Uri,
+		PubsubName:      input.ComponentName,
+	})
+}
+
+// DeliverCloudEvent delivers the CloudEvent envelope published to the topic of the input.
+func (h *AsyncHarness) DeliverCloudEvent(c context.Context, inputName string, event cloudevents.Event) *Result {
+	input, err := h.getInput(inputName, ofctx.OpenFuncTopic)
+	if err != nil {
+		return &Result{Err: err}
+	}
+	return h.deliverTopicEvent(c, &pb.TopicEventRequest{
+		Id:              event.ID(),
+		Source:          event.Source(),
+		Type:            event.Type(),
+		SpecVersion:     event.SpecVersion(),
+		DataContentType: event.DataContentType(),
+		Data:            event.Data(),
+		Topic:           input.Uri,
+		PubsubName:      input.ComponentName,
+	})
+}
+
+// DeliverInvocation invokes the method of the service invocation input.
+func (h *AsyncHarness) DeliverInvocation(c context.Context, inputName string, data []byte, contentType string) *Result {
+	input, err := h.getInput(inputName, ofctx.OpenFuncInvoke)
+	if err != nil {
+		return &Result{Err: err}
+	}
+	return h.deliver(func(result *Result) {
+		out, err := h.server.OnInvoke(c, &cpb.InvokeRequest{
+			Method:      input.Uri,
+			Data:        &anypb.Any{Value: data},
+			ContentType: contentType,
+		})
+		if out != nil {
+			result.Data = out.GetData().GetValue()
+			result.ContentType = out.GetContentType()
+		}
+		result.Err = err
+	})
+}
+
+func (h *AsyncHarness) deliverTopicEvent(c context.Context, in *pb.TopicEventRequest) *Result {
+	return h.deliver(func(result *Result) {
+		out, err := h.server.OnTopicEvent(c, in)
+		result.Err = err
+		switch out.GetStatus() {
+		case pb.TopicEventResponse_RETRY:
+			result.Retry = true
+		case pb.TopicEventResponse_DROP:
+			result.Dropped = true
+		}
+	})
+}
+
+// deliver captures the outputs of the delivery.
+func (h *AsyncHarness) deliver(fn func(result *Result)) *Result {
+	result := &Result{}
+	if h.client == nil {
+		fn(result)
+		return result
+	}
+	n := h.client.callCount()
+	fn(result)
+	result.Outputs = h.client.callsSince(n)
+	return result
+}
+
+func (h *AsyncHarness) getInput(inputName string, t ofctx.ResourceType) (*ofctx.Input, error) {
+	input, ok := h.ctx.GetInputs()[inputName]
+	if !ok {
+		return nil, fmt.Errorf("input %s not found", inputName)
+	}
+	if input.GetType() != t {
+		return nil, fmt.Errorf("input %s is not of type %s", inputName, t)
+	}
+	return input, nil
+}
@@ -0,0 +1,100 @@
+package oftest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/stretchr/testify/assert"
+
+	ofctx "github.com/OpenFunction/functions-framework-go/context"
+	"github.com/OpenFunction/functions-framework-go/functions"
+)
+
+func TestAsyncHarness(t *testing.T) {
+	h, err := NewAsyncHarness(NewContextBuilder().
+		WithInput("kafka", &ofctx.Input{ComponentName: "kafka", ComponentType: "bindings.kafka"}).
+		WithInput("sub", &ofctx.Input{ComponentName: "msg", ComponentType: "pubsub.kafka", Uri: "orders"}).
+		WithInput("invoke", &ofctx.Input{ComponentType: "invoke", Uri: "orders"}).
+		WithOutput("topic", &ofctx.Output{ComponentName: "msg", ComponentType: "pubsub.kafka", Uri: "sample"}))
+	if err != nil {
+		t.Fatalf("failed to create harness: %v", err)
+	}
+	defer h.Close()
+
+	if err := h.Register(func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		switch string(in) {
+		case "retry":
+			return ctx.ReturnOnInternalError(), ofctx.NewRetryableError(errors.New("database is down"))
+		case "drop":
+			return ctx.ReturnOnInternalError(), ofctx.NewDropError(errors.New("bad order"))
+		}
+		if _, err := ctx.Send("topic", in); err != nil {
+			return ctx.ReturnOnInternalError(), err
+		}
+		return ctx.ReturnOnSuccess().WithData(in).WithContentType("text/plain"), nil
+	}); err != nil {
+		t.Fatalf("failed to register function: %v", err)
+	}
+
+	c := context.Background()
+	result := h.DeliverBindingEvent(c, "kafka", []byte("hello"), nil)
+	assert.NoError(t, result.Err)
+	assert.Equal(t, []byte("hello"), result.Data)
+	if assert.Len(t, result.Outputs, 1) {
+		assert.Equal(t, "topic", result.Outputs[0].Output)
+		assert.Equal(t, []byte("hello"), result.Outputs[0].Data)
+	}
+
+	result = h.DeliverBindingEvent(c, "kafka", []byte("retry"), nil)
+	assert.True(t, result.Retry)
+	assert.Len(t, result.Outputs, 0)
+
+	result = h.DeliverTopicEvent(c, "sub", []byte("retry"), "text/plain")
+	assert.Error(t, result.Err)
+	assert.True(t, result.Retry)
+
+	result = h.DeliverTopicEvent(c, "sub", []byte("drop"), "text/plain")
+	assert.Error(t, result.Err)
+	assert.True(t, result.Dropped)
+
+	event := cloudevents.NewEvent()
+	event.SetID("1")
+	event.SetSource("test")
+	event.SetType("test")
+	_ = event.SetData("text/plain", []byte("order"))
+	result = h.DeliverCloudEvent(c, "sub", event)
+	assert.NoError(t, result.Err)
+	assert.False(t, result.Retry)
+	assert.Len(t, result.Outputs, 1)
+
+	result = h.DeliverInvocation(c, "invoke", []byte("order"), "text/plain")
+	assert.NoError(t, result.Err)
+	assert.Equal(t, []byte("order"), result.Data)
+	assert.Equal(t, "text/plain", result.ContentType)
+
+	result = h.DeliverTopicEvent(c, "kafka", []byte("hello"), "")
+	assert.EqualError(t, result.Err, "input kafka is not of type pubsub")
+}
+
+func TestAsyncHarnessInputs(t *testing.T) {
+	h, err := NewAsyncHarness(NewContextBuilder().
+		WithInput("a", &ofctx.Input{ComponentName: "a", ComponentType: "bindings.kafka"}).
+		WithInput("b", &ofctx.Input{ComponentName: "b", ComponentType: "bindings.kafka"}))
+	if err != nil {
+		t.Fatalf("failed to create harness: %v", err)
+	}
+	defer h.Close()
+
+	for _, name := range []string{"a", "b"} {
+		n := name
+		if err := h.Register(func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+			return ctx.ReturnOnSuccess().WithData([]byte(n)), nil
+		}, functions.WithInputs(n)); err != nil {
+			t.Fatalf("failed to register function: %v", err)
+		}
+	}
+	assert.Equal(t, []byte("a"), h.DeliverBindingEvent(context.Background(), "a", nil, nil).Data)
+	assert.Equal(t, []byte("b"), h.DeliverBindingEvent(context.Background(), "b", nil, nil).Data)
+}
@@ -0,0 +1,204 @@
+// Package oftest helps to unit-test the functions without Dapr or the environment variables of the framework.
+//
+// A test builds the context of the function with a fake Dapr client, calls the function,
+// and asserts its Out and the calls recorded by the client:
+//
+//	ctx, client, err := oftest.NewContextBuilder().
+//		WithOutput("sample", &ofctx.Output{ComponentName: "msg", ComponentType: "pubsub.kafka", Uri: "sample"}).
+//		WithBindingEvent("cron", &common.BindingEvent{Data: []byte("hello")}).
+//		Build()
+//	out, err := MyFunction(ctx, ctx.GetInnerEvent().GetUserData())
+//	calls := client.Sent("sample")
+package oftest
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	dapr "github.com/dapr/go-sdk/client"
+	"github.com/dapr/go-sdk/service/common"
+
+	ofctx "github.com/OpenFunction/functions-framework-go/context"
+)
+
+const defaultFunctionName = "test-function"
+
+// ContextBuilder builds the context of a function under test.
+type ContextBuilder struct {
+	spec          contextSpec
+	nativeContext context.Context
+	daprClient    dapr.Client
+	secrets       map[string]map[string]map[string]string
+	inputName     string
+	event         interface{}
+	w             http.ResponseWriter
+	r             *http.Request
+}
+
+// contextSpec is the FUNC_CONTEXT of the function under test.
+type contextSpec struct {
+	Name         string                        `json:"name"`
+	Version      string                        `json:"version"`
+	Runtime      ofctx.Runtime                 `json:"runtime"`
+	Inputs       map[string]*ofctx.Input       `json:"inputs,omitempty"`
+	Outputs      map[string]*ofctx.Output      `json:"outputs,omitempty"`
+	States       map[string]*ofctx.StateStore  `json:"states,omitempty"`
+	SecretStores map[string]*ofctx.SecretStore `json:"secretStores,omitempty"`
+}
+
+// NewContextBuilder creates a builder of the context of an async function named test-function.
+func NewContextBuilder() *ContextBuilder {
+	return &ContextBuilder{
+		spec: contextSpec{
+			Name:    defaultFunctionName,
+			Version: "v1.0.0",
+			Runtime: ofctx.Async,
+			Inputs:  map[string]*ofctx.Input{},
+			Outputs: map[string]*ofctx.Output{},
+		},
+	}
+}
+
+// WithName sets the name of the function.
+func (b *ContextBuilder) WithName(name string) *ContextBuilder {
+	b.spec.Name = name
+	return b
+}
+
+// WithRuntime sets the runtime of the function, which is Async by default.
+func (b *ContextBuilder) WithRuntime(runtime ofctx.Runtime) *ContextBuilder {
+	b.spec.Runtime = runtime
+	return b
+}
+
+// WithInput adds an input to the context.
+func (b *ContextBuilder) WithInput(name string, input *ofctx.Input) *ContextBuilder {
+	b.spec.Inputs[name] = input
+	return b
+}
+
+// WithOutput adds an output to the context.
+func (b *ContextBuilder) WithOutput(name string, output *ofctx.Output) *ContextBuilder {
+	b.spec.Outputs[name] = output
+	return b
+}
+
+// WithStateStore adds a state store to the context.
+func (b *ContextBuilder) WithStateStore(name string, store *ofctx.StateStore) *ContextBuilder {
+	if b.spec.States == nil {
+		b.spec.States = map[string]*ofctx.StateStore{}
+	}
+	b.spec.States[name] = store
+	return b
+}
+
+// WithSecretStore adds a secret store to the context.
+func (b *ContextBuilder) WithSecretStore(name string, store *ofctx.SecretStore) *ContextBuilder {
+	if b.spec.SecretStores == nil {
+		b.spec.SecretStores = map[string]*ofctx.SecretStore{}
+	}
+	b.spec.SecretStores[name] = store
+	return b
+}
+
+// WithSecret sets the values of the secret in the secret store added by WithSecretStore, the secrets are
+// kept by the FakeDaprClient, so that the {{secret:store/key}} references in the metadata are resolved by Build.
+func (b *ContextBuilder) WithSecret(storeName, key string, values map[string]string) *ContextBuilder {
+	if b.secrets == nil {
+		b.secrets = map[string]map[string]map[string]string{}
+	}
+	if b.secrets[storeName] == nil {
+		b.secrets[storeName] = map[string]map[string]string{}
+	}
+	b.secrets[storeName][key] = values
+	return b
+}
+
+// WithBindingEvent sets the binding event received from the input.
+func (b *ContextBuilder) WithBindingEvent(inputName string, event *common.BindingEvent) *ContextBuilder {
+	b.inputName, b.event = inputName, event
+	return b
+}
+
+// WithTopicEvent sets the topic event received from the input.
+func (b *ContextBuilder) WithTopicEvent(inputName string, event *common.TopicEvent) *ContextBuilder {
+	b.inputName, b.event = inputName, event
+	return b
+}
+
+// WithCloudEvent sets the cloudevent received by the function.
+func (b *ContextBuilder) WithCloudEvent(event *cloudevents.Event) *ContextBuilder {
+	b.inputName, b.event = "", event
+	return b
+}
+
+// WithSyncRequest sets the http request received by the function and its response writer,
+// e.g. an httptest.ResponseRecorder.
+func (b *ContextBuilder) WithSyncRequest(w http.ResponseWriter, r *http.Request) *ContextBuilder {
+	b.w, b.r = w, r
+	return b
+}
+
+// WithNativeContext sets the native context of the invocation, which is the context of the sync request
+// or context.Background() by default.
+func (b *ContextBuilder) WithNativeContext(c context.Context) *ContextBuilder {
+	b.nativeContext = c
+	return b
+}
+
+// WithDaprClient replaces the FakeDaprClient of the context, Build returns a nil FakeDaprClient then,
+// and the secrets of WithSecret are not set.
+func (b *ContextBuilder) WithDaprClient(client dapr.Client) *ContextBuilder {
+	b.daprClient = client
+	return b
+}
+
+// Build validates the context as FUNC_CONTEXT and returns it with the FakeDaprClient serving its outputs.
+func (b *ContextBuilder) Build() (*ofctx.FunctionContext, *FakeDaprClient, error) {
+	data, err := json.Marshal(b.spec)
+	if err != nil {
+		return nil, nil, err
+	}
+	ctx, err := ofctx.ParseRuntimeContext(data)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var fake *FakeDaprClient
+	client := b.daprClient
+	if client == nil {
+		fake = NewFakeDaprClient(ctx.GetOutputs())
+		client = fake
+		for storeName, secrets := range b.secrets {
+			store, ok := b.spec.SecretStores[storeName]
+			if !ok {
+				return nil, nil, fmt.Errorf("secret store %s not found", storeName)
+			}
+			for key, values := range secrets {
+				fake.SetSecret(store.ComponentName, key, values)
+			}
+		}
+	}
+	if err := ctx.SetDaprClient(client); err != nil {
+		return nil, nil, err
+	}
+
+	nativeContext := b.nativeContext
+	if b.r != nil {
+		ctx.SetSyncRequest(b.w, b.r)
+		if nativeContext == nil {
+			nativeContext = b.r.Context()
+		}
+	}
+	if nativeContext == nil {
+		nativeContext = context.Background()
+	}
+	ctx.SetNativeContext(nativeContext)
+	if b.event != nil {
+		ctx.SetEvent(b.inputName, b.event)
+	}
+	return ctx, fake, nil
+}
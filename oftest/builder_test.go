@@ -0,0 +1,187 @@
+package oftest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/dapr/go-sdk/service/common"
+	"github.com/stretchr/testify/assert"
+
+	ofctx "github.com/OpenFunction/functions-framework-go/context"
+)
+
+func forward(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+	if _, err := ctx.Send("topic", in); err != nil {
+		return ctx.ReturnOnInternalError(), err
+	}
+	data, err := ctx.Send("binding", in, ofctx.SendWithOperation("get"))
+	if err != nil {
+		return ctx.ReturnOnInternalError(), err
+	}
+	return ctx.ReturnOnSuccess().WithData(data), nil
+}
+
+func newForwardBuilder() *ContextBuilder {
+	return NewContextBuilder().
+		WithInput("cron", &ofctx.Input{ComponentName: "cron", ComponentType: "bindings.cron"}).
+		WithOutput("topic", &ofctx.Output{ComponentName: "msg", ComponentType: "pubsub.kafka", Uri: "sample"}).
+		WithOutput("binding", &ofctx.Output{ComponentName: "echo", ComponentType: "bindings.http", Operation: "post"}).
+		WithBindingEvent("cron", &common.BindingEvent{Data: []byte("hello")})
+}
+
+func TestContextBuilder(t *testing.T) {
+	ctx, client, err := newForwardBuilder().Build()
+	if err != nil {
+		t.Fatalf("failed to build context: %v", err)
+	}
+	assert.Equal(t, defaultFunctionName, ctx.GetName())
+	assert.Equal(t, "cron", ctx.GetInputName())
+	assert.NotNil(t, ctx.GetBindingEvent())
+
+	client.SetResponses("binding", &Response{Data: []byte("first")}, &Response{Data: []byte("second")})
+	for _, expected := range []string{"first", "second", "second"} {
+		out, err := forward(ctx, ctx.GetInnerEvent().GetUserData())
+		assert.NoError(t, err)
+		assert.Equal(t, expected, string(out.GetData()))
+	}
+
+	published := client.Published()
+	if assert.Len(t, published, 3) {
+		assert.Equal(t, "topic", published[0].Output)
+		assert.Equal(t, "sample", published[0].Topic)
+		assert.Equal(t, []byte("hello"), published[0].Data)
+	}
+	bindings := client.Sent("binding")
+	if assert.Len(t, bindings, 3) {
+		assert.Equal(t, "echo", bindings[0].ComponentName)
+		assert.Equal(t, "get", bindings[0].Operation)
+	}
+	assert.Len(t, client.InvokedBindings(), 3)
+
+	client.Reset()
+	client.SetError("topic", errors.New("broker is down"))
+	_, err = forward(ctx, ctx.GetInnerEvent().GetUserData())
+	assert.EqualError(t, err, "broker is down")
+	assert.Len(t, client.Sent("topic"), 1)
+	assert.Len(t, client.Sent("binding"), 0)
+}
+
+func TestContextBuilderEvents(t *testing.T) {
+	ctx, _, err := NewContextBuilder().
+		WithInput("sub", &ofctx.Input{ComponentName: "msg", ComponentType: "pubsub.kafka", Uri: "sample"}).
+		WithTopicEvent("sub", &common.TopicEvent{Data: "hello"}).
+		Build()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "sub", ctx.GetInputName())
+		assert.Equal(t, []byte("hello"), ctx.GetInnerEvent().GetUserData())
+	}
+
+	event := cloudevents.NewEvent()
+	event.SetID("1")
+	event.SetSource("test")
+	event.SetType("test")
+	ctx, _, err = NewContextBuilder().WithRuntime(ofctx.Knative).WithCloudEvent(&event).Build()
+	if assert.NoError(t, err) {
+		assert.Equal(t, "1", ctx.GetCloudEvent().ID())
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+	ctx, _, err = NewContextBuilder().WithRuntime(ofctx.Knative).WithSyncRequest(w, r).Build()
+	if assert.NoError(t, err) {
+		assert.Equal(t, r, ctx.GetSyncRequest().Request)
+		assert.Equal(t, r.Context(), ctx.GetNativeContext())
+	}
+
+	// the context is validated as FUNC_CONTEXT
+	_, _, err = NewContextBuilder().WithOutput("bad", &ofctx.Output{ComponentType: "unknown"}).Build()
+	assert.Error(t, err)
+}
+
+func TestContextBuilderStores(t *testing.T) {
+	ctx, client, err := NewContextBuilder().
+		WithStateStore("orders", &ofctx.StateStore{ComponentName: "redis", ComponentType: "state.redis"}).
+		WithSecretStore("vault", &ofctx.SecretStore{ComponentName: "vault", ComponentType: "secretstores.hashicorp.vault"}).
+		WithSecret("vault", "kafka", map[string]string{"password": "secret"}).
+		WithOutput("topic", &ofctx.Output{ComponentName: "msg", ComponentType: "pubsub.kafka", Uri: "sample",
+			Metadata: map[string]string{"password": "{{secret:vault/kafka/password}}"}}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build context: %v", err)
+	}
+	assert.Equal(t, "secret", ctx.GetOutputs()["topic"].Metadata["password"])
+	secret, err := ctx.GetSecret("vault", "kafka")
+	assert.NoError(t, err)
+	assert.Equal(t, "secret", secret["password"])
+	_, err = ctx.GetSecret("vault", "missing")
+	assert.Error(t, err)
+
+	// the state is kept in memory with the etags
+	assert.NoError(t, ctx.SaveState("orders", "apple", []byte("1")))
+	item, err := ctx.GetState("orders", "apple")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []byte("1"), item.Value)
+		assert.Equal(t, "1", item.Etag)
+	}
+	assert.Error(t, ctx.SaveState("orders", "apple", []byte("2"), ofctx.StateWithETag("0")))
+	assert.NoError(t, ctx.SaveState("orders", "apple", []byte("2"), ofctx.StateWithETag("1")))
+	assert.NoError(t, ctx.ExecuteStateTransaction("orders", []*ofctx.StateOperation{
+		{Type: ofctx.StateOperationUpsert, Item: &ofctx.SetStateItem{Key: "banana", Value: []byte("3")}},
+		{Type: ofctx.StateOperationDelete, Item: &ofctx.SetStateItem{Key: "apple"}},
+	}))
+	items, err := ctx.GetBulkState("orders", []string{"apple", "banana"})
+	if assert.NoError(t, err) && assert.Len(t, items, 2) {
+		assert.Empty(t, items[0].Value)
+		assert.Equal(t, []byte("3"), items[1].Value)
+	}
+	value, ok := client.State("redis", "banana")
+	assert.True(t, ok)
+	assert.Equal(t, []byte("3"), value)
+
+	// the secret references are resolved by the secrets of the FakeDaprClient instead of panicking
+	_, _, err = NewContextBuilder().
+		WithSecretStore("vault", &ofctx.SecretStore{ComponentName: "vault", ComponentType: "secretstores.hashicorp.vault"}).
+		WithOutput("topic", &ofctx.Output{ComponentName: "msg", ComponentType: "pubsub.kafka", Uri: "sample",
+			Metadata: map[string]string{"password": "{{secret:vault/kafka/password}}"}}).
+		Build()
+	assert.Error(t, err)
+}
+
+func TestContextBuilderInvoke(t *testing.T) {
+	ctx, client, err := NewContextBuilder().
+		WithRuntime(ofctx.Knative).
+		WithOutput("orders", &ofctx.Output{ComponentType: "invoke", AppID: "order-service", Uri: "orders"}).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build context: %v", err)
+	}
+	defer client.Close()
+
+	client.SetResponses("orders",
+		&Response{Data: []byte(`{"id":1}`), StatusCode: http.StatusCreated, ContentType: "application/json"},
+		&Response{Data: []byte("out of stock"), StatusCode: http.StatusConflict})
+	resp, err := ctx.Invoke("orders", []byte(`{"item":"apple"}`))
+	if assert.NoError(t, err) {
+		assert.Equal(t, http.StatusCreated, resp.StatusCode)
+		assert.Equal(t, `{"id":1}`, string(resp.Data))
+	}
+	_, err = ctx.Invoke("orders", []byte(`{"item":"apple"}`))
+	assert.Equal(t, http.StatusConflict, ofctx.GetErrorStatusCode(err))
+
+	invocations := client.Sent("orders")
+	if assert.Len(t, invocations, 2) {
+		assert.Equal(t, "order-service", invocations[0].ComponentName)
+		assert.Equal(t, "orders", invocations[0].Method)
+		assert.Equal(t, `{"item":"apple"}`, string(invocations[0].Data))
+	}
+	assert.Len(t, client.Invocations(), 2)
+
+	// the methods which are not faked return an error
+	_, err = client.InvokeActor(context.Background(), nil)
+	assert.Error(t, err)
+}
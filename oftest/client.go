@@ -0,0 +1,335 @@
+package oftest
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sync"
+
+	pb "github.com/dapr/dapr/pkg/proto/runtime/v1"
+	"github.com/dapr/go-sdk/actor"
+	"github.com/dapr/go-sdk/actor/config"
+	dapr "github.com/dapr/go-sdk/client"
+	"google.golang.org/grpc"
+
+	ofctx "github.com/OpenFunction/functions-framework-go/context"
+)
+
+// errNotImplemented is returned by the methods of dapr.Client which FakeDaprClient does not fake.
+var errNotImplemented = errors.New("not implemented by FakeDaprClient")
+
+// Call is a publish, a binding invocation or a service invocation recorded by FakeDaprClient.
+type Call struct {
+	// Output is the name of the output the call is sent to, it is empty if no output matches the call.
+	Output        string
+	ComponentName string
+	// Topic is the topic of a publish.
+	Topic string
+	// Operation is the operation of a binding invocation.
+	Operation string
+	// Method is the method of a service invocation, the ComponentName is the app ID then.
+	Method      string
+	Data        []byte
+	ContentType string
+	Metadata    map[string]string
+}
+
+// Response is the scripted response of a call to an output.
+type Response struct {
+	Data     []byte
+	Metadata map[string]string
+	Err      error
+	// StatusCode and ContentType are the response of the app to a service invocation,
+	// the invocation fails as Dapr does if the status code is 400 or above.
+	StatusCode  int
+	ContentType string
+}
+
+// FakeDaprClient is a dapr.Client recording the publishes, the binding invocations and the service
+// invocations for assertions. The state stores and the secret stores are kept in memory, and the
+// other methods of dapr.Client, e.g. the actors, return an error.
+type FakeDaprClient struct {
+	mu          sync.Mutex
+	outputs     map[string]*ofctx.Output
+	responses   map[string][]*Response
+	published   []*Call
+	bindings    []*Call
+	invocations []*Call
+	// calls records all the calls in order
+	calls   []*Call
+	states  map[string]map[string]*stateEntry
+	secrets map[string]map[string]map[string]string
+
+	grpcServer *grpc.Server
+	grpcConn   *grpc.ClientConn
+	grpcClient pb.DaprClient
+}
+
+var _ dapr.Client = &FakeDaprClient{}
+
+// NewFakeDaprClient creates a FakeDaprClient matching the calls to the outputs.
+func NewFakeDaprClient(outputs map[string]*ofctx.Output) *FakeDaprClient {
+	return &FakeDaprClient{
+		outputs:   outputs,
+		responses: map[string][]*Response{},
+		states:    map[string]map[string]*stateEntry{},
+		secrets:   map[string]map[string]map[string]string{},
+	}
+}
+
+// SetResponses scripts the responses of the calls to the output in order, the last response
+// is returned for the rest of the calls. The calls succeed with no data by default.
+func (c *FakeDaprClient) SetResponses(outputName string, responses ...*Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.responses[outputName] = responses
+}
+
+// SetError fails all the calls to the output with err.
+func (c *FakeDaprClient) SetError(outputName string, err error) {
+	c.SetResponses(outputName, &Response{Err: err})
+}
+
+// Published returns the recorded publishes.
+func (c *FakeDaprClient) Published() []*Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*Call{}, c.published...)
+}
+
+// InvokedBindings returns the recorded binding invocations.
+func (c *FakeDaprClient) InvokedBindings() []*Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*Call{}, c.bindings...)
+}
+
+// Sent returns the recorded calls to the output.
+func (c *FakeDaprClient) Sent(outputName string) []*Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var calls []*Call
+	for _, call := range c.calls {
+		if call.Output == outputName {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+// Reset clears the recorded calls.
+func (c *FakeDaprClient) Reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.published = nil
+	c.bindings = nil
+	c.invocations = nil
+	c.calls = nil
+}
+
+// callsSince returns the calls after the first n calls.
+func (c *FakeDaprClient) callsSince(n int) []*Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if n > len(c.calls) {
+		return nil
+	}
+	return append([]*Call{}, c.calls[n:]...)
+}
+
+func (c *FakeDaprClient) callCount() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.calls)
+}
+
+func (c *FakeDaprClient) PublishEvent(ctx context.Context, pubsubName, topicName string, data interface{}, opts ...dapr.PublishEventOption) error {
+	req := &pb.PublishEventRequest{PubsubName: pubsubName, Topic: topicName}
+	for _, opt := range opts {
+		opt(req)
+	}
+	payload, err := toBytes(data)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	call := &Call{
+		Output:        c.matchOutput(ofctx.OpenFuncTopic, pubsubName, topicName),
+		ComponentName: pubsubName,
+		Topic:         topicName,
+		Data:          payload,
+		ContentType:   req.DataContentType,
+		Metadata:      req.Metadata,
+	}
+	c.published = append(c.published, call)
+	c.calls = append(c.calls, call)
+	if resp := c.nextResponse(call.Output); resp != nil {
+		return resp.Err
+	}
+	return ctx.Err()
+}
+
+func (c *FakeDaprClient) PublishEventfromCustomContent(ctx context.Context, pubsubName, topicName string, data interface{}) error {
+	return c.PublishEvent(ctx, pubsubName, topicName, data)
+}
+
+func (c *FakeDaprClient) InvokeBinding(ctx context.Context, in *dapr.InvokeBindingRequest) (*dapr.BindingEvent, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	call := &Call{
+		Output:        c.matchOutput(ofctx.OpenFuncBinding, in.Name, ""),
+		ComponentName: in.Name,
+		Operation:     in.Operation,
+		Data:          in.Data,
+		ContentType:   in.Metadata["contentType"],
+		Metadata:      in.Metadata,
+	}
+	c.bindings = append(c.bindings, call)
+	c.calls = append(c.calls, call)
+	if resp := c.nextResponse(call.Output); resp != nil {
+		if resp.Err != nil {
+			return nil, resp.Err
+		}
+		return &dapr.BindingEvent{Data: resp.Data, Metadata: resp.Metadata}, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &dapr.BindingEvent{}, nil
+}
+
+func (c *FakeDaprClient) InvokeOutputBinding(ctx context.Context, in *dapr.InvokeBindingRequest) error {
+	_, err := c.InvokeBinding(ctx, in)
+	return err
+}
+
+// Close stops the Dapr API served to the gRPC client.
+func (c *FakeDaprClient) Close() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.grpcConn != nil {
+		c.grpcConn.Close()
+		c.grpcServer.Stop()
+		c.grpcServer, c.grpcConn, c.grpcClient = nil, nil, nil
+	}
+}
+
+// matchOutput returns the name of the output of the component, the topic is matched for the publishes,
+// and the app ID is matched as the component name for the service invocations.
+func (c *FakeDaprClient) matchOutput(t ofctx.ResourceType, componentName string, topic string) string {
+	for name, output := range c.outputs {
+		if output.GetType() != t {
+			continue
+		}
+		if t == ofctx.OpenFuncInvoke && output.AppID != componentName {
+			continue
+		}
+		if t != ofctx.OpenFuncInvoke && output.ComponentName != componentName {
+			continue
+		}
+		if t == ofctx.OpenFuncTopic && output.Uri != topic {
+			continue
+		}
+		return name
+	}
+	return ""
+}
+
+func (c *FakeDaprClient) nextResponse(outputName string) *Response {
+	responses := c.responses[outputName]
+	if len(responses) == 0 {
+		return nil
+	}
+	if len(responses) > 1 {
+		c.responses[outputName] = responses[1:]
+	}
+	return responses[0]
+}
+
+// toBytes converts the data of a publish as the Dapr client does.
+func toBytes(data interface{}) ([]byte, error) {
+	switch d := data.(type) {
+	case []byte:
+		return d, nil
+	case string:
+		return []byte(d), nil
+	default:
+		return json.Marshal(d)
+	}
+}
+
+// The methods of dapr.Client which are not faked.
+
+func (c *FakeDaprClient) QueryStateAlpha1(ctx context.Context, storeName, query string, meta map[string]string) (*dapr.QueryResponse, error) {
+	return nil, errNotImplemented
+}
+
+func (c *FakeDaprClient) GetConfigurationItem(ctx context.Context, storeName, key string, opts ...dapr.ConfigurationOpt) (*dapr.ConfigurationItem, error) {
+	return nil, errNotImplemented
+}
+
+func (c *FakeDaprClient) GetConfigurationItems(ctx context.Context, storeName string, keys []string, opts ...dapr.ConfigurationOpt) ([]*dapr.ConfigurationItem, error) {
+	return nil, errNotImplemented
+}
+
+func (c *FakeDaprClient) SubscribeConfigurationItems(ctx context.Context, storeName string, keys []string, handler dapr.ConfigurationHandleFunction, opts ...dapr.ConfigurationOpt) error {
+	return errNotImplemented
+}
+
+func (c *FakeDaprClient) UnsubscribeConfigurationItems(ctx context.Context, storeName string, id string, opts ...dapr.ConfigurationOpt) error {
+	return errNotImplemented
+}
+
+func (c *FakeDaprClient) TryLockAlpha1(ctx context.Context, storeName string, request *dapr.LockRequest) (*dapr.LockResponse, error) {
+	return nil, errNotImplemented
+}
+
+func (c *FakeDaprClient) UnlockAlpha1(ctx context.Context, storeName string, request *dapr.UnlockRequest) (*dapr.UnlockResponse, error) {
+	return nil, errNotImplemented
+}
+
+func (c *FakeDaprClient) Shutdown(ctx context.Context) error {
+	return errNotImplemented
+}
+
+func (c *FakeDaprClient) WithTraceID(ctx context.Context, id string) context.Context {
+	return ctx
+}
+
+func (c *FakeDaprClient) WithAuthToken(token string) {}
+
+func (c *FakeDaprClient) RegisterActorTimer(ctx context.Context, req *dapr.RegisterActorTimerRequest) error {
+	return errNotImplemented
+}
+
+func (c *FakeDaprClient) UnregisterActorTimer(ctx context.Context, req *dapr.UnregisterActorTimerRequest) error {
+	return errNotImplemented
+}
+
+func (c *FakeDaprClient) RegisterActorReminder(ctx context.Context, req *dapr.RegisterActorReminderRequest) error {
+	return errNotImplemented
+}
+
+func (c *FakeDaprClient) UnregisterActorReminder(ctx context.Context, req *dapr.UnregisterActorReminderRequest) error {
+	return errNotImplemented
+}
+
+func (c *FakeDaprClient) RenameActorReminder(ctx context.Context, req *dapr.RenameActorReminderRequest) error {
+	return errNotImplemented
+}
+
+func (c *FakeDaprClient) InvokeActor(ctx context.Context, req *dapr.InvokeActorRequest) (*dapr.InvokeActorResponse, error) {
+	return nil, errNotImplemented
+}
+
+func (c *FakeDaprClient) GetActorState(ctx context.Context, req *dapr.GetActorStateRequest) (*dapr.GetActorStateResponse, error) {
+	return nil, errNotImplemented
+}
+
+func (c *FakeDaprClient) SaveStateTransactionally(ctx context.Context, actorType, actorID string, operations []*dapr.ActorStateOperation) error {
+	return errNotImplemented
+}
+
+func (c *FakeDaprClient) ImplActorClientStub(actorClientStub actor.Client, opt ...config.Option) {}
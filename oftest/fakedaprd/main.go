@@ -0,0 +1,46 @@
+// fakedaprd runs a FakeSidecar for running the functions on a laptop without Dapr installed:
+//
+//	go run github.com/OpenFunction/functions-framework-go/oftest/fakedaprd -app-id my-function -app-address localhost:8080
+//
+// and the function connects to it with DAPR_GRPC_PORT=50001.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"k8s.io/klog/v2"
+
+	"github.com/OpenFunction/functions-framework-go/oftest"
+)
+
+func main() {
+	port := os.Getenv("DAPR_GRPC_PORT")
+	if port == "" {
+		port = "50001"
+	}
+	appID := flag.String("app-id", "function", "the app ID of the function, which is invoked by InvokeService")
+	appAddress := flag.String("app-address", "localhost:8080", "the address of the AppCallback server of the function, empty to disable the delivery of the events")
+	daprPort := flag.String("dapr-grpc-port", port, "the port of the Dapr API")
+	bindingLoopback := flag.Bool("binding-loopback", false, "deliver the binding invocations to the function if it listens on the binding")
+	klog.InitFlags(nil)
+	flag.Parse()
+
+	var opts []oftest.SidecarOption
+	if *bindingLoopback {
+		opts = append(opts, oftest.WithBindingLoopback())
+	}
+	sidecar := oftest.NewFakeSidecar(*appID, *appAddress, opts...)
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+		<-signals
+		sidecar.Stop()
+	}()
+	if err := sidecar.Start(fmt.Sprintf(":%s", *daprPort)); err != nil {
+		klog.Exit(err)
+	}
+}
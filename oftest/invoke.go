@@ -0,0 +1,155 @@
+package oftest
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+
+	commonv1pb "github.com/dapr/dapr/pkg/proto/common/v1"
+	pb "github.com/dapr/dapr/pkg/proto/runtime/v1"
+	dapr "github.com/dapr/go-sdk/client"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	ofctx "github.com/OpenFunction/functions-framework-go/context"
+)
+
+const (
+	// the error info of a failed app, as Dapr returns it
+	errorInfoReason    = "ERR_INVOKE_APP"
+	errorInfoHTTPCode  = "http.code"
+	errorInfoHTTPError = "http.error_message"
+
+	bufconnSize = 1024 * 1024
+)
+
+// fakeDaprServer serves the service invocations of the gRPC client of FakeDaprClient,
+// the other methods of the Dapr API respond codes.Unimplemented.
+type fakeDaprServer struct {
+	pb.UnimplementedDaprServer
+	client *FakeDaprClient
+}
+
+func (s *fakeDaprServer) InvokeService(ctx context.Context, in *pb.InvokeServiceRequest) (*commonv1pb.InvokeResponse, error) {
+	resp, err := s.client.invoke(ctx, in.GetId(), in.GetMessage().GetMethod(), in.GetMessage().GetData().GetValue(), in.GetMessage().GetContentType())
+	if err != nil {
+		return nil, err
+	}
+
+	code := resp.StatusCode
+	if code == 0 {
+		code = http.StatusOK
+	}
+	if code >= http.StatusBadRequest {
+		st, err := status.New(codes.Unknown, http.StatusText(code)).WithDetails(&errdetails.ErrorInfo{
+			Reason: errorInfoReason,
+			Metadata: map[string]string{
+				errorInfoHTTPCode:  strconv.Itoa(code),
+				errorInfoHTTPError: string(resp.Data),
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		return nil, st.Err()
+	}
+	if err := grpc.SetHeader(ctx, metadata.Pairs(daprHTTPStatusHeader, strconv.Itoa(code))); err != nil {
+		return nil, err
+	}
+	return &commonv1pb.InvokeResponse{
+		Data:        &anypb.Any{Value: resp.Data},
+		ContentType: resp.ContentType,
+	}, nil
+}
+
+// GrpcClient returns a client of the Dapr API served in memory, it implements InvokeService with the
+// responses scripted for the service invocation outputs, the other methods fail with codes.Unimplemented.
+func (c *FakeDaprClient) GrpcClient() pb.DaprClient {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.grpcClient != nil {
+		return c.grpcClient
+	}
+
+	lis := bufconn.Listen(bufconnSize)
+	server := grpc.NewServer()
+	pb.RegisterDaprServer(server, &fakeDaprServer{client: c})
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	conn, err := grpc.Dial("bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) {
+			return lis.DialContext(ctx)
+		}),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		server.Stop()
+		return nil
+	}
+	c.grpcServer, c.grpcConn = server, conn
+	c.grpcClient = pb.NewDaprClient(conn)
+	return c.grpcClient
+}
+
+// invoke records the invocation of the method of the app, and returns the scripted response.
+func (c *FakeDaprClient) invoke(ctx context.Context, appID, method string, data []byte, contentType string) (*Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	call := &Call{
+		Output:        c.matchOutput(ofctx.OpenFuncInvoke, appID, ""),
+		ComponentName: appID,
+		Method:        method,
+		Data:          data,
+		ContentType:   contentType,
+	}
+	c.invocations = append(c.invocations, call)
+	c.calls = append(c.calls, call)
+	if resp := c.nextResponse(call.Output); resp != nil {
+		if resp.Err != nil {
+			return nil, resp.Err
+		}
+		return resp, nil
+	}
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return &Response{}, nil
+}
+
+// Invocations returns the recorded service invocations.
+func (c *FakeDaprClient) Invocations() []*Call {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*Call{}, c.invocations...)
+}
+
+func (c *FakeDaprClient) InvokeMethod(ctx context.Context, appID, methodName, verb string) ([]byte, error) {
+	return c.InvokeMethodWithContent(ctx, appID, methodName, verb, &dapr.DataContent{})
+}
+
+func (c *FakeDaprClient) InvokeMethodWithContent(ctx context.Context, appID, methodName, verb string, content *dapr.DataContent) ([]byte, error) {
+	resp, err := c.invoke(ctx, appID, methodName, content.Data, content.ContentType)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		return nil, status.Errorf(codes.Unknown, "app %s responded %d: %s", appID, resp.StatusCode, resp.Data)
+	}
+	return resp.Data, nil
+}
+
+func (c *FakeDaprClient) InvokeMethodWithCustomContent(ctx context.Context, appID, methodName, verb string, contentType string, content interface{}) ([]byte, error) {
+	data, err := toBytes(content)
+	if err != nil {
+		return nil, err
+	}
+	return c.InvokeMethodWithContent(ctx, appID, methodName, verb, &dapr.DataContent{Data: data, ContentType: contentType})
+}
@@ -0,0 +1,438 @@
+package oftest
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+
+	commonv1pb "github.com/dapr/dapr/pkg/proto/common/v1"
+	pb "github.com/dapr/dapr/pkg/proto/runtime/v1"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// the attributes of the CloudEvent envelopes of the published events, as Dapr sets them
+	topicEventType        = "com.dapr.event.sent"
+	topicEventSpecVersion = "1.0"
+
+	daprHTTPStatusHeader = "dapr-http-status"
+
+	stateOperationUpsert = "upsert"
+	stateOperationDelete = "delete"
+)
+
+// FakeSidecar is an in-memory Dapr API server, the functions connect to it through DAPR_GRPC_PORT
+// without Dapr installed. It implements PublishEvent, InvokeBinding, the state operations and InvokeService.
+// The published events are delivered to the app through the AppCallback protocol if the app subscribes
+// to the topic, and the app is invoked if the app ID matches. The binding invocations are only recorded,
+// unless WithBindingLoopback delivers them to the app listening on the binding.
+type FakeSidecar struct {
+	pb.UnimplementedDaprServer
+
+	appID           string
+	appAddress      string
+	bindingLoopback bool
+
+	mu        sync.Mutex
+	states    map[string]map[string]*sidecarState
+	published []*pb.PublishEventRequest
+	bindings  []*pb.InvokeBindingRequest
+	conn      *grpc.ClientConn
+	server    *grpc.Server
+	// delivering counts the events in delivery, delivered is signaled when it drops to zero
+	delivering int
+	delivered  *sync.Cond
+}
+
+// SidecarOption configures a FakeSidecar.
+type SidecarOption func(*FakeSidecar)
+
+// WithBindingLoopback delivers the binding invocations to the app if it listens on the binding,
+// as if the output binding and the input binding were the same queue.
+func WithBindingLoopback() SidecarOption {
+	return func(s *FakeSidecar) {
+		s.bindingLoopback = true
+	}
+}
+
+type sidecarState struct {
+	data     []byte
+	metadata map[string]string
+	version  int
+}
+
+func (s *sidecarState) etag() string {
+	return strconv.Itoa(s.version)
+}
+
+// NewFakeSidecar creates the sidecar of the app, appAddress is the address of the AppCallback server of the app,
+// e.g. localhost:8080. The events are not delivered if appAddress is empty.
+func NewFakeSidecar(appID string, appAddress string, opts ...SidecarOption) *FakeSidecar {
+	s := &FakeSidecar{
+		appID:      appID,
+		appAddress: appAddress,
+		states:     map[string]map[string]*sidecarState{},
+	}
+	s.delivered = sync.NewCond(&s.mu)
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// Start serves the Dapr API on address, e.g. :50001, and blocks until the sidecar is stopped.
+func (s *FakeSidecar) Start(address string) error {
+	lis, err := net.Listen("tcp", address)
+	if err != nil {
+		return err
+	}
+	return s.Serve(lis)
+}
+
+// Serve serves the Dapr API on lis.
+func (s *FakeSidecar) Serve(lis net.Listener) error {
+	gs := grpc.NewServer()
+	pb.RegisterDaprServer(gs, s)
+	s.mu.Lock()
+	s.server = gs
+	s.mu.Unlock()
+	klog.Infof("Fake Dapr sidecar of app %s serving gRPC: listening on %s", s.appID, lis.Addr())
+	return gs.Serve(lis)
+}
+
+// Stop stops accepting the calls of the app, and stops the sidecar after the events in delivery are delivered.
+func (s *FakeSidecar) Stop() {
+	s.mu.Lock()
+	server := s.server
+	s.mu.Unlock()
+	// no event is delivered once the calls in progress are finished
+	if server != nil {
+		server.GracefulStop()
+	}
+	s.Wait()
+
+	s.mu.Lock()
+	conn := s.conn
+	s.mu.Unlock()
+	if conn != nil {
+		conn.Close()
+	}
+}
+
+// Published returns the published events.
+func (s *FakeSidecar) Published() []*pb.PublishEventRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*pb.PublishEventRequest{}, s.published...)
+}
+
+// InvokedBindings returns the binding invocations.
+func (s *FakeSidecar) InvokedBindings() []*pb.InvokeBindingRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*pb.InvokeBindingRequest{}, s.bindings...)
+}
+
+// Wait waits for the events in delivery to be delivered, including the events published while waiting.
+func (s *FakeSidecar) Wait() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.delivering > 0 {
+		s.delivered.Wait()
+	}
+}
+
+// app returns the AppCallback client of the app, the connection is made on the first call
+// since the app usually starts after its sidecar.
+func (s *FakeSidecar) app() (pb.AppCallbackClient, error) {
+	if s.appAddress == "" {
+		return nil, nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.conn == nil {
+		conn, err := grpc.Dial(s.appAddress, grpc.WithTransportCredentials(insecure.NewCredentials()))
+		if err != nil {
+			return nil, err
+		}
+		s.conn = conn
+	}
+	return pb.NewAppCallbackClient(s.conn), nil
+}
+
+func (s *FakeSidecar) PublishEvent(ctx context.Context, in *pb.PublishEventRequest) (*emptypb.Empty, error) {
+	if in.PubsubName == "" || in.Topic == "" {
+		return nil, status.Error(codes.InvalidArgument, "pubsub name and topic are required")
+	}
+	s.mu.Lock()
+	s.published = append(s.published, in)
+	s.mu.Unlock()
+
+	event := &pb.TopicEventRequest{
+		Id:              uuid.New().String(),
+		Source:          s.appID,
+		Type:            topicEventType,
+		SpecVersion:     topicEventSpecVersion,
+		DataContentType: in.DataContentType,
+		Data:            in.Data,
+		Topic:           in.Topic,
+		PubsubName:      in.PubsubName,
+	}
+	// the event is delivered asynchronously as a message broker does
+	s.deliver(func(c context.Context, app pb.AppCallbackClient) {
+		subs, err := app.ListTopicSubscriptions(c, &emptypb.Empty{})
+		if err != nil {
+			klog.Errorf("failed to list the subscriptions of app %s: %v", s.appID, err)
+			return
+		}
+		for _, sub := range subs.GetSubscriptions() {
+			if sub.PubsubName != in.PubsubName || sub.Topic != in.Topic {
+				continue
+			}
+			out, err := app.OnTopicEvent(c, event)
+			if err != nil {
+				klog.Errorf("failed to deliver the event of topic %s to app %s: %v", in.Topic, s.appID, err)
+			} else if out.GetStatus() != pb.TopicEventResponse_SUCCESS {
+				klog.Warningf("app %s responded the event of topic %s with %s", s.appID, in.Topic, out.GetStatus())
+			}
+		}
+	})
+	return &emptypb.Empty{}, nil
+}
+
+func (s *FakeSidecar) InvokeBinding(ctx context.Context, in *pb.InvokeBindingRequest) (*pb.InvokeBindingResponse, error) {
+	if in.Name == "" {
+		return nil, status.Error(codes.InvalidArgument, "binding name is required")
+	}
+	s.mu.Lock()
+	s.bindings = append(s.bindings, in)
+	s.mu.Unlock()
+	if !s.bindingLoopback {
+		return &pb.InvokeBindingResponse{}, nil
+	}
+
+	// the binding loops back to the app listening on it
+	s.deliver(func(c context.Context, app pb.AppCallbackClient) {
+		bindings, err := app.ListInputBindings(c, &emptypb.Empty{})
+		if err != nil {
+			klog.Errorf("failed to list the input bindings of app %s: %v", s.appID, err)
+			return
+		}
+		for _, name := range bindings.GetBindings() {
+			if name != in.Name {
+				continue
+			}
+			if _, err := app.OnBindingEvent(c, &pb.BindingEventRequest{Name: in.Name, Data: in.Data, Metadata: in.Metadata}); err != nil {
+				klog.Errorf("failed to deliver the event of binding %s to app %s: %v", in.Name, s.appID, err)
+			}
+		}
+	})
+	return &pb.InvokeBindingResponse{}, nil
+}
+
+// deliver calls the app in the background.
+func (s *FakeSidecar) deliver(fn func(c context.Context, app pb.AppCallbackClient)) {
+	app, err := s.app()
+	if err != nil {
+		klog.Errorf("failed to connect to app %s: %v", s.appID, err)
+		return
+	}
+	if app == nil {
+		return
+	}
+	s.mu.Lock()
+	s.delivering++
+	s.mu.Unlock()
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			defer s.mu.Unlock()
+			s.delivering--
+			if s.delivering == 0 {
+				s.delivered.Broadcast()
+			}
+		}()
+		fn(context.Background(), app)
+	}()
+}
+
+func (s *FakeSidecar) InvokeService(ctx context.Context, in *pb.InvokeServiceRequest) (*commonv1pb.InvokeResponse, error) {
+	if in.Id != s.appID {
+		return nil, status.Errorf(codes.Unavailable, "app %s is not found", in.Id)
+	}
+	app, err := s.app()
+	if err != nil {
+		return nil, status.Errorf(codes.Unavailable, "failed to connect to app %s: %v", in.Id, err)
+	}
+	if app == nil {
+		return nil, status.Errorf(codes.Unavailable, "the address of app %s is unknown", in.Id)
+	}
+
+	// the headers of the caller are forwarded to the app
+	header := metadata.MD{}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		for k, v := range md {
+			if !strings.HasPrefix(k, ":") && !strings.HasPrefix(k, "grpc-") && k != "content-type" && k != "user-agent" {
+				header[k] = v
+			}
+		}
+	}
+	out, err := app.OnInvoke(metadata.NewOutgoingContext(ctx, header), in.GetMessage())
+	if err != nil {
+		return nil, err
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(daprHTTPStatusHeader, "200"))
+	return out, nil
+}
+
+// getStore returns the states of the store, it is created on the first use.
+func (s *FakeSidecar) getStore(storeName string) (map[string]*sidecarState, error) {
+	if storeName == "" {
+		return nil, status.Error(codes.InvalidArgument, "state store name is required")
+	}
+	store, ok := s.states[storeName]
+	if !ok {
+		store = map[string]*sidecarState{}
+		s.states[storeName] = store
+	}
+	return store, nil
+}
+
+// checkETag fails with codes.Aborted as Dapr does if the ETag does not match.
+func checkETag(state *sidecarState, etag *commonv1pb.Etag) error {
+	if etag == nil || etag.Value == "" {
+		return nil
+	}
+	if state == nil || state.etag() != etag.Value {
+		return status.Errorf(codes.Aborted, "possible etag mismatch. error from state store: etag %s does not match", etag.Value)
+	}
+	return nil
+}
+
+func (s *FakeSidecar) GetState(ctx context.Context, in *pb.GetStateRequest) (*pb.GetStateResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	store, err := s.getStore(in.StoreName)
+	if err != nil {
+		return nil, err
+	}
+	state, ok := store[in.Key]
+	if !ok {
+		return &pb.GetStateResponse{}, nil
+	}
+	return &pb.GetStateResponse{Data: state.data, Etag: state.etag(), Metadata: state.metadata}, nil
+}
+
+func (s *FakeSidecar) GetBulkState(ctx context.Context, in *pb.GetBulkStateRequest) (*pb.GetBulkStateResponse, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	store, err := s.getStore(in.StoreName)
+	if err != nil {
+		return nil, err
+	}
+	resp := &pb.GetBulkStateResponse{}
+	for _, key := range in.Keys {
+		item := &pb.BulkStateItem{Key: key}
+		if state, ok := store[key]; ok {
+			item.Data, item.Etag, item.Metadata = state.data, state.etag(), state.metadata
+		}
+		resp.Items = append(resp.Items, item)
+	}
+	return resp, nil
+}
+
+func (s *FakeSidecar) SaveState(ctx context.Context, in *pb.SaveStateRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	store, err := s.getStore(in.StoreName)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range in.States {
+		if err := checkETag(store[item.Key], item.Etag); err != nil {
+			return nil, err
+		}
+	}
+	for _, item := range in.States {
+		saveState(store, item)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *FakeSidecar) DeleteState(ctx context.Context, in *pb.DeleteStateRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	store, err := s.getStore(in.StoreName)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkETag(store[in.Key], in.Etag); err != nil {
+		return nil, err
+	}
+	delete(store, in.Key)
+	return &emptypb.Empty{}, nil
+}
+
+func (s *FakeSidecar) DeleteBulkState(ctx context.Context, in *pb.DeleteBulkStateRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	store, err := s.getStore(in.StoreName)
+	if err != nil {
+		return nil, err
+	}
+	for _, item := range in.States {
+		if err := checkETag(store[item.Key], item.Etag); err != nil {
+			return nil, err
+		}
+	}
+	for _, item := range in.States {
+		delete(store, item.Key)
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func (s *FakeSidecar) ExecuteStateTransaction(ctx context.Context, in *pb.ExecuteStateTransactionRequest) (*emptypb.Empty, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	store, err := s.getStore(in.StoreName)
+	if err != nil {
+		return nil, err
+	}
+	// the transaction is applied only if all the operations are valid
+	for _, op := range in.Operations {
+		if op.OperationType != stateOperationUpsert && op.OperationType != stateOperationDelete {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid operation type: %s", op.OperationType)
+		}
+		if err := checkETag(store[op.GetRequest().GetKey()], op.GetRequest().GetEtag()); err != nil {
+			return nil, err
+		}
+	}
+	for _, op := range in.Operations {
+		if op.OperationType == stateOperationUpsert {
+			saveState(store, op.Request)
+		} else {
+			delete(store, op.Request.GetKey())
+		}
+	}
+	return &emptypb.Empty{}, nil
+}
+
+func saveState(store map[string]*sidecarState, item *commonv1pb.StateItem) {
+	state, ok := store[item.Key]
+	if !ok {
+		state = &sidecarState{}
+		store[item.Key] = state
+	}
+	state.data = item.Value
+	state.metadata = item.Metadata
+	state.version++
+}
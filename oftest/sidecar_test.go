@@ -0,0 +1,138 @@
+package oftest
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	dapr "github.com/dapr/go-sdk/client"
+	"github.com/dapr/go-sdk/service/common"
+	grpcsvc "github.com/dapr/go-sdk/service/grpc"
+	"github.com/stretchr/testify/assert"
+
+	ofctx "github.com/OpenFunction/functions-framework-go/context"
+)
+
+func TestFakeSidecar(t *testing.T) {
+	appLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	events := make(chan []byte, 2)
+	app := grpcsvc.NewServiceWithListener(appLis)
+	_ = app.AddTopicEventHandler(&common.Subscription{PubsubName: "msg", Topic: "orders"}, func(c context.Context, e *common.TopicEvent) (bool, error) {
+		events <- e.RawData
+		return false, nil
+	})
+	_ = app.AddBindingInvocationHandler("kafka", func(c context.Context, in *common.BindingEvent) ([]byte, error) {
+		events <- in.Data
+		return nil, nil
+	})
+	_ = app.AddServiceInvocationHandler("echo", func(c context.Context, in *common.InvocationEvent) (*common.Content, error) {
+		return &common.Content{Data: append([]byte("echo: "), in.Data...), ContentType: "text/plain"}, nil
+	})
+	go app.Start()
+	defer app.Stop()
+
+	sidecarLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	sidecar := NewFakeSidecar("app", appLis.Addr().String(), WithBindingLoopback())
+	go sidecar.Serve(sidecarLis)
+	defer sidecar.Stop()
+
+	client, err := dapr.NewClientWithAddress(sidecarLis.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to sidecar: %v", err)
+	}
+	defer client.Close()
+
+	ctx, _, err := NewContextBuilder().
+		WithOutput("orders", &ofctx.Output{ComponentName: "msg", ComponentType: "pubsub.kafka", Uri: "orders"}).
+		WithOutput("kafka", &ofctx.Output{ComponentName: "kafka", ComponentType: "bindings.kafka", Operation: "create"}).
+		WithOutput("echo", &ofctx.Output{AppID: "app", Method: "echo", ComponentType: "invoke"}).
+		WithStateStore("cache", &ofctx.StateStore{ComponentName: "redis", ComponentType: "state.redis"}).
+		WithDaprClient(client).
+		Build()
+	if err != nil {
+		t.Fatalf("failed to build context: %v", err)
+	}
+
+	// the published events and the binding invocations loop back to the app
+	for _, output := range []string{"orders", "kafka"} {
+		if _, err := ctx.Send(output, []byte(output)); err != nil {
+			t.Fatalf("failed to send to %s: %v", output, err)
+		}
+		select {
+		case data := <-events:
+			assert.Equal(t, []byte(output), data)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("event of %s is not delivered", output)
+		}
+	}
+	assert.Len(t, sidecar.Published(), 1)
+	assert.Len(t, sidecar.InvokedBindings(), 1)
+
+	resp, err := ctx.Invoke("echo", []byte("hello"))
+	if assert.NoError(t, err) {
+		assert.Equal(t, []byte("echo: hello"), resp.Data)
+		assert.Equal(t, "text/plain", resp.ContentType)
+		assert.Equal(t, 200, resp.StatusCode)
+	}
+
+	// the states are kept in memory with ETags
+	assert.NoError(t, ctx.SaveState("cache", "order", []byte("apple")))
+	item, err := ctx.GetState("cache", "order")
+	if assert.NoError(t, err) {
+		assert.Equal(t, []byte("apple"), item.Value)
+	}
+	assert.NoError(t, ctx.SaveState("cache", "order", []byte("banana"), ofctx.StateWithETag(item.Etag)))
+	err = ctx.SaveState("cache", "order", []byte("cherry"), ofctx.StateWithETag(item.Etag))
+	assert.True(t, ofctx.IsETagMismatch(err))
+	assert.NoError(t, ctx.DeleteState("cache", "order"))
+	item, err = ctx.GetState("cache", "order")
+	if assert.NoError(t, err) {
+		assert.Nil(t, item.Value)
+	}
+}
+
+func TestFakeSidecarBindings(t *testing.T) {
+	appLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	events := make(chan []byte, 1)
+	app := grpcsvc.NewServiceWithListener(appLis)
+	_ = app.AddBindingInvocationHandler("kafka", func(c context.Context, in *common.BindingEvent) ([]byte, error) {
+		events <- in.Data
+		return nil, nil
+	})
+	go app.Start()
+	defer app.Stop()
+
+	sidecarLis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	sidecar := NewFakeSidecar("app", appLis.Addr().String())
+	go sidecar.Serve(sidecarLis)
+	defer sidecar.Stop()
+
+	client, err := dapr.NewClientWithAddress(sidecarLis.Addr().String())
+	if err != nil {
+		t.Fatalf("failed to connect to sidecar: %v", err)
+	}
+	defer client.Close()
+
+	// the binding invocations are recorded without looping back to the app by default
+	assert.NoError(t, client.InvokeOutputBinding(context.Background(), &dapr.InvokeBindingRequest{Name: "kafka", Operation: "create", Data: []byte("hello")}))
+	sidecar.Wait()
+	assert.Len(t, sidecar.InvokedBindings(), 1)
+	select {
+	case <-events:
+		t.Fatal("the binding invocation is delivered to the app")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
@@ -0,0 +1,198 @@
+package oftest
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	dapr "github.com/dapr/go-sdk/client"
+)
+
+// stateEntry is a value in the in-memory state stores of FakeDaprClient, its etag is increased on every save.
+type stateEntry struct {
+	value    []byte
+	etag     int
+	metadata map[string]string
+}
+
+// SetState saves the value of the key in the state store, the store is the component name of the state store.
+func (c *FakeDaprClient) SetState(storeName, key string, value []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.saveState(storeName, key, value, nil)
+}
+
+// State returns the value of the key in the state store, and whether the key exists.
+func (c *FakeDaprClient) State(storeName, key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.states[storeName][key]
+	if !ok {
+		return nil, false
+	}
+	return entry.value, true
+}
+
+// SetSecret sets the values of the secret in the secret store, the store is the component name of the secret store.
+func (c *FakeDaprClient) SetSecret(storeName, key string, values map[string]string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.secrets[storeName] == nil {
+		c.secrets[storeName] = map[string]map[string]string{}
+	}
+	c.secrets[storeName][key] = values
+}
+
+func (c *FakeDaprClient) saveState(storeName, key string, value []byte, metadata map[string]string) {
+	if c.states[storeName] == nil {
+		c.states[storeName] = map[string]*stateEntry{}
+	}
+	etag := 1
+	if entry, ok := c.states[storeName][key]; ok {
+		etag = entry.etag + 1
+	}
+	c.states[storeName][key] = &stateEntry{value: value, etag: etag, metadata: metadata}
+}
+
+// checkETag fails the write of the key if the etag does not match its current etag, as a store
+// with optimistic concurrency does.
+func (c *FakeDaprClient) checkETag(storeName, key string, etag *dapr.ETag) error {
+	if etag == nil || etag.Value == "" {
+		return nil
+	}
+	entry, ok := c.states[storeName][key]
+	if !ok || strconv.Itoa(entry.etag) != etag.Value {
+		return fmt.Errorf("possible etag mismatch of key %s in state store %s", key, storeName)
+	}
+	return nil
+}
+
+func (c *FakeDaprClient) getState(storeName, key string) *dapr.StateItem {
+	item := &dapr.StateItem{Key: key}
+	if entry, ok := c.states[storeName][key]; ok {
+		item.Value = entry.value
+		item.Etag = strconv.Itoa(entry.etag)
+		item.Metadata = entry.metadata
+	}
+	return item
+}
+
+func (c *FakeDaprClient) GetState(ctx context.Context, storeName, key string, meta map[string]string) (*dapr.StateItem, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.getState(storeName, key), ctx.Err()
+}
+
+func (c *FakeDaprClient) GetStateWithConsistency(ctx context.Context, storeName, key string, meta map[string]string, sc dapr.StateConsistency) (*dapr.StateItem, error) {
+	return c.GetState(ctx, storeName, key, meta)
+}
+
+func (c *FakeDaprClient) GetBulkState(ctx context.Context, storeName string, keys []string, meta map[string]string, parallelism int32) ([]*dapr.BulkStateItem, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	items := make([]*dapr.BulkStateItem, 0, len(keys))
+	for _, key := range keys {
+		item := c.getState(storeName, key)
+		items = append(items, &dapr.BulkStateItem{Key: item.Key, Value: item.Value, Etag: item.Etag, Metadata: item.Metadata})
+	}
+	return items, ctx.Err()
+}
+
+func (c *FakeDaprClient) SaveState(ctx context.Context, storeName, key string, data []byte, meta map[string]string, so ...dapr.StateOption) error {
+	return c.SaveBulkState(ctx, storeName, &dapr.SetStateItem{Key: key, Value: data, Metadata: meta})
+}
+
+func (c *FakeDaprClient) SaveBulkState(ctx context.Context, storeName string, items ...*dapr.SetStateItem) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, item := range items {
+		if err := c.checkETag(storeName, item.Key, item.Etag); err != nil {
+			return err
+		}
+		c.saveState(storeName, item.Key, item.Value, item.Metadata)
+	}
+	return nil
+}
+
+func (c *FakeDaprClient) DeleteState(ctx context.Context, storeName, key string, meta map[string]string) error {
+	return c.DeleteStateWithETag(ctx, storeName, key, nil, meta, nil)
+}
+
+func (c *FakeDaprClient) DeleteStateWithETag(ctx context.Context, storeName, key string, etag *dapr.ETag, meta map[string]string, opts *dapr.StateOptions) error {
+	return c.DeleteBulkStateItems(ctx, storeName, []*dapr.DeleteStateItem{{Key: key, Etag: etag, Metadata: meta}})
+}
+
+func (c *FakeDaprClient) DeleteBulkState(ctx context.Context, storeName string, keys []string, meta map[string]string) error {
+	items := make([]*dapr.DeleteStateItem, 0, len(keys))
+	for _, key := range keys {
+		items = append(items, &dapr.DeleteStateItem{Key: key, Metadata: meta})
+	}
+	return c.DeleteBulkStateItems(ctx, storeName, items)
+}
+
+func (c *FakeDaprClient) DeleteBulkStateItems(ctx context.Context, storeName string, items []*dapr.DeleteStateItem) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, item := range items {
+		if err := c.checkETag(storeName, item.Key, item.Etag); err != nil {
+			return err
+		}
+		delete(c.states[storeName], item.Key)
+	}
+	return nil
+}
+
+// ExecuteStateTransaction applies all the operations or none of them if an etag does not match.
+func (c *FakeDaprClient) ExecuteStateTransaction(ctx context.Context, storeName string, meta map[string]string, ops []*dapr.StateOperation) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, op := range ops {
+		if op.Item == nil {
+			return fmt.Errorf("state operation without item in state store %s", storeName)
+		}
+		if op.Type != dapr.StateOperationTypeUpsert && op.Type != dapr.StateOperationTypeDelete {
+			return fmt.Errorf("unsupported state operation %v", op.Type)
+		}
+		if err := c.checkETag(storeName, op.Item.Key, op.Item.Etag); err != nil {
+			return err
+		}
+	}
+	for _, op := range ops {
+		switch op.Type {
+		case dapr.StateOperationTypeUpsert:
+			c.saveState(storeName, op.Item.Key, op.Item.Value, op.Item.Metadata)
+		case dapr.StateOperationTypeDelete:
+			delete(c.states[storeName], op.Item.Key)
+		}
+	}
+	return nil
+}
+
+func (c *FakeDaprClient) GetSecret(ctx context.Context, storeName, key string, meta map[string]string) (map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	values, ok := c.secrets[storeName][key]
+	if !ok {
+		return nil, fmt.Errorf("secret %s not found in secret store %s", key, storeName)
+	}
+	return values, ctx.Err()
+}
+
+func (c *FakeDaprClient) GetBulkSecret(ctx context.Context, storeName string, meta map[string]string) (map[string]map[string]string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	secrets := map[string]map[string]string{}
+	for key, values := range c.secrets[storeName] {
+		secrets[key] = values
+	}
+	return secrets, ctx.Err()
+}
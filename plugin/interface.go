@@ -1,6 +1,9 @@
 package plugin
 
 import (
+	"context"
+	"net/http"
+
 	ofctx "github.com/OpenFunction/functions-framework-go/context"
 )
 
@@ -16,3 +19,15 @@ type Plugin interface {
 	ExecPostHook(ctx ofctx.RuntimeContext, plugins map[string]Plugin) error
 	Get(fieldName string) (interface{}, bool)
 }
+
+// Shutdowner is implemented by the plugins that need to release resources,
+// e.g. flush the buffered telemetry, when the framework shuts down.
+type Shutdowner interface {
+	Shutdown(ctx context.Context) error
+}
+
+// Endpoint is implemented by the plugins that expose an http endpoint, e.g. the metrics,
+// the framework serves it apart from the user functions in both runtimes.
+type Endpoint interface {
+	Endpoint() (pattern string, handler http.Handler)
+}
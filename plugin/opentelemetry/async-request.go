@@ -0,0 +1,93 @@
+package opentelemetry
+
+import (
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/types"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+
+	ofctx "github.com/OpenFunction/functions-framework-go/context"
+)
+
+func preAsyncRequestCommonLogic(ofCtx ofctx.RuntimeContext, tracer trace.Tracer, carrier propagation.TextMapCarrier, attrs ...attribute.KeyValue) {
+	pCtx := otel.GetTextMapPropagator().Extract(ofCtx.GetNativeContext(), carrier)
+	nCtx, span := tracer.Start(pCtx, ofCtx.GetName(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(attrs...),
+	)
+	nCtx = setPublicAttrs(nCtx, ofCtx, span)
+	ofCtx.SetNativeContext(withSpan(nCtx, span))
+}
+
+func preTopicEventLogic(ofCtx ofctx.RuntimeContext, tracer trace.Tracer) error {
+	preAsyncRequestCommonLogic(ofCtx, tracer, propagation.MapCarrier(ofCtx.GetInnerEvent().GetMetadata()),
+		semconv.FaaSTriggerPubsub,
+		tagRuntime.String(string(ofctx.Async)),
+		tagComponentType.String(string(ofctx.OpenFuncTopic)),
+		tagInputName.String(ofCtx.GetContext().GetInputName()),
+	)
+	return nil
+}
+
+func preBindingEventLogic(ofCtx ofctx.RuntimeContext, tracer trace.Tracer) error {
+	preAsyncRequestCommonLogic(ofCtx, tracer, propagation.MapCarrier(ofCtx.GetInnerEvent().GetMetadata()),
+		semconv.FaaSTriggerOther,
+		tagRuntime.String(string(ofctx.Async)),
+		tagComponentType.String(string(ofctx.OpenFuncBinding)),
+		tagInputName.String(ofCtx.GetContext().GetInputName()),
+	)
+	return nil
+}
+
+func preInvocationEventLogic(ofCtx ofctx.RuntimeContext, tracer trace.Tracer) error {
+	preAsyncRequestCommonLogic(ofCtx, tracer, propagation.MapCarrier(ofCtx.GetInnerEvent().GetMetadata()),
+		semconv.FaaSTriggerHTTP,
+		tagRuntime.String(string(ofctx.Async)),
+		tagComponentType.String(string(ofctx.OpenFuncInvoke)),
+		tagInputName.String(ofCtx.GetContext().GetInputName()),
+	)
+	return nil
+}
+
+// preCloudEventLogic reads the trace context from the distributed tracing extension of the cloudevent.
+func preCloudEventLogic(ofCtx ofctx.RuntimeContext, tracer trace.Tracer) error {
+	preAsyncRequestCommonLogic(ofCtx, tracer, cloudEventCarrier{ofCtx.GetCloudEvent()},
+		semconv.FaaSTriggerPubsub,
+		tagRuntime.String(string(ofCtx.GetRuntime())),
+		semconv.MessagingMessageIDKey.String(ofCtx.GetCloudEvent().ID()),
+	)
+	return nil
+}
+
+func postAsyncRequestLogic(ctx ofctx.RuntimeContext, span trace.Span) {
+	setSpanStatus(ctx, span, "Error on handling event")
+}
+
+// cloudEventCarrier adapts the extensions of a cloudevent to propagation.TextMapCarrier.
+type cloudEventCarrier struct {
+	event *cloudevents.Event
+}
+
+func (c cloudEventCarrier) Get(key string) string {
+	if v, ok := c.event.Extensions()[key]; ok {
+		if s, err := types.ToString(v); err == nil {
+			return s
+		}
+	}
+	return ""
+}
+
+func (c cloudEventCarrier) Set(key string, value string) {
+	c.event.SetExtension(key, value)
+}
+
+func (c cloudEventCarrier) Keys() []string {
+	keys := make([]string, 0, len(c.event.Extensions()))
+	for k := range c.event.Extensions() {
+		keys = append(keys, k)
+	}
+	return keys
+}
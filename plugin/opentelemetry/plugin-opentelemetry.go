@@ -0,0 +1,235 @@
+package opentelemetry
+
+import (
+	"context"
+	"sync"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+	"k8s.io/klog/v2"
+
+	ofctx "github.com/OpenFunction/functions-framework-go/context"
+	"github.com/OpenFunction/functions-framework-go/plugin"
+)
+
+const (
+	Name    = "opentelemetry"
+	Version = "v1"
+)
+
+var (
+	tagComponentType = attribute.Key("component.type")
+	tagRuntime       = attribute.Key("runtime")
+	tagInputName     = attribute.Key("input.name")
+	tagOutputName    = attribute.Key("output.name")
+	tagDecision      = attribute.Key("resiliency.decision")
+	tagAttempt       = attribute.Key("resiliency.attempt")
+	tagBackoff       = attribute.Key("resiliency.backoff")
+
+	observeOnce sync.Once
+)
+
+// spanKey is the key of the entry span created by this plugin in the native context,
+// so that the post hook never ends a span created by someone else.
+type spanKey struct{}
+
+var _ plugin.Plugin = &PluginOpenTelemetry{}
+
+type PluginOpenTelemetry struct {
+	once     sync.Once
+	exporter sdktrace.SpanExporter
+	provider *sdktrace.TracerProvider
+	tracer   trace.Tracer
+}
+
+// New creates the plugin which exports the spans over OTLP/gRPC to the `oapServer` of the tracing provider,
+// the standard OTEL_EXPORTER_OTLP_* environment variables are used when it is empty.
+func New() *PluginOpenTelemetry {
+	return &PluginOpenTelemetry{}
+}
+
+// NewWithExporter creates the plugin which exports the spans synchronously with the given exporter,
+// e.g. tracetest.NewInMemoryExporter() in offline tests.
+func NewWithExporter(exporter sdktrace.SpanExporter) *PluginOpenTelemetry {
+	return &PluginOpenTelemetry{
+		exporter: exporter,
+	}
+}
+
+func (p *PluginOpenTelemetry) Init() plugin.Plugin {
+	observeOnce.Do(func() {
+		ofctx.AddResiliencyObserver(observeResiliency)
+	})
+	return p
+}
+
+func (p *PluginOpenTelemetry) Name() string {
+	return Name
+}
+
+func (p *PluginOpenTelemetry) Version() string {
+	return Version
+}
+
+func (p *PluginOpenTelemetry) ExecPreHook(ctx ofctx.RuntimeContext, plugins map[string]plugin.Plugin) error {
+	p.initTracer(ctx)
+	if p.tracer == nil {
+		return nil
+	}
+
+	if ctx.GetSyncRequest().Request != nil {
+		return preSyncRequestLogic(ctx, p.tracer)
+	} else if ctx.GetBindingEvent() != nil {
+		return preBindingEventLogic(ctx, p.tracer)
+	} else if ctx.GetTopicEvent() != nil {
+		return preTopicEventLogic(ctx, p.tracer)
+	} else if ctx.GetInvocationEvent() != nil {
+		return preInvocationEventLogic(ctx, p.tracer)
+	} else if ctx.GetCloudEvent() != nil {
+		return preCloudEventLogic(ctx, p.tracer)
+	}
+	return nil
+}
+
+func (p *PluginOpenTelemetry) ExecPostHook(ctx ofctx.RuntimeContext, plugins map[string]plugin.Plugin) error {
+	if p.tracer == nil || ctx.GetNativeContext() == nil {
+		return nil
+	}
+
+	span, ok := ctx.GetNativeContext().Value(spanKey{}).(trace.Span)
+	if !ok {
+		return nil
+	}
+	defer span.End()
+
+	if ctx.GetSyncRequest().Request != nil {
+		postSyncRequestLogic(ctx, span)
+	} else {
+		postAsyncRequestLogic(ctx, span)
+	}
+	return nil
+}
+
+func (p *PluginOpenTelemetry) Get(fieldName string) (interface{}, bool) {
+	return nil, false
+}
+
+// Shutdown flushes the buffered spans to the exporter.
+func (p *PluginOpenTelemetry) Shutdown(ctx context.Context) error {
+	if p.provider == nil {
+		return nil
+	}
+	return p.provider.Shutdown(ctx)
+}
+
+func (p *PluginOpenTelemetry) initTracer(ofCtx ofctx.RuntimeContext) {
+	p.once.Do(func() {
+		attrs := []attribute.KeyValue{
+			semconv.ServiceNameKey.String(ofCtx.GetName()),
+			semconv.FaaSNameKey.String(ofCtx.GetName()),
+		}
+		if ofCtx.GetPodName() != "" {
+			attrs = append(attrs, semconv.K8SPodNameKey.String(ofCtx.GetPodName()))
+		}
+		if ofCtx.GetPodNamespace() != "" {
+			attrs = append(attrs, semconv.K8SNamespaceNameKey.String(ofCtx.GetPodNamespace()))
+		}
+		opts := []sdktrace.TracerProviderOption{
+			sdktrace.WithResource(resource.NewWithAttributes(semconv.SchemaURL, attrs...)),
+		}
+
+		if p.exporter != nil {
+			opts = append(opts, sdktrace.WithSyncer(p.exporter))
+		} else {
+			var clientOpts []otlptracegrpc.Option
+			if ofCtx.HasPluginsTracingCfg() && ofCtx.GetPluginsTracingCfg().ProviderOapServer() != "" {
+				clientOpts = append(clientOpts,
+					otlptracegrpc.WithEndpoint(ofCtx.GetPluginsTracingCfg().ProviderOapServer()),
+					otlptracegrpc.WithInsecure(),
+				)
+			}
+			exporter, err := otlptracegrpc.New(context.Background(), clientOpts...)
+			if err != nil {
+				klog.Errorf("new opentelemetry otlp exporter error: %v\n", err)
+				return
+			}
+			opts = append(opts, sdktrace.WithBatcher(exporter))
+		}
+
+		p.provider = sdktrace.NewTracerProvider(opts...)
+		otel.SetTracerProvider(p.provider)
+		otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+		p.tracer = p.provider.Tracer(ofctx.OpenTelemetryInstrumentationName)
+	})
+}
+
+func setPublicAttrs(ctx context.Context, ofCtx ofctx.RuntimeContext, span trace.Span) context.Context {
+	if !ofCtx.HasPluginsTracingCfg() {
+		return ctx
+	}
+
+	// tags
+	for key, value := range ofCtx.GetPluginsTracingCfg().GetTags() {
+		span.SetAttributes(attribute.String(key, value))
+	}
+	// baggage
+	bag := baggage.FromContext(ctx)
+	for key, value := range ofCtx.GetPluginsTracingCfg().GetBaggage() {
+		member, err := baggage.NewMember(key, value)
+		if err != nil {
+			klog.Warningf("skip invalid baggage %s: %v", key, err)
+			continue
+		}
+		if b, err := bag.SetMember(member); err == nil {
+			bag = b
+		}
+	}
+	return baggage.ContextWithBaggage(ctx, bag)
+}
+
+func withSpan(ctx context.Context, span trace.Span) context.Context {
+	return context.WithValue(trace.ContextWithSpan(ctx, span), spanKey{}, span)
+}
+
+func setSpanStatus(ofCtx ofctx.RuntimeContext, span trace.Span, desc string) {
+	if ofCtx.GetOut() != nil && ofCtx.GetOut().GetCode() == ofctx.InternalError {
+		span.SetStatus(codes.Error, desc)
+	}
+
+	if ofCtx.GetError() != nil {
+		span.RecordError(ofCtx.GetError())
+		span.SetStatus(codes.Error, ofCtx.GetError().Error())
+	}
+}
+
+// observeResiliency records the decisions of the resiliency policies of the outputs as events of the invocation span.
+func observeResiliency(c context.Context, ofCtx ofctx.RuntimeContext, event *ofctx.ResiliencyEvent) {
+	span := trace.SpanFromContext(c)
+	if !span.IsRecording() {
+		return
+	}
+
+	attrs := []attribute.KeyValue{
+		tagOutputName.String(event.OutputName),
+		tagDecision.String(string(event.Decision)),
+		tagAttempt.Int(event.Attempt),
+	}
+	if event.Output != nil {
+		attrs = append(attrs, tagComponentType.String(event.Output.ComponentType))
+	}
+	if event.Backoff > 0 {
+		attrs = append(attrs, tagBackoff.String(event.Backoff.String()))
+	}
+	if event.Err != nil {
+		attrs = append(attrs, semconv.ExceptionMessageKey.String(event.Err.Error()))
+	}
+	span.AddEvent("resiliency", trace.WithAttributes(attrs...))
+}
@@ -0,0 +1,142 @@
+package opentelemetry
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dapr/go-sdk/service/common"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	ofctx "github.com/OpenFunction/functions-framework-go/context"
+	"github.com/OpenFunction/functions-framework-go/plugin"
+	"github.com/OpenFunction/functions-framework-go/runtime"
+)
+
+const (
+	funcCtx = `{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Knative",
+  "pluginsTracing": {
+    "enabled": true,
+    "provider": {
+      "name": "opentelemetry"
+    },
+    "tags": {
+      "func": "function-test",
+      "layer": "faas"
+    }
+  }
+}`
+	traceID     = "4bf92f3577b34da6a3ce929d0e0e4736"
+	traceParent = "00-" + traceID + "-00f067aa0ba902b7-01"
+)
+
+func newRuntimeContext(t *testing.T) ofctx.RuntimeContext {
+	os.Setenv(ofctx.ModeEnvName, ofctx.SelfHostMode)
+	os.Setenv(ofctx.TestModeEnvName, ofctx.TestModeOn)
+	os.Setenv(ofctx.FunctionContextEnvName, funcCtx)
+	ctx, err := ofctx.GetRuntimeContext()
+	if err != nil {
+		t.Fatalf("failed to parse function context: %v", err)
+	}
+	return ctx
+}
+
+func TestSyncRequestSpan(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	plg := NewWithExporter(exporter)
+	plugins := []plugin.Plugin{plg}
+	ctx := newRuntimeContext(t)
+
+	fn := func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		if !trace.SpanContextFromContext(ctx.GetNativeContext()).IsValid() {
+			return ctx.ReturnOnInternalError(), errors.New("no span in the native context")
+		}
+		return ctx.ReturnOnSuccess(), nil
+	}
+
+	rm := runtime.NewRuntimeManager(ctx, plugins, plugins)
+	req := httptest.NewRequest(http.MethodPost, "/", nil)
+	req.Header.Set("traceparent", traceParent)
+	rm.FuncContext.SetNativeContext(req.Context())
+	rm.FuncContext.SetSyncRequest(httptest.NewRecorder(), req)
+	rm.FunctionRunWrapperWithHooks(fn)
+
+	spans := exporter.GetSpans()
+	if assert.Len(t, spans, 1) {
+		span := spans[0]
+		assert.Equal(t, "function-test", span.Name)
+		assert.Equal(t, trace.SpanKindServer, span.SpanKind)
+		assert.Equal(t, traceID, span.SpanContext.TraceID().String())
+		assert.Equal(t, traceID, span.Parent.TraceID().String())
+		assert.Equal(t, codes.Unset, span.Status.Code)
+	}
+	assert.NoError(t, rm.FuncContext.GetError())
+}
+
+func TestBindingEventSpan(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	plg := NewWithExporter(exporter)
+	plugins := []plugin.Plugin{plg}
+	ctx := newRuntimeContext(t)
+
+	fn := func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		return ctx.ReturnOnInternalError(), errors.New("failed to handle event")
+	}
+
+	// the upstream function sends the trace context in the metadata of the inner event
+	ie := ofctx.NewInnerEvent(ctx)
+	ie.SetMetadata("traceparent", traceParent)
+	ie.SetUserData([]byte("hello"))
+
+	rm := runtime.NewRuntimeManager(ctx, plugins, plugins)
+	rm.FuncContext.SetEvent("kafka", &common.BindingEvent{Data: ie.GetCloudEventJSON()})
+	rm.FunctionRunWrapperWithHooks(fn)
+
+	spans := exporter.GetSpans()
+	if assert.Len(t, spans, 1) {
+		span := spans[0]
+		assert.Equal(t, trace.SpanKindServer, span.SpanKind)
+		assert.Equal(t, traceID, span.SpanContext.TraceID().String())
+		assert.Equal(t, codes.Error, span.Status.Code)
+		assert.Contains(t, span.Attributes, tagComponentType.String(string(ofctx.OpenFuncBinding)))
+		assert.Contains(t, span.Attributes, tagInputName.String("kafka"))
+	}
+}
+
+func TestResiliencyEvents(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	ctx := newRuntimeContext(t)
+
+	c, span := provider.Tracer("test").Start(context.Background(), "function-test")
+	observeResiliency(c, ctx, &ofctx.ResiliencyEvent{
+		OutputName: "kafka",
+		Output:     &ofctx.Output{ComponentType: "bindings.kafka"},
+		Decision:   ofctx.ResiliencyRetry,
+		Attempt:    1,
+		Backoff:    100 * time.Millisecond,
+		Err:        errors.New("broker is not available"),
+	})
+	span.End()
+
+	spans := exporter.GetSpans()
+	if assert.Len(t, spans, 1) && assert.Len(t, spans[0].Events, 1) {
+		event := spans[0].Events[0]
+		assert.Equal(t, "resiliency", event.Name)
+		assert.Contains(t, event.Attributes, tagOutputName.String("kafka"))
+		assert.Contains(t, event.Attributes, tagDecision.String(string(ofctx.ResiliencyRetry)))
+		assert.Contains(t, event.Attributes, tagAttempt.Int(1))
+		assert.Contains(t, event.Attributes, tagBackoff.String("100ms"))
+	}
+}
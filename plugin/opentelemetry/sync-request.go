@@ -0,0 +1,45 @@
+package opentelemetry
+
+import (
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/baggage"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.10.0"
+	"go.opentelemetry.io/otel/trace"
+
+	ofctx "github.com/OpenFunction/functions-framework-go/context"
+)
+
+func preSyncRequestLogic(ofCtx ofctx.RuntimeContext, tracer trace.Tracer) error {
+	request := ofCtx.GetSyncRequest().Request
+
+	pCtx := otel.GetTextMapPropagator().Extract(request.Context(), propagation.HeaderCarrier(request.Header))
+	nCtx, span := tracer.Start(pCtx, ofCtx.GetName(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			semconv.FaaSTriggerHTTP,
+			semconv.HTTPMethodKey.String(request.Method),
+			semconv.HTTPTargetKey.String(request.URL.Path),
+			semconv.HTTPHostKey.String(request.Host),
+			tagRuntime.String(string(ofctx.Knative)),
+		),
+	)
+	nCtx = setPublicAttrs(nCtx, ofCtx, span)
+
+	ofCtx.GetSyncRequest().Request = request.WithContext(withSpan(nCtx, span))                                              // HTTPFunction
+	ofCtx.SetNativeContext(withSpan(baggage.ContextWithBaggage(ofCtx.GetNativeContext(), baggage.FromContext(nCtx)), span)) // OpenFunction
+	return nil
+}
+
+func postSyncRequestLogic(ctx ofctx.RuntimeContext, span trace.Span) {
+	if ctx.GetOut() != nil {
+		code := ctx.GetOut().GetCode()
+		span.SetAttributes(semconv.HTTPStatusCodeKey.Int(code))
+		if code >= 500 {
+			span.SetStatus(codes.Error, "Error on handling request")
+		}
+	}
+
+	setSpanStatus(ctx, span, "Error on handling request")
+}
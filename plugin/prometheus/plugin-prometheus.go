@@ -0,0 +1,204 @@
+package prometheus
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	prom "github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	ofctx "github.com/OpenFunction/functions-framework-go/context"
+	"github.com/OpenFunction/functions-framework-go/plugin"
+)
+
+const (
+	Name    = "prometheus"
+	Version = "v1"
+
+	MetricsPathEnvName = "METRICS_PATH"
+	defaultMetricsPath = "/metrics"
+
+	namespace      = "openfunction"
+	outcomeSuccess = "success"
+	outcomeError   = "error"
+)
+
+var (
+	registerOnce sync.Once
+
+	invocationLabels = []string{"function", "runtime", "input", "code", "outcome"}
+	outputLabels     = []string{"function", "output", "type", "outcome"}
+
+	invocationsTotal = prom.NewCounterVec(prom.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "function",
+		Name:      "invocations_total",
+		Help:      "Number of function invocations.",
+	}, invocationLabels)
+	invocationDuration = prom.NewHistogramVec(prom.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "function",
+		Name:      "invocation_duration_seconds",
+		Help:      "Duration of function invocations in seconds.",
+		Buckets:   prom.DefBuckets,
+	}, invocationLabels)
+	invocationsInFlight = &inFlightCollector{
+		desc: prom.NewDesc(prom.BuildFQName(namespace, "function", "invocations_in_flight"),
+			"Number of function invocations being executed, including the ones abandoned on timeout.",
+			[]string{"function", "runtime"}, nil),
+	}
+	outputCallsTotal = prom.NewCounterVec(prom.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "function",
+		Name:      "output_calls_total",
+		Help:      "Number of calls to the function outputs made by Send.",
+	}, outputLabels)
+	outputCallDuration = prom.NewHistogramVec(prom.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "function",
+		Name:      "output_call_duration_seconds",
+		Help:      "Duration of the calls to the function outputs made by Send in seconds.",
+		Buckets:   prom.DefBuckets,
+	}, outputLabels)
+	outputResiliencyDecisionsTotal = prom.NewCounterVec(prom.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "function",
+		Name:      "output_resiliency_decisions_total",
+		Help:      "Number of decisions made by the resiliency policies of the function outputs, such as retries and circuit breaker state changes.",
+	}, []string{"function", "output", "decision"})
+)
+
+var _ plugin.Plugin = &PluginPrometheus{}
+var _ plugin.Endpoint = &PluginPrometheus{}
+
+// PluginPrometheus records the invocations of the function and the calls to its outputs,
+// it has to be enabled in both prePlugins and postPlugins to measure the invocations.
+type PluginPrometheus struct {
+	start time.Time
+}
+
+// New creates the plugin, the metrics are registered in the default prometheus registry
+// so that the metrics of the user are exposed as well.
+func New() *PluginPrometheus {
+	return &PluginPrometheus{}
+}
+
+func (p *PluginPrometheus) Init() plugin.Plugin {
+	registerOnce.Do(func() {
+		prom.MustRegister(invocationsTotal, invocationDuration, invocationsInFlight, outputCallsTotal, outputCallDuration, outputResiliencyDecisionsTotal)
+		ofctx.AddSendObserver(observeSend)
+		ofctx.AddResiliencyObserver(observeResiliency)
+	})
+
+	// Each invocation gets its own instance to keep the start time
+	return New()
+}
+
+func (p *PluginPrometheus) Name() string {
+	return Name
+}
+
+func (p *PluginPrometheus) Version() string {
+	return Version
+}
+
+func (p *PluginPrometheus) ExecPreHook(ctx ofctx.RuntimeContext, plugins map[string]plugin.Plugin) error {
+	p.start = time.Now()
+	return nil
+}
+
+func (p *PluginPrometheus) ExecPostHook(ctx ofctx.RuntimeContext, plugins map[string]plugin.Plugin) error {
+	if p.start.IsZero() {
+		return nil
+	}
+
+	code := ""
+	outcome := outcomeSuccess
+	if ctx.GetOut() != nil {
+		code = strconv.Itoa(ctx.GetOut().GetCode())
+		if ctx.GetOut().GetCode() >= ofctx.InternalError {
+			outcome = outcomeError
+		}
+	}
+	if ctx.GetError() != nil {
+		outcome = outcomeError
+	}
+
+	labels := prom.Labels{
+		"function": ctx.GetName(),
+		"runtime":  string(ctx.GetRuntime()),
+		"input":    ctx.GetContext().GetInputName(),
+		"code":     code,
+		"outcome":  outcome,
+	}
+	invocationsTotal.With(labels).Inc()
+	invocationDuration.With(labels).Observe(time.Since(p.start).Seconds())
+	return nil
+}
+
+func (p *PluginPrometheus) Get(fieldName string) (interface{}, bool) {
+	return nil, false
+}
+
+// Endpoint returns the path the metrics are served on, which can be changed with the METRICS_PATH env.
+func (p *PluginPrometheus) Endpoint() (string, http.Handler) {
+	path := os.Getenv(MetricsPathEnvName)
+	if path == "" {
+		path = defaultMetricsPath
+	}
+	return path, promhttp.Handler()
+}
+
+func observeSend(ctx ofctx.RuntimeContext, outputName string, output *ofctx.Output, duration time.Duration, err error) {
+	outcome := outcomeSuccess
+	if err != nil {
+		outcome = outcomeError
+	}
+
+	// the outputs of the transports other than dapr may have no component type
+	outputType := string(output.GetType())
+	if outputType == "" {
+		outputType = output.GetTransport()
+	}
+	labels := prom.Labels{
+		"function": ctx.GetName(),
+		"output":   outputName,
+		"type":     outputType,
+		"outcome":  outcome,
+	}
+	outputCallsTotal.With(labels).Inc()
+	outputCallDuration.With(labels).Observe(duration.Seconds())
+}
+
+func observeResiliency(c context.Context, ctx ofctx.RuntimeContext, event *ofctx.ResiliencyEvent) {
+	outputResiliencyDecisionsTotal.WithLabelValues(ctx.GetName(), event.OutputName, string(event.Decision)).Inc()
+}
+
+// inFlightCollector exports the in-flight invocations counted by the function contexts, so that the functions
+// abandoned on timeout are counted until they return, whether the plugin runs in the pre or the post hooks.
+type inFlightCollector struct {
+	desc *prom.Desc
+}
+
+func (c *inFlightCollector) Describe(ch chan<- *prom.Desc) {
+	ch <- c.desc
+}
+
+func (c *inFlightCollector) Collect(ch chan<- prom.Metric) {
+	// the functions deployed in one pod may share the name
+	type key struct {
+		function string
+		runtime  string
+	}
+	inFlight := map[key]int64{}
+	ofctx.VisitRuntimeContexts(func(ctx ofctx.RuntimeContext) {
+		inFlight[key{ctx.GetName(), string(ctx.GetRuntime())}] += ctx.GetInFlight()
+	})
+	for k, n := range inFlight {
+		ch <- prom.MustNewConstMetric(c.desc, prom.GaugeValue, float64(n), k.function, k.runtime)
+	}
+}
@@ -0,0 +1,185 @@
+package prometheus
+
+import (
+	"context"
+	"errors"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dapr/go-sdk/service/common"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+
+	ofctx "github.com/OpenFunction/functions-framework-go/context"
+	"github.com/OpenFunction/functions-framework-go/plugin"
+	"github.com/OpenFunction/functions-framework-go/runtime"
+)
+
+const funcCtx = `{
+  "name": "function-test",
+  "version": "v1.0.0",
+  "runtime": "Async",
+  "inputs": {
+    "kafka": {
+      "uri": "kafka",
+      "componentName": "kafka",
+      "componentType": "bindings.kafka"
+    }
+  },
+  "outputs": {
+    "topic": {
+      "uri": "sample",
+      "componentName": "msg",
+      "componentType": "pubsub.kafka"
+    }
+  },
+  "prePlugins": ["prometheus"],
+  "postPlugins": ["prometheus"]
+}`
+
+func newRuntimeContext(t *testing.T) ofctx.RuntimeContext {
+	os.Setenv(ofctx.ModeEnvName, ofctx.SelfHostMode)
+	os.Setenv(ofctx.TestModeEnvName, ofctx.TestModeOn)
+	os.Setenv(ofctx.FunctionContextEnvName, funcCtx)
+	ctx, err := ofctx.GetRuntimeContext()
+	if err != nil {
+		t.Fatalf("failed to parse function context: %v", err)
+	}
+	return ctx
+}
+
+func TestInvocationMetrics(t *testing.T) {
+	plugins := []plugin.Plugin{New()}
+	ctx := newRuntimeContext(t)
+
+	success := func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		return ctx.ReturnOnSuccess(), nil
+	}
+	failure := func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		return ctx.ReturnOnInternalError(), errors.New("failed to handle event")
+	}
+
+	for _, fn := range []func(ofctx.Context, []byte) (ofctx.Out, error){success, success, failure} {
+		rm := runtime.NewRuntimeManager(ctx, plugins, plugins)
+		rm.FuncContext.SetEvent("kafka", &common.BindingEvent{Data: []byte("hello")})
+		rm.FunctionRunWrapperWithHooks(fn)
+	}
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(invocationsTotal.WithLabelValues("function-test", "Async", "kafka", "200", outcomeSuccess)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(invocationsTotal.WithLabelValues("function-test", "Async", "kafka", "500", outcomeError)))
+	assert.Equal(t, 2, testutil.CollectAndCount(invocationDuration))
+}
+
+func TestInFlightMetrics(t *testing.T) {
+	plugins := []plugin.Plugin{New()}
+	ctx := newRuntimeContext(t)
+
+	inFlight := -1.0
+	fn := func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		inFlight = testutil.ToFloat64(invocationsInFlight)
+		return ctx.ReturnOnSuccess(), nil
+	}
+
+	rm := runtime.NewRuntimeManager(ctx, plugins, plugins)
+	rm.FuncContext.SetEvent("kafka", &common.BindingEvent{Data: []byte("hello")})
+	rm.FunctionRunWrapperWithHooks(fn)
+
+	assert.Equal(t, float64(1), inFlight)
+	assert.Equal(t, float64(0), testutil.ToFloat64(invocationsInFlight))
+	assert.Equal(t, int64(0), ctx.GetInFlight())
+}
+
+func TestConcurrentInFlightMetrics(t *testing.T) {
+	plugins := []plugin.Plugin{New()}
+	ctx := newRuntimeContext(t)
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	fn := func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		started <- struct{}{}
+		<-release
+		return ctx.ReturnOnSuccess(), nil
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rm := runtime.NewRuntimeManager(ctx, plugins, plugins)
+			rm.FuncContext.SetEvent("kafka", &common.BindingEvent{Data: []byte("hello")})
+			rm.FunctionRunWrapperWithHooks(fn)
+		}()
+	}
+	for i := 0; i < 10; i++ {
+		<-started
+	}
+	assert.Equal(t, float64(10), testutil.ToFloat64(invocationsInFlight))
+	close(release)
+	wg.Wait()
+	assert.Equal(t, float64(0), testutil.ToFloat64(invocationsInFlight))
+}
+
+func TestAbandonedInFlightMetrics(t *testing.T) {
+	// the plugin is enabled only in the pre hooks
+	plugins := []plugin.Plugin{New()}
+	ctx := newRuntimeContext(t)
+
+	release := make(chan struct{})
+	fn := func(ctx ofctx.Context, in []byte) (ofctx.Out, error) {
+		// ignores the cancellation, so that it is abandoned on timeout
+		<-release
+		return ctx.ReturnOnSuccess(), nil
+	}
+
+	rm := runtime.NewRuntimeManager(ctx, plugins, nil)
+	rm.SetTimeout(10 * time.Millisecond)
+	rm.FuncContext.SetEvent("kafka", &common.BindingEvent{Data: []byte("hello")})
+	rm.FunctionRunWrapperWithHooks(fn)
+	exited := make(chan struct{})
+	rm.OnFunctionExit(func() { close(exited) })
+
+	// the abandoned function is counted until it returns
+	assert.Equal(t, float64(1), testutil.ToFloat64(invocationsInFlight))
+	close(release)
+	<-exited
+	assert.Eventually(t, func() bool {
+		return testutil.ToFloat64(invocationsInFlight) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestOutputMetrics(t *testing.T) {
+	ctx := newRuntimeContext(t)
+	output := ctx.GetOutputs()["topic"]
+
+	observeSend(ctx, "topic", output, 10*time.Millisecond, nil)
+	observeSend(ctx, "topic", output, 10*time.Millisecond, errors.New("broker is not available"))
+
+	assert.Equal(t, float64(1), testutil.ToFloat64(outputCallsTotal.WithLabelValues("function-test", "topic", "pubsub", outcomeSuccess)))
+	assert.Equal(t, float64(1), testutil.ToFloat64(outputCallsTotal.WithLabelValues("function-test", "topic", "pubsub", outcomeError)))
+}
+
+func TestResiliencyMetrics(t *testing.T) {
+	ctx := newRuntimeContext(t)
+	output := ctx.GetOutputs()["topic"]
+
+	observeResiliency(context.Background(), ctx, &ofctx.ResiliencyEvent{OutputName: "topic", Output: output, Decision: ofctx.ResiliencyRetry, Attempt: 1})
+	observeResiliency(context.Background(), ctx, &ofctx.ResiliencyEvent{OutputName: "topic", Output: output, Decision: ofctx.ResiliencyRetry, Attempt: 2})
+	observeResiliency(context.Background(), ctx, &ofctx.ResiliencyEvent{OutputName: "topic", Output: output, Decision: ofctx.ResiliencyCircuitOpen, Attempt: 3})
+
+	assert.Equal(t, float64(2), testutil.ToFloat64(outputResiliencyDecisionsTotal.WithLabelValues("function-test", "topic", "retry")))
+	assert.Equal(t, float64(1), testutil.ToFloat64(outputResiliencyDecisionsTotal.WithLabelValues("function-test", "topic", "circuit_open")))
+}
+
+func TestEndpoint(t *testing.T) {
+	path, handler := New().Endpoint()
+	assert.Equal(t, defaultMetricsPath, path)
+	assert.NotNil(t, handler)
+
+	os.Setenv(MetricsPathEnvName, "/custom-metrics")
+	defer os.Unsetenv(MetricsPathEnvName)
+	path, _ = New().Endpoint()
+	assert.Equal(t, "/custom-metrics", path)
+}
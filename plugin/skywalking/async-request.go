@@ -42,6 +42,15 @@ func preBindingEventLogic(ofCtx ofctx.RuntimeContext, tracer *go2sky.Tracer) err
 	return nil
 }
 
+func preInvocationEventLogic(ofCtx ofctx.RuntimeContext, tracer *go2sky.Tracer) error {
+	span, err := preAsyncRequestCommonLogic(ofCtx, tracer)
+	if err != nil {
+		return err
+	}
+	span.Tag(tagComponentType, string(ofctx.OpenFuncInvoke))
+	return nil
+}
+
 func postAsyncRequestLogic(ctx ofctx.RuntimeContext) error {
 	span := go2sky.ActiveSpan(ctx.GetNativeContext())
 	if span == nil {
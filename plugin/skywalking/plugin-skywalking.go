@@ -111,6 +111,8 @@ func (p *PluginSkywalking) ExecPreHook(ctx ofctx.RuntimeContext, plugins map[str
 		return preBindingEventLogic(ctx, p.tracer)
 	} else if ctx.GetTopicEvent() != nil {
 		return preTopicEventLogic(ctx, p.tracer)
+	} else if ctx.GetInvocationEvent() != nil {
+		return preInvocationEventLogic(ctx, p.tracer)
 	}
 	return nil
 }
@@ -122,7 +124,7 @@ func (p *PluginSkywalking) ExecPostHook(ctx ofctx.RuntimeContext, plugins map[st
 
 	if ctx.GetSyncRequest().Request != nil {
 		return postSyncRequestLogic(ctx)
-	} else if ctx.GetBindingEvent() != nil || ctx.GetTopicEvent() != nil {
+	} else if ctx.GetBindingEvent() != nil || ctx.GetTopicEvent() != nil || ctx.GetInvocationEvent() != nil {
 		return postAsyncRequestLogic(ctx)
 	}
 	return nil
@@ -4,9 +4,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 	"os"
+	"runtime/debug"
 	"strings"
 
+	"github.com/go-chi/chi/v5"
+
 	dapr "github.com/dapr/go-sdk/service/common"
 	grpcsvc "github.com/dapr/go-sdk/service/grpc"
 	httpsvc "github.com/dapr/go-sdk/service/http"
@@ -19,16 +23,44 @@ import (
 )
 
 const (
-	defaultPattern = "/"
-	protocolEnvVar = "APP_PROTOCOL"
+	defaultPattern        = "/"
+	defaultManagementPort = "8081"
+	protocolEnvVar        = "APP_PROTOCOL"
+	managementPortEnvVar  = "MANAGEMENT_PORT"
 )
 
+// errTooManyEvents rejects the events exceeding the max concurrency of the function to be redelivered.
+var errTooManyEvents = errors.New("too many concurrent events")
+
+// errStopping rejects the events arriving after the runtime starts stopping to be redelivered.
+var errStopping = errors.New("the runtime is stopping")
+
 type Runtime struct {
-	protocol   string
-	port       string
-	pattern    string
-	handler    dapr.Service
-	grpcHander *FakeServer
+	protocol       string
+	port           string
+	pattern        string
+	handler        dapr.Service
+	grpcHander     *FakeServer
+	inflight       *runtime.InflightTracker
+	managementPort string
+	management     *chi.Mux
+	managementSrv  *http.Server
+	// claims records the function serving each binding or topic
+	claims map[string]string
+}
+
+// newManagementServer creates the http server for the framework-owned endpoints,
+// since the Dapr service of the async runtime cannot serve them.
+func newManagementServer() (string, *chi.Mux, *http.Server) {
+	port := os.Getenv(managementPortEnvVar)
+	if port == "" {
+		port = defaultManagementPort
+	}
+	handler := chi.NewRouter()
+	return port, handler, &http.Server{
+		Addr:    fmt.Sprintf(":%s", port),
+		Handler: handler,
+	}
 }
 
 func NewAsyncRuntime(port string, pattern string) (*Runtime, error) {
@@ -36,24 +68,14 @@ func NewAsyncRuntime(port string, pattern string) (*Runtime, error) {
 		pattern = defaultPattern
 	}
 	if testMode := os.Getenv(ofctx.TestModeEnvName); testMode == ofctx.TestModeOn {
-		handler, grpcHandler, err := NewFakeService(fmt.Sprintf(":%s", port))
-		if err != nil {
-			klog.Errorf("failed to create dapr grpc service: %v\n", err)
-			return nil, err
-		}
-		return &Runtime{
-			port:       port,
-			pattern:    pattern,
-			handler:    handler,
-			grpcHander: grpcHandler,
-		}, nil
+		return NewFakeAsyncRuntime(port, pattern)
 	}
 
 	var handler dapr.Service
 	protocol := os.Getenv(protocolEnvVar)
 	switch protocol {
 	case "http":
-		handler = httpsvc.NewService(fmt.Sprintf(":%s", port))
+		handler = httpsvc.NewServiceWithMux(fmt.Sprintf(":%s", port), newInvocationRouter())
 	default:
 		protocol = "grpc"
 		service, err := grpcsvc.NewService(fmt.Sprintf(":%s", port))
@@ -64,21 +86,94 @@ func NewAsyncRuntime(port string, pattern string) (*Runtime, error) {
 		handler = service
 	}
 
+	managementPort, management, managementSrv := newManagementServer()
+	return &Runtime{
+		protocol:       protocol,
+		port:           port,
+		pattern:        pattern,
+		handler:        handler,
+		grpcHander:     nil,
+		managementPort: managementPort,
+		management:     management,
+		managementSrv:  managementSrv,
+		claims:         map[string]string{},
+		inflight:       runtime.NewInflightTracker(),
+	}, nil
+}
+
+// NewFakeAsyncRuntime creates the async runtime served by a FakeServer, whose handlers are called
+// in-process instead of by Dapr, e.g. by the tests of the functions.
+func NewFakeAsyncRuntime(port string, pattern string) (*Runtime, error) {
+	if pattern == "" {
+		pattern = defaultPattern
+	}
+	handler, grpcHandler, err := NewFakeService(fmt.Sprintf(":%s", port))
+	if err != nil {
+		klog.Errorf("failed to create dapr grpc service: %v\n", err)
+		return nil, err
+	}
+	managementPort, management, managementSrv := newManagementServer()
 	return &Runtime{
-		protocol:   protocol,
-		port:       port,
-		pattern:    pattern,
-		handler:    handler,
-		grpcHander: nil,
+		port:           port,
+		pattern:        pattern,
+		handler:        handler,
+		grpcHander:     grpcHandler,
+		managementPort: managementPort,
+		management:     management,
+		managementSrv:  managementSrv,
+		claims:         map[string]string{},
+		inflight:       runtime.NewInflightTracker(),
 	}, nil
 }
 
 func (r *Runtime) Start(ctx context.Context) error {
+	if len(r.management.Routes()) > 0 {
+		go func() {
+			klog.Infof("Async Function serving management endpoints: listening on port %s", r.managementPort)
+			if err := r.managementSrv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				klog.Errorf("failed to serve management endpoints: %v", err)
+			}
+		}()
+	}
+
 	klog.Infof("Async Function serving %s: listening on port %s", r.protocol, r.port)
-	klog.Fatal(r.handler.Start())
+	if err := r.handler.Start(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
 	return nil
 }
 
+func (r *Runtime) Stop(ctx context.Context) error {
+	klog.Infof("Async Function stopping %s service", r.protocol)
+	done := make(chan error, 1)
+	// the events arriving from now on are rejected to be redelivered
+	drained := r.inflight.Drain()
+	go func() {
+		err := r.handler.GracefulStop()
+		// the http service gives up after a fixed period, and the functions abandoned on timeout
+		// outlive their handlers, so wait for the events by ourselves
+		<-drained
+		done <- err
+	}()
+
+	defer func() {
+		if err := r.managementSrv.Shutdown(ctx); err != nil {
+			klog.Errorf("failed to stop management server: %v", err)
+		}
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		klog.Warningf("in-flight events are not finished in time: %v", ctx.Err())
+		if err := r.handler.Stop(); err != nil {
+			klog.Errorf("failed to stop %s service: %v", r.protocol, err)
+		}
+		return ctx.Err()
+	}
+}
+
 func (r *Runtime) RegisterHTTPFunction(
 	ctx ofctx.RuntimeContext,
 	prePlugins []plugin.Plugin,
@@ -113,25 +208,55 @@ func (r *Runtime) RegisterOpenFunction(
 
 		// Serving function with inputs
 		if ctx.HasInputs() {
-			for name, input := range ctx.GetInputs() {
+			inputs, err := r.claimInputs(ctx, rf)
+			if err != nil {
+				ctx.DestroyDaprClient()
+				klog.Errorf("failed to register function: %v\n", err)
+				return err
+			}
+			// the inputs of the function share its concurrency limit
+			limiter := runtime.NewConcurrencyLimiter(rf)
+			for name, input := range inputs {
 				n := name
+				dl := newDeadLetter(input)
 				switch input.GetType() {
 				case ofctx.OpenFuncBinding:
-					input.Uri = input.ComponentName
 					funcErr = r.handler.AddBindingInvocationHandler(input.Uri, func(c context.Context, in *dapr.BindingEvent) (out []byte, err error) {
-						rm := runtime.NewRuntimeManager(ctx, prePlugins, postPlugins)
+						// the function is recovered by the runtime manager, this guards the plugins
+						defer func() {
+							if p := recover(); p != nil {
+								out, err = bindingResponse(n, ofctx.NewFunctionOut(), panicError(ctx, n, p))
+							}
+						}()
+						if !r.inflight.Begin() {
+							return bindingResponse(n, ofctx.NewFunctionOut(), ofctx.NewRetryableError(errStopping))
+						}
+						if !limiter.Acquire(c) {
+							r.inflight.End()
+							return bindingResponse(n, ofctx.NewFunctionOut(), ofctx.NewRetryableError(errTooManyEvents))
+						}
+
+						// the slot and the event are held until the function returns, even if it is abandoned on timeout,
+						// they are released right away if the runtime manager fails to be created
+						var rm *runtime.RuntimeManager
+						defer func() {
+							rm.OnFunctionExit(func() {
+								limiter.Release()
+								r.inflight.End()
+							})
+						}()
+						rm = runtime.NewRuntimeManager(ctx, prePlugins, postPlugins)
+						rm.SetTimeout(runtime.GetFunctionTimeout(ctx, rf))
 						rm.FuncContext.SetNativeContext(c)
 						rm.FuncContext.SetEvent(n, in)
 						rm.FunctionRunWrapperWithHooks(rf.GetOpenFunctionFunction())
 
-						switch rm.FuncOut.GetCode() {
-						case ofctx.Success:
-							return rm.FuncOut.GetData(), nil
-						case ofctx.InternalError:
-							return nil, rm.FuncContext.GetError()
-						default:
+						// the binding may redeliver the event if the error is returned
+						out, err = bindingResponse(n, rm.FuncOut, rm.FuncContext.GetError())
+						if dl.handle(c, rm, n, bindingEventKey(in), err != nil) {
 							return nil, nil
 						}
+						return out, err
 					})
 					if funcErr == nil {
 						klog.Infof("registered bindings handler: %s", input.Uri)
@@ -145,33 +270,80 @@ func (r *Runtime) RegisterOpenFunction(
 						sub.Route = fmt.Sprintf("/%s", input.Uri)
 					}
 					funcErr = r.handler.AddTopicEventHandler(sub, func(c context.Context, e *dapr.TopicEvent) (retry bool, err error) {
-						rm := runtime.NewRuntimeManager(ctx, prePlugins, postPlugins)
+						// the function is recovered by the runtime manager, this guards the plugins
+						defer func() {
+							if p := recover(); p != nil {
+								retry, err = topicResponse(n, ofctx.NewFunctionOut(), panicError(ctx, n, p))
+							}
+						}()
+						if !r.inflight.Begin() {
+							return topicResponse(n, ofctx.NewFunctionOut(), ofctx.NewRetryableError(errStopping))
+						}
+						if !limiter.Acquire(c) {
+							r.inflight.End()
+							return topicResponse(n, ofctx.NewFunctionOut(), ofctx.NewRetryableError(errTooManyEvents))
+						}
+
+						// the slot and the event are held until the function returns, even if it is abandoned on timeout,
+						// they are released right away if the runtime manager fails to be created
+						var rm *runtime.RuntimeManager
+						defer func() {
+							rm.OnFunctionExit(func() {
+								limiter.Release()
+								r.inflight.End()
+							})
+						}()
+						rm = runtime.NewRuntimeManager(ctx, prePlugins, postPlugins)
+						rm.SetTimeout(runtime.GetFunctionTimeout(ctx, rf))
 						rm.FuncContext.SetNativeContext(c)
 						rm.FuncContext.SetEvent(n, e)
 						rm.FunctionRunWrapperWithHooks(rf.GetOpenFunctionFunction())
 
-						switch rm.FuncOut.GetCode() {
-						case ofctx.Success:
-							return false, nil
-						case ofctx.InternalError:
-							err = rm.FuncContext.GetError()
-							if retry, ok := rm.FuncOut.GetMetadata()["retry"]; ok {
-								if strings.EqualFold(retry, "true") {
-									return true, err
-								} else if strings.EqualFold(retry, "false") {
-									return false, err
-								} else {
-									return false, err
-								}
-							}
-							return false, err
-						default:
+						retry, err = topicResponse(n, rm.FuncOut, rm.FuncContext.GetError())
+						if dl.handle(c, rm, n, topicEventKey(e), retry) {
 							return false, nil
 						}
+						return retry, err
 					})
 					if funcErr == nil {
 						klog.Infof("registered pubsub handler: %s, topic: %s", input.ComponentName, input.Uri)
 					}
+				case ofctx.OpenFuncInvoke:
+					funcErr = r.handler.AddServiceInvocationHandler(input.Uri, func(c context.Context, in *dapr.InvocationEvent) (out *dapr.Content, err error) {
+						// the function is recovered by the runtime manager, this guards the plugins
+						defer func() {
+							if p := recover(); p != nil {
+								out, err = invocationResponse(c, n, ofctx.NewFunctionOut(), panicError(ctx, n, p))
+							}
+						}()
+						if !r.inflight.Begin() {
+							return invocationResponse(c, n, ofctx.NewFunctionOut(), ofctx.NewRetryableError(errStopping))
+						}
+						if !limiter.Acquire(c) {
+							r.inflight.End()
+							return invocationResponse(c, n, ofctx.NewFunctionOut(), ofctx.NewHTTPError(http.StatusTooManyRequests, errTooManyEvents))
+						}
+
+						// the slot and the event are held until the function returns, even if it is abandoned on timeout,
+						// they are released right away if the runtime manager fails to be created
+						var rm *runtime.RuntimeManager
+						defer func() {
+							rm.OnFunctionExit(func() {
+								limiter.Release()
+								r.inflight.End()
+							})
+						}()
+						rm = runtime.NewRuntimeManager(ctx, prePlugins, postPlugins)
+						rm.SetTimeout(runtime.GetFunctionTimeout(ctx, rf))
+						rm.FuncContext.SetNativeContext(c)
+						rm.FuncContext.SetEvent(n, in)
+						rm.FunctionRunWrapperWithHooks(rf.GetOpenFunctionFunction())
+
+						return invocationResponse(c, n, rm.FuncOut, rm.FuncContext.GetError())
+					})
+					if funcErr == nil {
+						klog.Infof("registered service invocation handler: %s", input.Uri)
+					}
 				default:
 					return fmt.Errorf("invalid input type: %s", input.GetType())
 				}
@@ -193,6 +365,144 @@ func (r *Runtime) RegisterOpenFunction(
 	}(rf.GetOpenFunctionFunction())
 }
 
+// claimInputs returns the inputs served by the function, which are selected by functions.WithInputs
+// or all the inputs of its context, and fails if a binding or topic is claimed twice, by another
+// function or by the function itself.
+func (r *Runtime) claimInputs(ctx ofctx.RuntimeContext, rf *functions.RegisteredFunction) (map[string]*ofctx.Input, error) {
+	inputs := ctx.GetInputs()
+	if names := rf.GetFunctionInputs(); len(names) > 0 {
+		inputs = map[string]*ofctx.Input{}
+		for _, name := range names {
+			input, ok := ctx.GetInputs()[name]
+			if !ok {
+				return nil, fmt.Errorf("input %s of function %s is not defined", name, rf.GetName())
+			}
+			if _, ok := inputs[name]; ok {
+				return nil, fmt.Errorf("input %s of function %s is selected more than once", name, rf.GetName())
+			}
+			inputs[name] = input
+		}
+	}
+
+	keys := map[string]string{}
+	for name, input := range inputs {
+		var key string
+		switch input.GetType() {
+		case ofctx.OpenFuncBinding:
+			input.Uri = input.ComponentName
+			key = fmt.Sprintf("binding %s", input.Uri)
+		case ofctx.OpenFuncTopic:
+			key = fmt.Sprintf("topic %s of pubsub %s", input.Uri, input.ComponentName)
+		case ofctx.OpenFuncInvoke:
+			key = fmt.Sprintf("method %s", input.Uri)
+		default:
+			return nil, fmt.Errorf("invalid input type: %s", input.GetType())
+		}
+		if owner, ok := r.claims[key]; ok {
+			return nil, fmt.Errorf("%s of input %s is already claimed by function %s", key, name, owner)
+		}
+		if _, ok := keys[key]; ok {
+			return nil, fmt.Errorf("%s of input %s is already claimed by another input of function %s", key, name, rf.GetName())
+		}
+		keys[key] = rf.GetName()
+	}
+	for key, owner := range keys {
+		r.claims[key] = owner
+	}
+	return inputs, nil
+}
+
+func panicError(ctx ofctx.RuntimeContext, input string, recovered interface{}) error {
+	klog.Errorf("panic on handling the event of input %s: %v\n%s", input, recovered, debug.Stack())
+	return ofctx.NewPanicError(recovered, ctx.GetPanicDisposition())
+}
+
+// bindingResponse returns the data of the Out to the binding on success, the errors are returned
+// to the binding except the ones to be dropped, which are acknowledged to avoid the redelivery.
+func bindingResponse(input string, out ofctx.Out, err error) ([]byte, error) {
+	switch ofctx.GetErrorDisposition(err) {
+	case ofctx.DispositionSuccess:
+		if out.GetCode() == ofctx.Success {
+			return out.GetData(), nil
+		}
+		return nil, nil
+	case ofctx.DispositionDrop:
+		if ofctx.IsTypedError(err) {
+			klog.Warningf("dropped the event of input %s: %v", input, err)
+			return nil, nil
+		}
+		return nil, err
+	default:
+		return nil, err
+	}
+}
+
+// invocationResponse returns the data and the content type of the Out to the caller,
+// the caller gets the error of the function since there is no redelivery of the invocation.
+// The status code of the typed error, or the code of the Out, is responded as knative does.
+func invocationResponse(c context.Context, input string, out ofctx.Out, err error) (*dapr.Content, error) {
+	code := out.GetCode()
+	if code == 0 {
+		code = ofctx.Success
+	}
+
+	if err != nil {
+		klog.Warningf("failed to handle the invocation of input %s: %v", input, err)
+		if ofctx.IsTypedError(err) || code < http.StatusBadRequest {
+			code = ofctx.GetErrorStatusCode(err)
+		}
+		// only the message of an error with an explicit status code is meant for the caller
+		msg := http.StatusText(code)
+		if len(out.GetData()) > 0 {
+			msg = string(out.GetData())
+		} else if ofctx.IsTypedError(err) {
+			msg = err.Error()
+		}
+		return nil, invocationError(c, code, msg, err)
+	}
+	if code >= http.StatusBadRequest {
+		msg := http.StatusText(code)
+		if len(out.GetData()) > 0 {
+			msg = string(out.GetData())
+		}
+		return nil, invocationError(c, code, msg, fmt.Errorf("function returned code %d", code))
+	}
+
+	if code != ofctx.Success {
+		setInvocationStatus(c, code)
+	}
+	return &dapr.Content{
+		Data:        out.GetData(),
+		ContentType: out.GetContentType(),
+	}, nil
+}
+
+// topicResponse maps the result of the function to the SUCCESS, RETRY or DROP status of the pubsub,
+// the `retry` metadata of the Out is still respected for the errors not created by ofctx.
+func topicResponse(input string, out ofctx.Out, err error) (retry bool, _ error) {
+	switch ofctx.GetErrorDisposition(err) {
+	case ofctx.DispositionSuccess:
+		return false, nil
+	case ofctx.DispositionRetry:
+		return true, err
+	default:
+		if !ofctx.IsTypedError(err) && strings.EqualFold(out.GetMetadata()["retry"], "true") {
+			return true, err
+		}
+		klog.Warningf("dropped the event of input %s: %v", input, err)
+		return false, err
+	}
+}
+
+func (r *Runtime) RegisterManagementHandler(pattern string, handler http.Handler) {
+	r.management.Handle(pattern, handler)
+}
+
+// GetManagementHandler returns the handler serving the framework-owned endpoints.
+func (r *Runtime) GetManagementHandler() http.Handler {
+	return r.management
+}
+
 func (r *Runtime) Name() ofctx.Runtime {
 	return ofctx.Async
 }
@@ -0,0 +1,195 @@
+package async
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	dapr "github.com/dapr/go-sdk/service/common"
+	"k8s.io/klog/v2"
+
+	ofctx "github.com/OpenFunction/functions-framework-go/context"
+	"github.com/OpenFunction/functions-framework-go/runtime"
+)
+
+const (
+	// the metadata of the events forwarded to the dead letter
+	deadLetterInputKey    = "deadLetterInput"
+	deadLetterErrorKey    = "deadLetterError"
+	deadLetterAttemptsKey = "deadLetterAttempts"
+
+	// the failed attempts of an event are forgotten if it is not redelivered in time,
+	// and the oldest events are forgotten if too many events are failing
+	attemptsTTL     = 10 * time.Minute
+	maxFailedEvents = 10000
+)
+
+// the metadata keys of the binding events carrying a delivery id, which is kept across the redeliveries
+var deliveryIDKeys = []string{"id", "messageid", "message-id", "ce-id", "ce_id"}
+
+// deadLetter forwards the failed events of an input to its dead-letter output,
+// it counts the failed deliveries of the events which are redelivered by Dapr.
+type deadLetter struct {
+	output      string
+	maxAttempts int
+
+	mu       sync.Mutex
+	attempts map[string]*failedEvent
+	pruned   time.Time
+}
+
+type failedEvent struct {
+	attempts int
+	failedAt time.Time
+}
+
+// newDeadLetter returns nil if the input has no dead letter.
+func newDeadLetter(input *ofctx.Input) *deadLetter {
+	if input.DeadLetter == "" {
+		return nil
+	}
+	maxAttempts := input.MaxDeliveryAttempts
+	if maxAttempts < 1 {
+		maxAttempts = 1
+	}
+	return &deadLetter{
+		output:      input.DeadLetter,
+		maxAttempts: maxAttempts,
+		attempts:    map[string]*failedEvent{},
+		pruned:      time.Now(),
+	}
+}
+
+// handle forwards the event to the dead letter if the invocation failed and the event will not be redelivered,
+// or it has failed for the max delivery attempts. It returns true if the event is forwarded and should be acknowledged.
+func (d *deadLetter) handle(c context.Context, rm *runtime.RuntimeManager, input string, key string, redelivered bool) bool {
+	if d == nil {
+		return false
+	}
+
+	err := rm.FuncContext.GetError()
+	if err == nil && rm.FuncOut.GetCode() < ofctx.InternalError {
+		d.reset(key)
+		return false
+	}
+
+	attempts := d.fail(key)
+	if redelivered && attempts < d.maxAttempts {
+		return false
+	}
+	d.reset(key)
+
+	var msg string
+	if err != nil {
+		msg = err.Error()
+	} else {
+		msg = "function returned code " + strconv.Itoa(rm.FuncOut.GetCode())
+	}
+	ie := rm.FuncContext.GetInnerEvent()
+	metadata := map[string]string{}
+	for k, v := range ie.GetMetadata() {
+		metadata[k] = v
+	}
+	metadata[deadLetterInputKey] = input
+	metadata[deadLetterErrorKey] = msg
+	metadata[deadLetterAttemptsKey] = strconv.Itoa(attempts)
+
+	if _, err := rm.FuncContext.GetContext().SendWithContext(c, d.output, ie.GetUserData(), ofctx.SendWithMetadata(metadata)); err != nil {
+		klog.Errorf("failed to forward the event of input %s to dead letter %s: %v", input, d.output, err)
+		return false
+	}
+	klog.Warningf("forwarded the event of input %s to dead letter %s after %d failed attempts: %s", input, d.output, attempts, msg)
+	return true
+}
+
+func (d *deadLetter) fail(key string) int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	now := time.Now()
+	d.prune(now)
+	e, ok := d.attempts[key]
+	if !ok {
+		if len(d.attempts) >= maxFailedEvents {
+			d.evictOldest()
+		}
+		e = &failedEvent{}
+		d.attempts[key] = e
+	}
+	e.attempts++
+	e.failedAt = now
+	return e.attempts
+}
+
+// prune forgets the events which are not redelivered within the ttl, e.g. the events acknowledged
+// by a dead letter of Dapr, it scans the events at most once per ttl.
+func (d *deadLetter) prune(now time.Time) {
+	if now.Sub(d.pruned) < attemptsTTL {
+		return
+	}
+	d.pruned = now
+	for key, e := range d.attempts {
+		if now.Sub(e.failedAt) >= attemptsTTL {
+			delete(d.attempts, key)
+		}
+	}
+}
+
+func (d *deadLetter) evictOldest() {
+	var oldest string
+	var failedAt time.Time
+	for key, e := range d.attempts {
+		if oldest == "" || e.failedAt.Before(failedAt) {
+			oldest, failedAt = key, e.failedAt
+		}
+	}
+	delete(d.attempts, oldest)
+}
+
+func (d *deadLetter) reset(key string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	delete(d.attempts, key)
+}
+
+// topicEventKey identifies the redeliveries of a topic event by its id.
+func topicEventKey(e *dapr.TopicEvent) string {
+	if e.ID != "" {
+		return e.ID
+	}
+	return hashEvent(e.RawData, nil)
+}
+
+// bindingEventKey identifies the redeliveries of a binding event by the delivery id in its metadata.
+// The binding events have no id of their own, so without a delivery id the event is identified by its
+// data and metadata, and the failures can not be counted reliably: the distinct events with the same
+// payload share a counter, and a redelivery with changed metadata, e.g. a retry count, starts a new one.
+func bindingEventKey(e *dapr.BindingEvent) string {
+	for _, key := range deliveryIDKeys {
+		for k, v := range e.Metadata {
+			if v != "" && strings.EqualFold(k, key) {
+				return key + ":" + v
+			}
+		}
+	}
+	return hashEvent(e.Data, e.Metadata)
+}
+
+func hashEvent(data []byte, metadata map[string]string) string {
+	h := sha256.New()
+	h.Write(data)
+	keys := make([]string, 0, len(metadata))
+	for k := range metadata {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		h.Write([]byte(k))
+		h.Write([]byte(metadata[k]))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
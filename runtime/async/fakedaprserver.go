@@ -58,6 +58,9 @@ func (s *FakeServer) Stop() error {
 }
 
 func (s *FakeServer) GracefulStop() error {
+	if s.grpcServer == nil {
+		return s.Stop()
+	}
 	s.grpcServer.GracefulStop()
 	return nil
 }
@@ -0,0 +1,136 @@
+package async
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/gorilla/mux"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// the error info of a failed invocation, as Dapr returns it for a failed app,
+	// the callers get the status code of the function from it
+	errorInfoReason    = "ERR_INVOKE_APP"
+	errorInfoHTTPCode  = "http.code"
+	errorInfoHTTPError = "http.error_message"
+
+	daprHTTPStatusHeader = "dapr-http-status"
+)
+
+// invocationStatus carries the status code of an invocation from the handler to the response
+// of the http service, since the invocation handlers of the Dapr SDK respond only 200 or 500.
+type invocationStatus struct {
+	code int
+}
+
+type invocationStatusKey struct{}
+
+// newInvocationRouter returns the router of the http service responding the status codes of the invocations.
+func newInvocationRouter() *mux.Router {
+	router := mux.NewRouter()
+	router.Use(func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s := &invocationStatus{}
+			sw := &statusWriter{ResponseWriter: w, status: s}
+			next.ServeHTTP(sw, r.WithContext(context.WithValue(r.Context(), invocationStatusKey{}, s)))
+			// the handler writes nothing if the function returns no data
+			if !sw.wroteHeader && s.code != 0 {
+				sw.WriteHeader(s.code)
+			}
+		})
+	})
+	return router
+}
+
+// statusWriter replaces the status code written by the handler with the status code of the invocation.
+type statusWriter struct {
+	http.ResponseWriter
+	status      *invocationStatus
+	wroteHeader bool
+}
+
+func (w *statusWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if w.status.code != 0 {
+			code = w.status.code
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// setInvocationStatus responds the status code of a successful invocation, through the dapr-http-status
+// header of the grpc service, which is where the callers read it.
+func setInvocationStatus(c context.Context, code int) {
+	if s, ok := c.Value(invocationStatusKey{}).(*invocationStatus); ok {
+		s.code = code
+		return
+	}
+	if err := grpc.SetHeader(c, metadata.Pairs(daprHTTPStatusHeader, strconv.Itoa(code))); err != nil {
+		klog.V(4).Infof("failed to set the status code of the invocation: %v", err)
+	}
+}
+
+// invocationError returns the failure of an invocation with its status code, which is the status code of
+// the http service, or the gRPC status with the error info Dapr returns for a failed app.
+func invocationError(c context.Context, code int, msg string, err error) error {
+	if s, ok := c.Value(invocationStatusKey{}).(*invocationStatus); ok {
+		s.code = code
+		return err
+	}
+	st := status.New(grpcCode(code), err.Error())
+	if detailed, detailsErr := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: errorInfoReason,
+		Metadata: map[string]string{
+			errorInfoHTTPCode:  strconv.Itoa(code),
+			errorInfoHTTPError: msg,
+		},
+	}); detailsErr == nil {
+		st = detailed
+	}
+	return st.Err()
+}
+
+// grpcCode maps the http status code to the gRPC code, as grpc-gateway does.
+func grpcCode(code int) codes.Code {
+	switch code {
+	case http.StatusBadRequest:
+		return codes.InvalidArgument
+	case http.StatusUnauthorized:
+		return codes.Unauthenticated
+	case http.StatusForbidden:
+		return codes.PermissionDenied
+	case http.StatusNotFound:
+		return codes.NotFound
+	case http.StatusConflict:
+		return codes.Aborted
+	case http.StatusPreconditionFailed, http.StatusUnprocessableEntity:
+		return codes.FailedPrecondition
+	case http.StatusTooManyRequests:
+		return codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		return codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		return codes.Unavailable
+	case http.StatusGatewayTimeout, http.StatusRequestTimeout:
+		return codes.DeadlineExceeded
+	}
+	if code >= http.StatusInternalServerError {
+		return codes.Internal
+	}
+	return codes.Unknown
+}
@@ -0,0 +1,53 @@
+package runtime
+
+import (
+	"sync"
+)
+
+// InflightTracker counts the invocations being handled, including the functions abandoned on timeout.
+// Unlike a sync.WaitGroup, it is safe to start handling an invocation while the runtime is draining,
+// the invocation is rejected instead.
+type InflightTracker struct {
+	mu      sync.Mutex
+	count   int
+	closed  bool
+	drained chan struct{}
+}
+
+func NewInflightTracker() *InflightTracker {
+	return &InflightTracker{drained: make(chan struct{})}
+}
+
+// Begin counts an invocation, it returns false if the runtime is stopping.
+func (t *InflightTracker) Begin() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	t.count++
+	return true
+}
+
+// End finishes an invocation counted by Begin.
+func (t *InflightTracker) End() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.count--
+	if t.closed && t.count == 0 {
+		close(t.drained)
+	}
+}
+
+// Drain stops counting new invocations, the returned channel is closed when the counted invocations are finished.
+func (t *InflightTracker) Drain() <-chan struct{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if !t.closed {
+		t.closed = true
+		if t.count == 0 {
+			close(t.drained)
+		}
+	}
+	return t.drained
+}
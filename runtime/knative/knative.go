@@ -2,6 +2,7 @@ package knative
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
@@ -9,6 +10,8 @@ import (
 	"strings"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/binding"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
 
 	"github.com/go-chi/chi/v5"
 	"k8s.io/klog/v2"
@@ -31,38 +34,97 @@ type Runtime struct {
 	port    string
 	pattern string
 	handler *chi.Mux
+	server  *http.Server
+	// paths maps the paths to the functions serving them
+	paths map[string]string
+	// inflight counts the invocations until their functions return, the functions abandoned
+	// on timeout outlive the requests waited for by the http server on shutdown
+	inflight *runtime.InflightTracker
 }
 
 func NewKnativeRuntime(port string, pattern string) *Runtime {
 	if pattern == "" {
 		pattern = defaultPattern
 	}
+	handler := chi.NewRouter()
 	return &Runtime{
-		port:    port,
-		pattern: pattern,
-		handler: chi.NewRouter(),
+		port:     port,
+		pattern:  pattern,
+		handler:  handler,
+		paths:    map[string]string{},
+		inflight: runtime.NewInflightTracker(),
+		server: &http.Server{
+			Addr:    fmt.Sprintf(":%s", port),
+			Handler: handler,
+		},
 	}
 }
 
 func (r *Runtime) Start(ctx context.Context) error {
 	klog.Infof("Knative Function serving http: listening on port %s", r.port)
-	klog.Fatal(http.ListenAndServe(fmt.Sprintf(":%s", r.port), r.handler))
+	if err := r.server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
 	return nil
 }
 
+func (r *Runtime) Stop(ctx context.Context) error {
+	klog.Info("Knative Function stopping http server")
+	// the requests arriving from now on are rejected
+	drained := r.inflight.Drain()
+	// Shutdown closes the listener first and then waits for the active requests to complete
+	if err := r.server.Shutdown(ctx); err != nil {
+		return err
+	}
+
+	select {
+	case <-drained:
+		return nil
+	case <-ctx.Done():
+		klog.Warningf("in-flight invocations are not finished in time: %v", ctx.Err())
+		return ctx.Err()
+	}
+}
+
 func (r *Runtime) RegisterOpenFunction(
 	ctx ofctx.RuntimeContext,
 	prePlugins []plugin.Plugin,
 	postPlugins []plugin.Plugin,
 	rf *functions.RegisteredFunction,
 ) error {
-	// Initialize dapr client if FuncContext defined inputs or outputs
-	if ctx.HasInputs() || ctx.HasOutputs() {
+	if err := r.claimPath(rf); err != nil {
+		return err
+	}
+
+	// Initialize dapr client if FuncContext defined any Dapr component
+	if ctx.HasDaprComponents() {
 		ctx.InitDaprClientIfNil()
 	}
 
+	limiter := runtime.NewConcurrencyLimiter(rf)
+	inflight := r.inflight
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		rm := runtime.NewRuntimeManager(ctx, prePlugins, postPlugins)
+		if !inflight.Begin() {
+			writeRejected(w, http.StatusServiceUnavailable)
+			return
+		}
+		if !limiter.Acquire(r.Context()) {
+			inflight.End()
+			writeRejected(w, http.StatusTooManyRequests)
+			return
+		}
+
+		// the slot and the invocation are held until the function returns, even if it is abandoned on timeout,
+		// they are released right away if the runtime manager fails to be created
+		var rm *runtime.RuntimeManager
+		defer func() {
+			rm.OnFunctionExit(func() {
+				limiter.Release()
+				inflight.End()
+			})
+		}()
+		rm = runtime.NewRuntimeManager(ctx, prePlugins, postPlugins)
+		rm.SetTimeout(runtime.GetFunctionTimeout(ctx, rf))
 		// save the Vars into the context
 		_ctx := ofctx.CtxWithVars(r.Context(), ofctx.URLParamsFromCtx(r.Context()))
 		rm.FuncContext.SetNativeContext(_ctx)
@@ -70,19 +132,7 @@ func (r *Runtime) RegisterOpenFunction(
 		defer RecoverPanicHTTP(w, "Function panic")
 		rm.FunctionRunWrapperWithHooks(rf.GetOpenFunctionFunction())
 
-		switch rm.FuncOut.GetCode() {
-		case ofctx.Success:
-			w.Header().Set(functionStatusHeader, successStatus)
-			w.WriteHeader(rm.FuncOut.GetCode())
-			w.Write(rm.FuncOut.GetData())
-			return
-		case ofctx.InternalError:
-			w.Header().Set(functionStatusHeader, errorStatus)
-			w.WriteHeader(rm.FuncOut.GetCode())
-			return
-		default:
-			return
-		}
+		writeOpenFunctionResponse(w, rm.FuncOut, rm.FuncContext.GetError())
 	}
 
 	methods := rf.GetFunctionMethods()
@@ -105,14 +155,52 @@ func (r *Runtime) RegisterHTTPFunction(
 	postPlugins []plugin.Plugin,
 	rf *functions.RegisteredFunction,
 ) error {
+	if err := r.claimPath(rf); err != nil {
+		return err
+	}
+
+	limiter := runtime.NewConcurrencyLimiter(rf)
+	inflight := r.inflight
 	fn := func(w http.ResponseWriter, r *http.Request) {
-		rm := runtime.NewRuntimeManager(ctx, prePlugins, postPlugins)
+		if !inflight.Begin() {
+			writeRejected(w, http.StatusServiceUnavailable)
+			return
+		}
+		if !limiter.Acquire(r.Context()) {
+			inflight.End()
+			writeRejected(w, http.StatusTooManyRequests)
+			return
+		}
+
+		// the slot and the invocation are held until the function returns, even if it is abandoned on timeout,
+		// they are released right away if the runtime manager fails to be created
+		var rm *runtime.RuntimeManager
+		defer func() {
+			rm.OnFunctionExit(func() {
+				limiter.Release()
+				inflight.End()
+			})
+		}()
+		rm = runtime.NewRuntimeManager(ctx, prePlugins, postPlugins)
+		rm.SetTimeout(runtime.GetFunctionTimeout(ctx, rf))
 		// save the Vars into the context
 		_ctx := ofctx.CtxWithVars(r.Context(), ofctx.URLParamsFromCtx(r.Context()))
 		rm.FuncContext.SetNativeContext(_ctx)
-		rm.FuncContext.SetSyncRequest(w, r.WithContext(_ctx))
+		rww := ofctx.NewResponseWriterWrapper(w, http.StatusOK)
+		rm.FuncContext.SetSyncRequest(rww, r.WithContext(_ctx))
 		defer RecoverPanicHTTP(w, "Function panic")
 		rm.FunctionRunWrapperWithHooks(rf.GetHTTPFunction())
+
+		// respond the recovered panic or the timeout unless the function has started the response
+		if err := rm.FuncContext.GetError(); ofctx.IsTypedError(err) && !rww.Written() {
+			if errors.Is(err, ofctx.ErrFunctionPanic) {
+				w.Header().Set(functionStatusHeader, crashStatus)
+			} else {
+				w.Header().Set(functionStatusHeader, errorStatus)
+			}
+			w.WriteHeader(ofctx.GetErrorStatusCode(err))
+			fmt.Fprint(w, err.Error())
+		}
 	}
 
 	methods := rf.GetFunctionMethods()
@@ -135,19 +223,61 @@ func (r *Runtime) RegisterCloudEventFunction(
 	postPlugins []plugin.Plugin,
 	rf *functions.RegisteredFunction,
 ) error {
+	if err := r.claimPath(rf); err != nil {
+		return err
+	}
+
 	p, err := cloudevents.NewHTTP()
 	if err != nil {
 		klog.Errorf("failed to create protocol: %v\n", err)
 		return err
 	}
 
-	handleFn, err := cloudevents.NewHTTPReceiveHandler(ctx, p, func(ctx context.Context, ce cloudevents.Event) error {
-		rm := runtime.NewRuntimeManager(funcContext, prePlugins, postPlugins)
+	limiter := runtime.NewConcurrencyLimiter(rf)
+	inflight := r.inflight
+	handleFn, err := cloudevents.NewHTTPReceiveHandler(ctx, p, func(ctx context.Context, ce cloudevents.Event) (*cloudevents.Event, cloudevents.Result) {
+		if !inflight.Begin() {
+			return nil, cehttp.NewResult(http.StatusServiceUnavailable, "the runtime is stopping")
+		}
+		if !limiter.Acquire(ctx) {
+			inflight.End()
+			return nil, cehttp.NewResult(http.StatusTooManyRequests, "too many concurrent requests")
+		}
+
+		// the reply function runs as a CloudEvent function, so that the plugins see the same signature
+		var reply *cloudevents.Event
+		fn := rf.GetCloudEventFunction()
+		if replyFn := rf.GetCloudEventReplyFunction(); replyFn != nil {
+			fn = func(ctx context.Context, ce cloudevents.Event) error {
+				var err error
+				reply, err = replyFn(ctx, ce)
+				return err
+			}
+		}
+
+		// the slot and the invocation are held until the function returns, even if it is abandoned on timeout,
+		// they are released right away if the runtime manager fails to be created
+		var rm *runtime.RuntimeManager
+		defer func() {
+			rm.OnFunctionExit(func() {
+				limiter.Release()
+				inflight.End()
+			})
+		}()
+		rm = runtime.NewRuntimeManager(funcContext, prePlugins, postPlugins)
+		rm.SetTimeout(runtime.GetFunctionTimeout(funcContext, rf))
 		// save the native ctx
 		rm.FuncContext.SetNativeContext(ctx)
 		rm.FuncContext.SetEvent("", &ce)
-		rm.FunctionRunWrapperWithHooks(rf.GetCloudEventFunction())
-		return rm.FuncContext.GetError()
+		rm.FunctionRunWrapperWithHooks(fn)
+		// the receiver responds with the status code of a http result
+		if err := rm.FuncContext.GetError(); ofctx.IsTypedError(err) {
+			return nil, cehttp.NewResult(ofctx.GetErrorStatusCode(err), "%s", err.Error())
+		} else if err != nil {
+			return nil, err
+		}
+		// the reply is read only on success, the function abandoned on timeout may still be running
+		return reply, nil
 	})
 
 	if err != nil {
@@ -159,6 +289,10 @@ func (r *Runtime) RegisterCloudEventFunction(
 	withVars := func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			_ctx := ofctx.CtxWithVars(r.Context(), ofctx.URLParamsFromCtx(r.Context()))
+			// the reply event is written in the content mode of the request, which is binary by default
+			if cehttp.NewMessageFromHttpRequest(r).ReadEncoding() == binding.EncodingStructured {
+				_ctx = binding.WithForceStructured(_ctx)
+			}
 			next.ServeHTTP(w, r.WithContext(_ctx))
 		})
 	}
@@ -166,6 +300,21 @@ func (r *Runtime) RegisterCloudEventFunction(
 	return nil
 }
 
+// claimPath fails if the path of the function is served by another function. The registry does not check
+// the paths of the functions serving selected inputs, since the async runtime routes them by the inputs.
+func (r *Runtime) claimPath(rf *functions.RegisteredFunction) error {
+	path := rf.GetPath()
+	if name, ok := r.paths[path]; ok {
+		return fmt.Errorf("function path already registered: %s by function %s", path, name)
+	}
+	r.paths[path] = rf.GetName()
+	return nil
+}
+
+func (r *Runtime) RegisterManagementHandler(pattern string, handler http.Handler) {
+	r.handler.Handle(pattern, handler)
+}
+
 func (r *Runtime) Name() ofctx.Runtime {
 	return ofctx.Knative
 }
@@ -174,6 +323,73 @@ func (r *Runtime) GetHandler() interface{} {
 	return r.handler
 }
 
+// writeOpenFunctionResponse responds with the status code of the typed error returned by the function,
+// or with the code of the Out, the errors without a status code are responded with 500.
+func writeOpenFunctionResponse(w http.ResponseWriter, out ofctx.Out, err error) {
+	code := out.GetCode()
+	if code == 0 {
+		code = ofctx.Success
+	}
+
+	for key, values := range out.GetHeader() {
+		for _, value := range values {
+			w.Header().Add(key, value)
+		}
+	}
+	if out.GetContentType() != "" {
+		w.Header().Set("Content-Type", out.GetContentType())
+	}
+
+	if err != nil {
+		if ofctx.IsTypedError(err) || code < http.StatusBadRequest {
+			code = ofctx.GetErrorStatusCode(err)
+		}
+		if errors.Is(err, ofctx.ErrFunctionPanic) {
+			w.Header().Set(functionStatusHeader, crashStatus)
+		} else {
+			w.Header().Set(functionStatusHeader, errorStatus)
+		}
+		w.WriteHeader(code)
+		// only the message of an error with an explicit status code is meant for the caller,
+		// unless the function returns its own body
+		if len(out.GetData()) > 0 && bodyAllowed(code) {
+			w.Write(out.GetData())
+		} else if ofctx.IsTypedError(err) && bodyAllowed(code) {
+			fmt.Fprint(w, err.Error())
+		}
+		return
+	}
+
+	if code >= http.StatusBadRequest {
+		w.Header().Set(functionStatusHeader, errorStatus)
+	} else {
+		w.Header().Set(functionStatusHeader, successStatus)
+	}
+	w.WriteHeader(code)
+	if bodyAllowed(code) {
+		w.Write(out.GetData())
+	}
+}
+
+// bodyAllowed reports whether a response with the status code may carry a body, see RFC 7230, section 3.3.
+func bodyAllowed(code int) bool {
+	switch {
+	case code >= 100 && code <= 199:
+		return false
+	case code == http.StatusNoContent, code == http.StatusNotModified:
+		return false
+	}
+	return true
+}
+
+// writeRejected rejects a request exceeding the max concurrency of the function,
+// or arriving while the runtime is stopping.
+func writeRejected(w http.ResponseWriter, code int) {
+	w.Header().Set(functionStatusHeader, errorStatus)
+	w.WriteHeader(code)
+	fmt.Fprint(w, http.StatusText(code))
+}
+
 func RecoverPanicHTTP(w http.ResponseWriter, msg string) {
 	if r := recover(); r != nil {
 		writeHTTPErrorResponse(w, http.StatusInternalServerError, crashStatus, fmt.Sprintf("%s: %v\n\n%s", msg, r, debug.Stack()))
@@ -0,0 +1,57 @@
+package runtime
+
+import (
+	"context"
+
+	"github.com/OpenFunction/functions-framework-go/internal/functions"
+)
+
+// ConcurrencyLimiter bounds the concurrent invocations of a function set by functions.WithMaxConcurrency,
+// a nil limiter does not limit the invocations.
+type ConcurrencyLimiter struct {
+	slots  chan struct{}
+	policy functions.ConcurrencyPolicy
+}
+
+// NewConcurrencyLimiter returns nil if the max concurrency of the function is not set.
+func NewConcurrencyLimiter(rf *functions.RegisteredFunction) *ConcurrencyLimiter {
+	if rf == nil || rf.GetMaxConcurrency() <= 0 {
+		return nil
+	}
+	return &ConcurrencyLimiter{
+		slots:  make(chan struct{}, rf.GetMaxConcurrency()),
+		policy: rf.GetConcurrencyPolicy(),
+	}
+}
+
+// Acquire reserves a slot for an invocation, it returns false if the invocation is rejected,
+// or ctx is done before a slot is released when the invocations are queued.
+func (l *ConcurrencyLimiter) Acquire(ctx context.Context) bool {
+	if l == nil {
+		return true
+	}
+
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	default:
+	}
+
+	if l.policy != functions.ConcurrencyPolicyWait {
+		return false
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// Release releases the slot of a finished invocation.
+func (l *ConcurrencyLimiter) Release() {
+	if l == nil {
+		return
+	}
+	<-l.slots
+}
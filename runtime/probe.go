@@ -0,0 +1,89 @@
+package runtime
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+const (
+	HealthzPath = "/healthz"
+	ReadyzPath  = "/readyz"
+)
+
+// ReadinessCheck returns an error when the function is not able to serve requests yet.
+type ReadinessCheck func(ctx context.Context) error
+
+// Probe holds the state behind the framework-owned health and readiness endpoints.
+type Probe struct {
+	mu     sync.RWMutex
+	ready  bool
+	names  []string
+	checks map[string]ReadinessCheck
+}
+
+func NewProbe() *Probe {
+	return &Probe{
+		checks: map[string]ReadinessCheck{},
+	}
+}
+
+// SetReady marks whether the functions have been registered and the runtime is able to serve.
+func (p *Probe) SetReady(ready bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.ready = ready
+}
+
+// AddReadinessCheck adds a check that has to pass before the function is reported as ready,
+// a check with the same name will be replaced.
+func (p *Probe) AddReadinessCheck(name string, check ReadinessCheck) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.checks[name]; !ok {
+		p.names = append(p.names, name)
+	}
+	p.checks[name] = check
+}
+
+// Ready runs the readiness checks in the order they were added and returns the first failure.
+func (p *Probe) Ready(ctx context.Context) error {
+	p.mu.RLock()
+	ready := p.ready
+	names := append([]string{}, p.names...)
+	checks := make(map[string]ReadinessCheck, len(p.checks))
+	for name, check := range p.checks {
+		checks[name] = check
+	}
+	p.mu.RUnlock()
+
+	if !ready {
+		return fmt.Errorf("functions are not registered")
+	}
+	for _, name := range names {
+		if err := checks[name](ctx); err != nil {
+			return fmt.Errorf("readiness check %s failed: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// ServeHealthz reports that the process is alive, it never runs the user function.
+func (p *Probe) ServeHealthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "ok")
+}
+
+// ServeReadyz reports whether the function is ready to serve requests.
+func (p *Probe) ServeReadyz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := p.Ready(r.Context()); err != nil {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		fmt.Fprint(w, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprint(w, "ok")
+}
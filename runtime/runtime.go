@@ -4,6 +4,8 @@ import (
 	"context"
 	"io/ioutil"
 	"net/http"
+	"runtime/debug"
+	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/cloudevents/sdk-go/v2/binding"
@@ -17,6 +19,9 @@ import (
 
 type Interface interface {
 	Start(ctx context.Context) error
+	// Stop stops accepting new invocations and waits for the in-flight ones
+	// to finish until ctx is done.
+	Stop(ctx context.Context) error
 	RegisterHTTPFunction(
 		ctx ofctx.RuntimeContext,
 		prePlugins []plugin.Plugin,
@@ -36,6 +41,9 @@ type Interface interface {
 		postPlugins []plugin.Plugin,
 		rf *functions.RegisteredFunction,
 	) error
+	// RegisterManagementHandler registers a framework-owned endpoint, such as the health probes,
+	// which is served apart from the user functions.
+	RegisterManagementHandler(pattern string, handler http.Handler)
 	Name() ofctx.Runtime
 	GetHandler() interface{}
 }
@@ -46,8 +54,15 @@ type RuntimeManager struct {
 	prePlugins  []plugin.Plugin
 	postPlugins []plugin.Plugin
 	pluginState map[string]plugin.Plugin
+	timeout     time.Duration
+	// exited is closed when the function called with a timeout returns
+	exited chan struct{}
 }
 
+// maxTimeoutGracePeriod bounds the time to wait for a timed out function to return,
+// the function is waited for as long as its timeout otherwise.
+const maxTimeoutGracePeriod = 30 * time.Second
+
 func NewRuntimeManager(funcContext ofctx.RuntimeContext, prePlugin []plugin.Plugin, postPlugin []plugin.Plugin) *RuntimeManager {
 	ctx := ofctx.CloneRuntimeContext(funcContext)
 	rm := &RuntimeManager{
@@ -112,8 +127,20 @@ func (rm *RuntimeManager) ProcessPostHooks() {
 func (rm *RuntimeManager) FunctionRunWrapperWithHooks(fn interface{}) {
 	functionContext := rm.FuncContext.GetContext()
 
+	defer rm.OnFunctionExit(rm.FuncContext.TrackInFlight())
+
 	rm.ProcessPreHooks()
 
+	// the deadline is kept until the post hooks are done, so that they can see the timeout
+	if rm.timeout > 0 {
+		ctx, cancel := context.WithTimeout(rm.FuncContext.GetNativeContext(), rm.timeout)
+		defer cancel()
+		rm.FuncContext.SetNativeContext(ctx)
+		if sr := rm.FuncContext.GetSyncRequest(); sr.Request != nil {
+			sr.Request = sr.Request.WithContext(ctx)
+		}
+	}
+
 	if function, ok := fn.(func(http.ResponseWriter, *http.Request)); ok {
 
 		// get the sync request
@@ -122,20 +149,27 @@ func (rm *RuntimeManager) FunctionRunWrapperWithHooks(fn interface{}) {
 		// wrap the response writer
 		rww := ofctx.NewResponseWriterWrapper(sr.ResponseWriter, 200)
 
-		function(rww, sr.Request)
-		rm.FuncContext.WithOut(rm.FuncOut.WithCode(rww.Status()))
+		// the function owns the response writer, so it is never abandoned on timeout
+		if out, err := rm.recoverFunction(func() (ofctx.Out, error) {
+			function(rww, sr.Request)
+			return nil, nil
+		}); err != nil {
+			rm.setResult(out, err)
+		} else if ctxErr := sr.Request.Context().Err(); rm.timeout > 0 && ctxErr == context.DeadlineExceeded && !rww.Written() {
+			rm.setResult(ofctx.NewFunctionOut().WithCode(http.StatusGatewayTimeout), ofctx.NewTimeoutError(rm.timeout))
+		} else {
+			rm.FuncContext.WithOut(rm.FuncOut.WithCode(rww.Status()))
+		}
 
 	} else if function, ok := fn.(func(ofctx.Context, []byte) (ofctx.Out, error)); ok {
-		if rm.FuncContext.GetBindingEvent() != nil || rm.FuncContext.GetTopicEvent() != nil {
+		if rm.FuncContext.GetBindingEvent() != nil || rm.FuncContext.GetTopicEvent() != nil || rm.FuncContext.GetInvocationEvent() != nil {
 			// get the user data from inner event
 			userData := rm.FuncContext.GetInnerEvent().GetUserData()
 
 			// pass user data to user function
-			out, err := function(functionContext, userData)
-
-			rm.FuncContext.WithOut(out.GetOut())
-			rm.FuncContext.WithError(err)
-			rm.FuncOut = rm.FuncContext.GetOut()
+			rm.setResult(rm.callFunction(func() (ofctx.Out, error) {
+				return function(functionContext, userData)
+			}))
 		} else if rm.FuncContext.GetSyncRequest().Request != nil {
 			var body []byte
 			// if it is a cloud event, we extract the cloudevent data as user data, and pass the raw cloud event in ctx
@@ -153,19 +187,143 @@ func (rm *RuntimeManager) FunctionRunWrapperWithHooks(fn interface{}) {
 				// have to reset the cloudevent here other wise a http call can get the cloud event of the last cloud event call from ctx
 				rm.FuncContext.SetEvent("", &ce)
 			}
-			out, err := function(functionContext, body)
-			rm.FuncContext.WithOut(out.GetOut())
-			rm.FuncContext.WithError(err)
 			// overwrite the result
-			rm.FuncOut = rm.FuncContext.GetOut()
+			rm.setResult(rm.callFunction(func() (ofctx.Out, error) {
+				return function(functionContext, body)
+			}))
 		}
 	} else if function, ok := fn.(func(context.Context, cloudevents.Event) error); ok {
 		ce := cloudevents.Event{}
 		if rm.FuncContext.GetCloudEvent() != nil {
 			ce = *rm.FuncContext.GetCloudEvent()
 		}
-		rm.FuncContext.WithError(function(rm.FuncContext.GetNativeContext(), ce))
+		nativeContext := rm.FuncContext.GetNativeContext()
+		out, err := rm.callFunction(func() (ofctx.Out, error) {
+			return nil, function(nativeContext, ce)
+		})
+		if out != nil {
+			rm.setResult(out, err)
+		} else {
+			rm.FuncContext.WithError(err)
+		}
 	}
 
 	rm.ProcessPostHooks()
 }
+
+// SetTimeout bounds the execution time of the function, see GetFunctionTimeout.
+func (rm *RuntimeManager) SetTimeout(timeout time.Duration) {
+	rm.timeout = timeout
+}
+
+// GetFunctionTimeout returns the timeout set by functions.WithTimeout,
+// or the default timeout of the function context.
+func GetFunctionTimeout(ctx ofctx.RuntimeContext, rf *functions.RegisteredFunction) time.Duration {
+	if rf != nil && rf.GetTimeout() > 0 {
+		return rf.GetTimeout()
+	}
+	return ctx.GetTimeout()
+}
+
+// callFunction calls the user function with the deadline of the invocation. When the deadline is exceeded,
+// the native context of the function is cancelled and the function is given a grace period to return,
+// so that its work is stopped before the invocation fails with a timeout. A function which ignores
+// the context and does not return within the grace period is abandoned, see OnFunctionExit.
+func (rm *RuntimeManager) callFunction(call func() (ofctx.Out, error)) (ofctx.Out, error) {
+	if rm.timeout <= 0 {
+		return rm.recoverFunction(call)
+	}
+
+	type result struct {
+		out ofctx.Out
+		err error
+	}
+	done := make(chan result, 1)
+	exited := make(chan struct{})
+	rm.exited = exited
+	go func() {
+		defer close(exited)
+		out, err := rm.recoverFunction(call)
+		done <- result{out, err}
+	}()
+
+	ctx := rm.FuncContext.GetNativeContext()
+	select {
+	case r := <-done:
+		// the function gives up by returning the error of the context
+		if r.err != nil && ctx.Err() == context.DeadlineExceeded && !ofctx.IsTypedError(r.err) {
+			return ofctx.NewFunctionOut().WithCode(http.StatusGatewayTimeout), ofctx.NewTimeoutError(rm.timeout)
+		}
+		return r.out, r.err
+	case <-ctx.Done():
+	}
+
+	grace := rm.timeout
+	if grace > maxTimeoutGracePeriod {
+		grace = maxTimeoutGracePeriod
+	}
+	timer := time.NewTimer(grace)
+	defer timer.Stop()
+
+	select {
+	case r := <-done:
+		if ctx.Err() != context.DeadlineExceeded || ofctx.IsTypedError(r.err) {
+			return r.out, r.err
+		}
+		klog.Warningf("function %s timed out after %s", rm.FuncContext.GetName(), rm.timeout)
+	case <-timer.C:
+		klog.Errorf("function %s did not return within %s after it was cancelled, it is abandoned", rm.FuncContext.GetName(), grace)
+	}
+	if ctx.Err() == context.DeadlineExceeded {
+		return ofctx.NewFunctionOut().WithCode(http.StatusGatewayTimeout), ofctx.NewTimeoutError(rm.timeout)
+	}
+	return ofctx.NewFunctionOut().WithCode(ofctx.InternalError), ctx.Err()
+}
+
+// OnFunctionExit calls fn once the function has returned, which is after the invocation
+// if the function is abandoned on timeout, e.g. to release the resources held by the function.
+// A nil RuntimeManager calls fn right away, since no function has been called.
+func (rm *RuntimeManager) OnFunctionExit(fn func()) {
+	if rm == nil || rm.exited == nil {
+		fn()
+		return
+	}
+	select {
+	case <-rm.exited:
+		fn()
+	default:
+		exited := rm.exited
+		go func() {
+			<-exited
+			fn()
+		}()
+	}
+}
+
+// recoverFunction calls the user function and recovers it from a panic, which becomes an InternalError
+// so that the post hooks are still executed.
+func (rm *RuntimeManager) recoverFunction(call func() (ofctx.Out, error)) (out ofctx.Out, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			klog.Errorf("function %s panic: %v\n%s", rm.FuncContext.GetName(), r, debug.Stack())
+			out = ofctx.NewFunctionOut().WithCode(ofctx.InternalError)
+			err = ofctx.NewPanicError(r, rm.FuncContext.GetPanicDisposition())
+		}
+	}()
+	return call()
+}
+
+// setResult saves the result of the function, a nil Out is replaced by an empty one
+// with the code derived from the error.
+func (rm *RuntimeManager) setResult(out ofctx.Out, err error) {
+	if out == nil || out.GetOut() == nil {
+		if err != nil {
+			out = ofctx.NewFunctionOut().WithCode(ofctx.InternalError)
+		} else {
+			out = ofctx.NewFunctionOut().WithCode(ofctx.Success)
+		}
+	}
+	rm.FuncContext.WithOut(out.GetOut())
+	rm.FuncContext.WithError(err)
+	rm.FuncOut = rm.FuncContext.GetOut()
+}